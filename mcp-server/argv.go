@@ -0,0 +1,198 @@
+package mcpserver
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// argCheck validates one value in a script's argument vector
+type argCheck func(string) error
+
+var (
+	domainPattern = regexp.MustCompile(`^(\*\.)?[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9])?(\.[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9])?)*\.?$`)
+	namePattern   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+)
+
+func checkDomain(v string) error {
+	if len(v) > 253 || !domainPattern.MatchString(v) {
+		return fmt.Errorf("invalid domain %q", v)
+	}
+	return nil
+}
+
+func checkDomains(v string) error {
+	domains := strings.Fields(strings.ReplaceAll(v, ",", " "))
+	if len(domains) == 0 {
+		return fmt.Errorf("empty domain list")
+	}
+	for _, d := range domains {
+		if err := checkDomain(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkName(v string) error {
+	if len(v) > 128 || !namePattern.MatchString(v) {
+		return fmt.Errorf("invalid name %q", v)
+	}
+	return nil
+}
+
+func checkPort(v string) error {
+	if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", v)
+	}
+	return nil
+}
+
+func checkPath(v string) error {
+	if !filepath.IsAbs(v) || filepath.Clean(v) != v || strings.ContainsAny(v, "\x00\n\r") {
+		return fmt.Errorf("invalid path %q: must be absolute and clean", v)
+	}
+	return nil
+}
+
+func checkOneOf(values ...string) argCheck {
+	return func(v string) error {
+		for _, ok := range values {
+			if v == ok {
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid value %q, expected one of %s", v, strings.Join(values, ", "))
+	}
+}
+
+// argvRule is the argument grammar a tool's script may be run with: an
+// optional subcommand, then flags and positional arguments in any order.
+// A flag maps to the check for its value, or nil if it takes none.
+type argvRule struct {
+	Script     string
+	Subcommand string
+	Flags      map[string]argCheck
+	Positional []argCheck
+}
+
+// argvRules allowlists every command the tools run. It mirrors the
+// builders in commands.go; the privileged helper runs nothing else.
+var argvRules = map[string]argvRule{
+	"a2sitemgr": {
+		Script: "a2sitemgr",
+		Flags: map[string]argCheck{
+			"-d": checkDomain, "-m": checkOneOf("proxypass", "swc"), "-r": checkName, "-p": checkPort,
+			"-ni": nil, "-s": nil, "--setInitDNSRecords": nil, "-o": nil, "-v": nil,
+		},
+	},
+	"fqdnmgr_check": {
+		Script: "fqdnmgr", Subcommand: "check",
+		Flags:      map[string]argCheck{"-ni": nil, "-v": nil},
+		Positional: []argCheck{checkDomain, checkName},
+	},
+	"fqdnmgr_purchase": {
+		Script: "fqdnmgr", Subcommand: "purchase",
+		Flags:      map[string]argCheck{"-ni": nil, "-v": nil},
+		Positional: []argCheck{checkDomain, checkName},
+	},
+	"fqdnmgr_list": {
+		Script: "fqdnmgr", Subcommand: "list",
+		Flags:      map[string]argCheck{"-ni": nil, "-v": nil},
+		Positional: []argCheck{checkName, checkName},
+	},
+	"list_domains": {
+		Script: "fqdnmgr", Subcommand: "list",
+		Flags: map[string]argCheck{"-l": nil, "-ni": nil},
+	},
+	"fqdnmgr_setInitDNSRecords": {
+		Script: "fqdnmgr", Subcommand: "setInitDNSRecords",
+		Flags: map[string]argCheck{"-d": checkDomains, "-r": checkName, "-ni": nil, "-o": nil, "-v": nil},
+	},
+	"fqdnmgr_checkInitDns": {
+		Script: "fqdnmgr", Subcommand: "checkInitDns",
+		Flags:      map[string]argCheck{"-ni": nil, "-v": nil},
+		Positional: []argCheck{checkDomain},
+	},
+	"fqdncredmgr_delete": {
+		Script: "fqdncredmgr", Subcommand: "delete",
+		Flags:      map[string]argCheck{"-v": nil},
+		Positional: []argCheck{checkName},
+	},
+	"fqdncredmgr_list": {
+		Script: "fqdncredmgr", Subcommand: "list",
+		Flags: map[string]argCheck{"-v": nil},
+	},
+	"a2wcrecalc": {
+		Script:     "a2wcrecalc",
+		Positional: []argCheck{checkDomain},
+	},
+	"a2wcrecalc_dms": {
+		Script:     "a2wcrecalc-dms",
+		Positional: []argCheck{checkPath},
+	},
+	"a2certrenew": {
+		Script: "a2certrenew",
+	},
+}
+
+// validateArgv checks that a tool runs its own script with arguments its
+// grammar allows. Values never start with "-", so they cannot be taken
+// for options.
+func validateArgv(tool, name string, args []string) error {
+	rule, ok := argvRules[tool]
+	if def := definedTool(tool); !ok && def != nil {
+		rule, ok = def.rule, true
+	}
+	if !ok {
+		return fmt.Errorf("tool %q is not allowed to run commands", tool)
+	}
+	if name != rule.Script {
+		return fmt.Errorf("tool %s runs %s, not %q", tool, rule.Script, name)
+	}
+
+	if rule.Subcommand != "" {
+		if len(args) == 0 || args[0] != rule.Subcommand {
+			return fmt.Errorf("%s must start with the %s subcommand", tool, rule.Subcommand)
+		}
+		args = args[1:]
+	}
+
+	positional := 0
+	seen := map[string]bool{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "-") {
+			check, ok := rule.Flags[arg]
+			if !ok {
+				return fmt.Errorf("%s: option %q is not allowed", tool, arg)
+			}
+			if seen[arg] {
+				return fmt.Errorf("%s: option %s given twice", tool, arg)
+			}
+			seen[arg] = true
+			if check == nil {
+				continue
+			}
+			i++
+			if i == len(args) || strings.HasPrefix(args[i], "-") {
+				return fmt.Errorf("%s: option %s needs a value", tool, arg)
+			}
+			if err := check(args[i]); err != nil {
+				return fmt.Errorf("%s: %s: %v", tool, arg, err)
+			}
+			continue
+		}
+
+		if positional == len(rule.Positional) {
+			return fmt.Errorf("%s: unexpected argument %q", tool, arg)
+		}
+		if err := rule.Positional[positional](arg); err != nil {
+			return fmt.Errorf("%s: %v", tool, err)
+		}
+		positional++
+	}
+	return nil
+}
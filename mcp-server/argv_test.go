@@ -0,0 +1,56 @@
+package mcpserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateArgv(t *testing.T) {
+	tests := []struct {
+		tool    string
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"a2sitemgr", "a2sitemgr", []string{"-d", "example.com", "-ni"}, ""},
+		{"a2sitemgr", "a2sitemgr", []string{"-d", "example.com", "-m", "proxypass", "-p", "8080", "-v"}, ""},
+		{"a2sitemgr", "a2sitemgr", []string{"-ni", "-d", "example.com"}, ""},
+		{"fqdnmgr_check", "fqdnmgr", []string{"check", "example.com", "-ni"}, ""},
+		{"fqdnmgr_check", "fqdnmgr", []string{"check", "example.com", "namecheap.com", "-ni"}, ""},
+		{"fqdnmgr_list", "fqdnmgr", []string{"list", "-ni", "namecheap.com", "remote", "-v"}, ""},
+		{"list_domains", "fqdnmgr", []string{"list", "-l", "-ni"}, ""},
+		{"fqdnmgr_setInitDNSRecords", "fqdnmgr", []string{"setInitDNSRecords", "-d", "example.com,example.org", "-ni"}, ""},
+		{"fqdncredmgr_list", "fqdncredmgr", []string{"list"}, ""},
+		{"a2wcrecalc_dms", "a2wcrecalc-dms", []string{"/opt/compose/docker-mailserver"}, ""},
+		{"a2certrenew", "a2certrenew", nil, ""},
+
+		{"rm", "rm", []string{"-rf", "/"}, "not allowed to run commands"},
+		{"a2sitemgr", "fqdnmgr", []string{"-d", "example.com"}, "runs a2sitemgr"},
+		{"fqdnmgr_check", "fqdnmgr", []string{"purchase", "example.com"}, "check subcommand"},
+		{"fqdnmgr_check", "fqdnmgr", nil, "check subcommand"},
+		{"a2sitemgr", "a2sitemgr", []string{"-d", "example.com", "-x"}, `option "-x" is not allowed`},
+		{"a2sitemgr", "a2sitemgr", []string{"-d", "example.com", "-d", "example.org"}, "given twice"},
+		{"a2sitemgr", "a2sitemgr", []string{"-d"}, "needs a value"},
+		{"a2sitemgr", "a2sitemgr", []string{"-d", "-ni"}, "needs a value"},
+		{"a2sitemgr", "a2sitemgr", []string{"-d", "example.com;reboot"}, "-d:"},
+		{"a2sitemgr", "a2sitemgr", []string{"-m", "rewrite"}, "-m:"},
+		{"a2sitemgr", "a2sitemgr", []string{"-p", "99999"}, "-p:"},
+		{"fqdnmgr_list", "fqdnmgr", []string{"list", "-l", "-ni"}, `option "-l" is not allowed`},
+		{"list_domains", "fqdnmgr", []string{"list", "-l", "-ni", "remote"}, "unexpected argument"},
+		{"fqdnmgr_checkInitDns", "fqdnmgr", []string{"checkInitDns", "example.com", "extra"}, "unexpected argument"},
+		{"a2certrenew", "a2certrenew", []string{"example.com"}, "unexpected argument"},
+		{"a2wcrecalc_dms", "a2wcrecalc-dms", []string{"relative/dir"}, "a2wcrecalc_dms:"},
+	}
+
+	for _, tt := range tests {
+		err := validateArgv(tt.tool, tt.name, tt.args)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("validateArgv(%s, %s, %q): %v", tt.tool, tt.name, tt.args, err)
+		case tt.wantErr != "" && err == nil:
+			t.Errorf("validateArgv(%s, %s, %q) succeeded, want an error containing %q", tt.tool, tt.name, tt.args, tt.wantErr)
+		case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+			t.Errorf("validateArgv(%s, %s, %q) = %v, want an error containing %q", tt.tool, tt.name, tt.args, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package mcpserver
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Directories the Apache tools write vhost configs to
+const (
+	ApacheSitesAvailable = "/etc/apache2/sites-available"
+	ApacheSitesEnabled   = "/etc/apache2/sites-enabled"
+)
+
+// Snapshot limits. Watched paths are config directories; anything beyond
+// these is noted in the job output instead of being diffed.
+const (
+	maxSnapshotFiles    = 2000
+	maxSnapshotFileSize = 1 << 20
+	maxSnapshotBytes    = 64 << 20
+	// maxArtifactDiff caps the diff kept per changed file
+	maxArtifactDiff = 256 << 10
+)
+
+// MaxStatusDiff caps the diffs check_job_status shows inline; longer ones
+// are only listed and served as the job's artifacts resource
+const MaxStatusDiff = 8 << 10
+
+// DefaultWatchPaths lists the files and directories each tool may change.
+// They are snapshotted before and after every job of the tool so its
+// result shows exactly what it wrote.
+var DefaultWatchPaths = map[string]func(args map[string]any) []string{
+	"a2sitemgr":   apacheSitePaths,
+	"a2wcrecalc":  apacheSitePaths,
+	"a2certrenew": apacheSitePaths,
+	"a2wcrecalc_dms": func(args map[string]any) []string {
+		dir := getString(args, "dmsDir", "")
+		if dir == "" {
+			dir = config().DMSDir
+		}
+		confDir := filepath.Join(dir, "docker-data", "dms", "config")
+		return []string{
+			filepath.Join(confDir, "sni_cert_map"),
+			filepath.Join(confDir, "99-sni.conf"),
+		}
+	},
+}
+
+func apacheSitePaths(args map[string]any) []string {
+	return []string{ApacheSitesAvailable, ApacheSitesEnabled}
+}
+
+// extraWatchPaths holds paths added with -watch, per tool
+var extraWatchPaths = watchFlag{}
+
+// watchFlag collects repeated -watch tool=path flags
+type watchFlag map[string][]string
+
+func (f watchFlag) String() string {
+	var parts []string
+	for tool, paths := range f {
+		for _, p := range paths {
+			parts = append(parts, tool+"="+p)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f watchFlag) Set(value string) error {
+	tool, path, ok := strings.Cut(value, "=")
+	if !ok || tool == "" || !filepath.IsAbs(path) {
+		return fmt.Errorf("expected tool=/absolute/path, got %q", value)
+	}
+	f[tool] = append(f[tool], filepath.Clean(path))
+	return nil
+}
+
+// watchPathsFor returns the paths snapshotted around a tool's jobs
+func watchPathsFor(tool string, args map[string]any) []string {
+	var paths []string
+	if fn, ok := DefaultWatchPaths[tool]; ok {
+		paths = fn(args)
+	}
+	return append(paths, extraWatchPaths[tool]...)
+}
+
+// FileChange is one file a job created, modified or deleted
+type FileChange struct {
+	Path   string
+	Change string // "created", "modified" or "deleted"
+	Diff   string
+}
+
+// fileSnapshot maps each watched file to its content. Symlinks are
+// recorded by target so relinking in sites-enabled shows up too.
+type fileSnapshot struct {
+	files map[string]string
+	// skipped lists files that were too large or unreadable, and
+	// truncated says the file or byte limit was hit
+	skipped   map[string]string
+	truncated bool
+}
+
+// takeSnapshot reads every regular file and symlink under paths.
+// Missing paths are fine: a job may be the one creating them.
+func takeSnapshot(paths []string) *fileSnapshot {
+	if len(paths) == 0 {
+		return nil
+	}
+	snap := &fileSnapshot{files: map[string]string{}, skipped: map[string]string{}}
+	total := 0
+
+	for _, root := range paths {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if !os.IsNotExist(err) {
+					snap.skipped[path] = err.Error()
+				}
+				return nil
+			}
+			if _, seen := snap.files[path]; seen {
+				return nil
+			}
+			if len(snap.files) >= maxSnapshotFiles || total >= maxSnapshotBytes {
+				snap.truncated = true
+				return filepath.SkipAll
+			}
+
+			switch {
+			case d.Type()&fs.ModeSymlink != 0:
+				target, err := os.Readlink(path)
+				if err != nil {
+					snap.skipped[path] = err.Error()
+					return nil
+				}
+				snap.files[path] = "-> " + target + "\n"
+			case d.Type().IsRegular():
+				info, err := d.Info()
+				if err != nil {
+					snap.skipped[path] = err.Error()
+					return nil
+				}
+				if info.Size() > maxSnapshotFileSize {
+					// Size and mtime still tell whether it changed
+					snap.skipped[path] = "too large to diff"
+					snap.files[path] = fmt.Sprintf("\x00size %d mtime %d", info.Size(), info.ModTime().UnixNano())
+					return nil
+				}
+				data, err := os.ReadFile(path)
+				if err != nil {
+					snap.skipped[path] = err.Error()
+					return nil
+				}
+				snap.files[path] = string(data)
+				total += len(data)
+			}
+			return nil
+		})
+	}
+	return snap
+}
+
+// diffSnapshots lists the files that differ between two snapshots,
+// sorted by path
+func diffSnapshots(before, after *fileSnapshot) []FileChange {
+	if before == nil || after == nil {
+		return nil
+	}
+
+	var changes []FileChange
+	for path, old := range before.files {
+		cur, ok := after.files[path]
+		switch {
+		case !ok:
+			changes = append(changes, FileChange{Path: path, Change: "deleted", Diff: artifactDiff(path, old, "")})
+		case cur != old:
+			changes = append(changes, FileChange{Path: path, Change: "modified", Diff: artifactDiff(path, old, cur)})
+		}
+	}
+	for path, cur := range after.files {
+		if _, ok := before.files[path]; !ok {
+			changes = append(changes, FileChange{Path: path, Change: "created", Diff: artifactDiff(path, "", cur)})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// artifactDiff diffs one file, capping the result at maxArtifactDiff
+func artifactDiff(path, before, after string) string {
+	diff := unifiedDiff(path, before, after)
+	if len(diff) > maxArtifactDiff {
+		diff = diff[:maxArtifactDiff] + "\n... (diff truncated)\n"
+	}
+	return diff
+}
+
+// snapshotNotes describes what a snapshot could not cover
+func snapshotNotes(snaps ...*fileSnapshot) []string {
+	var notes []string
+	seen := map[string]bool{}
+	for _, snap := range snaps {
+		if snap == nil {
+			continue
+		}
+		if snap.truncated && !seen[""] {
+			seen[""] = true
+			notes = append(notes, fmt.Sprintf("Watched paths exceed %d files or %d MiB; later files were not compared", maxSnapshotFiles, maxSnapshotBytes>>20))
+		}
+		for path, reason := range snap.skipped {
+			if !seen[path] {
+				seen[path] = true
+				notes = append(notes, fmt.Sprintf("%s: %s", path, reason))
+			}
+		}
+	}
+	sort.Strings(notes)
+	return notes
+}
+
+// formatChanges summarises file changes, one line per file, followed by
+// their diffs when withDiffs is set
+func formatChanges(changes []FileChange, withDiffs bool) string {
+	var b strings.Builder
+	for _, c := range changes {
+		fmt.Fprintf(&b, "%s %s\n", c.Change, c.Path)
+	}
+	if withDiffs {
+		for _, c := range changes {
+			if c.Diff != "" {
+				b.WriteString("\n")
+				b.WriteString(c.Diff)
+			}
+		}
+	}
+	return b.String()
+}
+
+// artifactURI is the MCP resource holding a job's file changes
+func artifactURI(jobID string) string {
+	return "job://" + jobID + "/artifacts"
+}
+
+// ArtifactJobs returns the finished jobs that changed watched files,
+// most recent first
+func (jm *JobQueue) ArtifactJobs() []JobInfo {
+	var infos []JobInfo
+	for _, info := range jm.ListJobs() {
+		if len(info.Artifacts) > 0 {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].EndTime.After(infos[j].EndTime) })
+	return infos
+}
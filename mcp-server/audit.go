@@ -0,0 +1,86 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditEntry is one line of the audit log
+type auditEntry struct {
+	Time      time.Time      `json:"time"`
+	Caller    string         `json:"caller"`
+	Via       string         `json:"via"`
+	Tool      string         `json:"tool"`
+	Arguments map[string]any `json:"arguments,omitempty"`
+	// Denied marks calls the caller was not allowed to make
+	Denied     bool   `json:"denied,omitempty"`
+	IsError    bool   `json:"isError,omitempty"`
+	Error      string `json:"error,omitempty"`
+	JobID      string `json:"jobId,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// auditMu keeps concurrent calls' lines from interleaving
+var auditMu sync.Mutex
+
+// audit appends a tool call to the audit log, if one is configured. The
+// file is opened for each line, so it can be rotated at any time.
+func audit(c Caller, tool string, args map[string]any, result ToolCallResult, denied bool, start time.Time) {
+	path := config().AuditLog
+	if path == "" {
+		return
+	}
+	entry := auditEntry{
+		Time:       start.UTC(),
+		Caller:     c.Name,
+		Via:        c.Via,
+		Tool:       tool,
+		Arguments:  auditArgs(tool, args),
+		Denied:     denied,
+		IsError:    result.IsError,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if result.IsError && len(result.Content) > 0 {
+		entry.Error = tail(result.Content[0].Text, 200)
+	}
+	if job, ok := result.StructuredContent.(*jobContent); ok {
+		entry.JobID = job.JobID
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Audit log: %v\n", err)
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Audit log: %v\n", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "Audit log: %v\n", err)
+	}
+}
+
+// auditArgs returns the arguments to log: internal ones are left out,
+// and answers to prompts and secret-looking values are masked
+func auditArgs(tool string, args map[string]any) map[string]any {
+	out := make(map[string]any, len(args))
+	for k, v := range args {
+		switch {
+		case strings.HasPrefix(k, "_"):
+			continue
+		case tool == "send_job_input" && k == "input", sensitivePrompt.MatchString(k):
+			v = "[redacted]"
+		}
+		out[k] = v
+	}
+	return out
+}
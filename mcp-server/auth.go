@@ -0,0 +1,250 @@
+package mcpserver
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Caller is who a tool call comes from. MCP clients and the REST API
+// are authorized and audited the same way.
+type Caller struct {
+	// Name is "stdio", the name of a REST API token, or the Unix user
+	// of a socket client or a subcommand
+	Name string
+	// Via is how the call arrived: stdio, socket, http, local for a
+	// subcommand running the tools itself, or schedule for a scheduled run
+	Via string
+}
+
+// sourceArgKey carries what made a call that no client made, such as a
+// schedule, into the jobs it starts. Like traceArgKey it is set by the
+// server and never read from clients.
+const sourceArgKey = "_source"
+
+// callerArgKey carries the caller of a tool call into the handlers and
+// the jobs it starts, as Caller.id; set by the server only
+const callerArgKey = "_caller"
+
+// id identifies the caller as the owner of jobs, as "via:name"
+func (c Caller) id() string {
+	return c.Via + ":" + c.Name
+}
+
+// callerFromArgs returns the caller callTool recorded in args. Calls
+// that did not come through callTool, such as an embedding program's own
+// ExecuteTool, have none.
+func callerFromArgs(args map[string]any) (Caller, bool) {
+	via, name, ok := strings.Cut(getString(args, callerArgKey, ""), ":")
+	return Caller{Name: name, Via: via}, ok
+}
+
+// stdioCaller is the client that started the server, which may call
+// every tool
+var stdioCaller = Caller{Name: "stdio", Via: "stdio"}
+
+// connCaller identifies the client on the other end of a Listen
+// connection by its Unix user
+func connCaller(conn net.Conn) Caller {
+	c := Caller{Name: conn.RemoteAddr().String(), Via: "socket"}
+	if uc, ok := conn.(*net.UnixConn); ok {
+		if uid, err := peerUID(uc); err == nil {
+			c.Name = strconv.FormatUint(uint64(uid), 10)
+			if u, err := user.LookupId(c.Name); err == nil {
+				c.Name = u.Username
+			}
+		}
+	}
+	return c
+}
+
+// tokenCaller returns the caller a REST API bearer token belongs to
+func tokenCaller(token string) (Caller, bool) {
+	sum := sha256.Sum256([]byte(token))
+	for name, tc := range config().Auth.Tokens {
+		want, _ := hex.DecodeString(tc.SHA256)
+		if subtle.ConstantTimeCompare(sum[:], want) == 1 {
+			return Caller{Name: name, Via: "http"}, true
+		}
+	}
+	return Caller{}, false
+}
+
+// access returns the limits on the caller's tools; nil allows all
+func (c Caller) access() (*AccessConfig, error) {
+	auth := config().Auth
+	switch c.Via {
+	case "http":
+		if tc := auth.Tokens[c.Name]; tc != nil {
+			return &tc.AccessConfig, nil
+		}
+		return nil, fmt.Errorf("token %s is not configured", c.Name)
+	case "socket":
+		if len(auth.Users) == 0 {
+			return nil, nil
+		}
+		if uc := auth.Users[c.Name]; uc != nil {
+			return uc, nil
+		}
+		return nil, fmt.Errorf("user %s may not call tools", c.Name)
+	}
+	return nil, nil
+}
+
+// authorize checks that the caller may call tool, and every tool a
+// pipeline or schedule would run for it
+func authorize(c Caller, tool string, args map[string]any) error {
+	access, err := c.access()
+	if err != nil || access == nil {
+		return err
+	}
+	for _, name := range calledTools(tool, args) {
+		if !access.allows(name) {
+			return fmt.Errorf("%s may not call %s", c.Name, name)
+		}
+	}
+	return nil
+}
+
+// mayUseJob checks that the caller may see and act on a job of tool
+// started by owner, a Caller.id. Callers with limits need the tool and
+// only get their own jobs unless they are admins.
+func (c Caller) mayUseJob(tool, owner string) error {
+	access, err := c.access()
+	if err != nil || access == nil || access.Admin {
+		return err
+	}
+	if !access.allows(tool) {
+		return fmt.Errorf("%s may not use %s jobs", c.Name, tool)
+	}
+	if owner != c.id() {
+		return fmt.Errorf("%s may only use its own jobs", c.Name)
+	}
+	return nil
+}
+
+// jobAllowed checks a job tool's access to a job for the caller of args.
+// Calls without a recorded caller are the embedding program's own.
+func jobAllowed(args map[string]any, info JobInfo) error {
+	c, ok := callerFromArgs(args)
+	if !ok {
+		return nil
+	}
+	return c.mayUseJob(info.Tool, info.Caller)
+}
+
+// calledTools lists tool and the tools a call to it would run later
+func calledTools(tool string, args map[string]any) []string {
+	tools := []string{tool}
+	switch tool {
+	case "run_pipeline":
+		steps, _ := args["steps"].([]any)
+		for _, st := range steps {
+			step, _ := st.(map[string]any)
+			if name, _ := step["tool"].(string); name != "" {
+				stepArgs, _ := step["arguments"].(map[string]any)
+				tools = append(tools, calledTools(name, stepArgs)...)
+			}
+		}
+	case "schedule_create":
+		if name, _ := args["tool"].(string); name != "" {
+			schArgs, _ := args["arguments"].(map[string]any)
+			tools = append(tools, calledTools(name, schArgs)...)
+		}
+	}
+	return tools
+}
+
+// allows reports whether the limits let a caller call tool
+func (a *AccessConfig) allows(tool string) bool {
+	if len(a.Tools) == 0 {
+		return true
+	}
+	for _, t := range a.Tools {
+		if t == tool {
+			return true
+		}
+	}
+	return false
+}
+
+// callTool runs a tool call from MCP or the REST API. It refuses calls
+// the caller may not make, and traces, counts and audits the rest. The
+// error is set only when the call was refused.
+func callTool(c Caller, name string, args map[string]any, parent SpanContext) (ToolCallResult, error) {
+	start := time.Now()
+	span := StartSpan("tools/call "+name, parent, start)
+	defer span.End()
+	span.SetAttr("mcp.tool", name)
+	span.SetAttr("caller", c.Name)
+	span.SetAttr("caller.via", c.Via)
+
+	// Callers cannot set the internal trace argument; handlers use it to
+	// parent the jobs and commands they start
+	if args == nil {
+		args = map[string]any{}
+	}
+	delete(args, traceArgKey)
+	delete(args, sourceArgKey)
+	args[callerArgKey] = c.id()
+	if c.Via == "schedule" {
+		args[sourceArgKey] = c.Name
+	}
+
+	if err := authorize(c, name, args); err != nil {
+		span.SetError(err.Error())
+		audit(c, name, args, errorResult(err.Error()), true, start)
+		return ToolCallResult{}, err
+	}
+	if sc := span.Context(); sc.IsValid() {
+		args[traceArgKey] = sc.Traceparent()
+	}
+
+	result := ExecuteTool(name, args)
+	metrics.ToolCall(name, result.IsError)
+	if result.IsError && len(result.Content) > 0 {
+		span.SetError(tail(result.Content[0].Text, 200))
+	}
+	audit(c, name, args, result, false, start)
+	return result, nil
+}
+
+// validate checks that the tokens are well formed and name known tools
+func (a *ConfigAuth) validate(tools map[string]Tool) error {
+	for name, tc := range a.Tokens {
+		if tc == nil {
+			return fmt.Errorf("tokens: %s: sha256 is required", name)
+		}
+		if b, err := hex.DecodeString(tc.SHA256); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("tokens: %s: sha256 must be 64 hex digits", name)
+		}
+		if err := tc.AccessConfig.validate(tools); err != nil {
+			return fmt.Errorf("tokens: %s: %v", name, err)
+		}
+	}
+	for name, uc := range a.Users {
+		if uc == nil {
+			a.Users[name] = &AccessConfig{}
+			continue
+		}
+		if err := uc.validate(tools); err != nil {
+			return fmt.Errorf("users: %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (a *AccessConfig) validate(tools map[string]Tool) error {
+	for _, t := range a.Tools {
+		if _, ok := tools[t]; !ok {
+			return fmt.Errorf("tools: unknown tool %q", t)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package mcpserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useAuth configures a REST token "ci" limited to some tools, an admin
+// token "ops", a socket user "deploy" limited to some tools and an admin
+// socket user "root"
+func useAuth(t *testing.T) {
+	t.Helper()
+	useConfig(t, func(c *Config) {
+		c.Auth.Tokens = map[string]*TokenConfig{
+			"ci":  {SHA256: sha256Hex("ci-token"), AccessConfig: AccessConfig{Tools: []string{"fqdnmgr_check", "a2certrenew", "check_job_status", "cancel_job", "list_jobs"}}},
+			"ops": {SHA256: sha256Hex("ops-token"), AccessConfig: AccessConfig{Admin: true}},
+		}
+		c.Auth.Users = map[string]*AccessConfig{
+			"deploy": {Tools: []string{"fqdnmgr_check", "run_pipeline", "check_job_status"}},
+			"root":   {Admin: true},
+		}
+	})
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAuthorize(t *testing.T) {
+	useAuth(t)
+
+	ci := Caller{Name: "ci", Via: "http"}
+	deploy := Caller{Name: "deploy", Via: "socket"}
+	pipeline := func(tools ...string) map[string]any {
+		var steps []any
+		for _, tool := range tools {
+			steps = append(steps, map[string]any{"tool": tool, "arguments": map[string]any{}})
+		}
+		return map[string]any{"steps": steps}
+	}
+
+	tests := []struct {
+		name    string
+		caller  Caller
+		tool    string
+		args    map[string]any
+		wantErr string
+	}{
+		{"stdio", stdioCaller, "fqdnmgr_purchase", nil, ""},
+		{"local subcommand", Caller{Name: "root", Via: "local"}, "fqdnmgr_purchase", nil, ""},
+		{"token tool", ci, "a2certrenew", nil, ""},
+		{"token other tool", ci, "fqdnmgr_purchase", nil, "ci may not call fqdnmgr_purchase"},
+		{"unknown token", Caller{Name: "gone", Via: "http"}, "fqdnmgr_check", nil, "not configured"},
+		{"admin token", Caller{Name: "ops", Via: "http"}, "fqdnmgr_purchase", nil, ""},
+		{"user tool", deploy, "fqdnmgr_check", nil, ""},
+		{"user other tool", deploy, "a2sitemgr", nil, "deploy may not call a2sitemgr"},
+		{"unlisted user", Caller{Name: "www-data", Via: "socket"}, "fqdnmgr_check", nil, "may not call tools"},
+		{"pipeline of allowed steps", deploy, "run_pipeline", pipeline("fqdnmgr_check"), ""},
+		{"pipeline smuggling a step", deploy, "run_pipeline", pipeline("fqdnmgr_check", "fqdnmgr_purchase"), "deploy may not call fqdnmgr_purchase"},
+		{"schedule without schedule_create", ci, "schedule_create", map[string]any{"tool": "a2certrenew"}, "ci may not call schedule_create"},
+		{"schedule as admin", Caller{Name: "ops", Via: "http"}, "schedule_create", map[string]any{"tool": "a2certrenew"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authorize(tt.caller, tt.tool, tt.args)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("authorize: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("authorize = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTokenCaller(t *testing.T) {
+	useAuth(t)
+
+	tests := []struct {
+		token string
+		want  string
+		ok    bool
+	}{
+		{"ci-token", "ci", true},
+		{"ops-token", "ops", true},
+		{"ci-token ", "", false},
+		{"", "", false},
+		{sha256Hex("ci-token"), "", false},
+	}
+	for _, tt := range tests {
+		c, ok := tokenCaller(tt.token)
+		if ok != tt.ok || c.Name != tt.want || (ok && c.Via != "http") {
+			t.Errorf("tokenCaller(%q) = %+v, %v; want %q, %v", tt.token, c, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMayUseJob(t *testing.T) {
+	useAuth(t)
+
+	ci := Caller{Name: "ci", Via: "http"}
+	tests := []struct {
+		name   string
+		caller Caller
+		tool   string
+		owner  string
+		want   bool
+	}{
+		{"own job", ci, "a2certrenew", ci.id(), true},
+		{"other caller's job", ci, "a2certrenew", "socket:deploy", false},
+		{"same name over another transport", ci, "a2certrenew", "socket:ci", false},
+		{"own job of a tool no longer allowed", ci, "fqdnmgr_purchase", ci.id(), false},
+		{"scheduled job", ci, "a2certrenew", "schedule:schedule 1", false},
+		{"admin token", Caller{Name: "ops", Via: "http"}, "fqdnmgr_purchase", "socket:deploy", true},
+		{"admin user", Caller{Name: "root", Via: "socket"}, "fqdnmgr_purchase", "http:ci", true},
+		{"stdio", stdioCaller, "fqdnmgr_purchase", "http:ci", true},
+		{"unlisted user", Caller{Name: "www-data", Via: "socket"}, "fqdnmgr_check", "socket:www-data", false},
+	}
+	for _, tt := range tests {
+		if err := tt.caller.mayUseJob(tt.tool, tt.owner); (err == nil) != tt.want {
+			t.Errorf("%s: mayUseJob = %v, want allowed %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestJobToolsCheckOwner(t *testing.T) {
+	useAuth(t)
+	useReplayer(t)
+
+	ci := Caller{Name: "ci", Via: "http"}
+	result, err := callTool(ci, "a2certrenew", map[string]any{}, SpanContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, ok := result.StructuredContent.(*jobContent)
+	if !ok {
+		t.Fatalf("a2certrenew started no job: %+v", result)
+	}
+	<-jobMgr.Done(job.JobID)
+	if info, _ := jobMgr.GetJobStatus(job.JobID); info.Caller != ci.id() {
+		t.Fatalf("job caller = %q, want %q", info.Caller, ci.id())
+	}
+
+	text := func(r ToolCallResult) string {
+		var b strings.Builder
+		for _, c := range r.Content {
+			b.WriteString(c.Text)
+		}
+		return b.String()
+	}
+	tests := []struct {
+		caller  Caller
+		tool    string
+		allowed bool
+	}{
+		{ci, "check_job_status", true},
+		{Caller{Name: "deploy", Via: "socket"}, "check_job_status", false},
+		{Caller{Name: "ops", Via: "http"}, "check_job_status", true},
+		{Caller{Name: "ops", Via: "http"}, "wait_job", true},
+		{Caller{Name: "deploy", Via: "socket"}, "wait_job", false},
+	}
+	for _, tt := range tests {
+		result, err := callTool(tt.caller, tt.tool, map[string]any{"jobId": job.JobID}, SpanContext{})
+		refused := err != nil || strings.Contains(text(result), "Not allowed")
+		if refused == tt.allowed {
+			t.Errorf("%s %s on %s's job: refused = %v (%v %q)", tt.caller.id(), tt.tool, ci.id(), refused, err, text(result))
+		}
+	}
+
+	// list_jobs leaves out other callers' jobs
+	if result, err := callTool(ci, "list_jobs", map[string]any{}, SpanContext{}); err != nil || !strings.Contains(text(result), job.JobID) {
+		t.Errorf("list_jobs for the owner does not list its job: %v %q", err, text(result))
+	}
+	ops := Caller{Name: "ops", Via: "http"}
+	other, err := callTool(ops, "a2certrenew", map[string]any{}, SpanContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherJob := other.StructuredContent.(*jobContent)
+	<-jobMgr.Done(otherJob.JobID)
+	if result, _ := callTool(ci, "list_jobs", map[string]any{}, SpanContext{}); strings.Contains(text(result), otherJob.JobID) {
+		t.Errorf("list_jobs for ci lists a job of ops: %q", text(result))
+	}
+	if result, _ := callTool(ci, "cancel_job", map[string]any{"jobId": otherJob.JobID}, SpanContext{}); !strings.Contains(text(result), "Not allowed") {
+		t.Errorf("ci cancelling a job of ops got %q", text(result))
+	}
+}
+
+// recordingTransport keeps what the server writes to a client
+type recordingTransport struct {
+	sent [][]byte
+}
+
+func (t *recordingTransport) ReadMessage() ([]byte, error) { select {} }
+
+func (t *recordingTransport) WriteMessage(msg []byte) error {
+	t.sent = append(t.sent, msg)
+	return nil
+}
+
+func TestResourcesCheckOwner(t *testing.T) {
+	useAuth(t)
+	useReplayer(t)
+
+	jm := jobMgr.(*JobQueue)
+	done := make(chan struct{})
+	close(done)
+	job := &Job{
+		ID:        "00000000-job-of-deploy",
+		Spec:      JobSpec{Tool: "a2sitemgr", Caller: "socket:deploy"},
+		Status:    JobStatusCompleted,
+		EndTime:   time.Now(),
+		Artifacts: []FileChange{{Path: "/etc/apache2/sites-available/example.com.conf", Change: "created", Diff: "+secret\n"}},
+		done:      done,
+		changed:   make(chan struct{}),
+	}
+	jm.mu.Lock()
+	jm.jobs[job.ID] = job
+	jm.mu.Unlock()
+
+	tests := []struct {
+		caller  Caller
+		allowed bool
+	}{
+		{Caller{Name: "root", Via: "socket"}, true},
+		{stdioCaller, true},
+		// deploy may not call a2sitemgr, so not even its own job's changes
+		{Caller{Name: "deploy", Via: "socket"}, false},
+		{Caller{Name: "www-data", Via: "socket"}, false},
+	}
+	for _, tt := range tests {
+		tr := &recordingTransport{}
+		sess := &session{transport: tr, caller: tt.caller}
+		handleResourcesList(sess, &JSONRPCRequest{ID: 1})
+		params, _ := json.Marshal(ResourceReadParams{URI: artifactURI(job.ID)})
+		handleResourcesRead(sess, &JSONRPCRequest{ID: 2, Params: params})
+
+		if len(tr.sent) != 2 {
+			t.Fatalf("%s: %d responses, want 2", tt.caller.id(), len(tr.sent))
+		}
+		listed := strings.Contains(string(tr.sent[0]), job.ID)
+		read := strings.Contains(string(tr.sent[1]), "+secret")
+		if listed != tt.allowed || read != tt.allowed {
+			t.Errorf("%s: listed %v, read %v; want %v", tt.caller.id(), listed, read, tt.allowed)
+		}
+	}
+}
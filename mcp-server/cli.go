@@ -0,0 +1,191 @@
+package mcpserver
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// toolLimitFlag collects repeated -tool-limit tool=N flags
+type toolLimitFlag map[string]int
+
+func (f toolLimitFlag) String() string {
+	parts := make([]string, 0, len(f))
+	for tool, n := range f {
+		parts = append(parts, fmt.Sprintf("%s=%d", tool, n))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f toolLimitFlag) Set(value string) error {
+	tool, n, ok := strings.Cut(value, "=")
+	if !ok || tool == "" {
+		return fmt.Errorf("expected tool=N, got %q", value)
+	}
+	limit, err := strconv.Atoi(n)
+	if err != nil || limit < 1 {
+		return fmt.Errorf("invalid limit for %s: %q", tool, n)
+	}
+	f[tool] = limit
+	return nil
+}
+
+// Main runs the a2cmds-mcp command with the given arguments, without the
+// program name, and returns its exit code. A first argument naming a
+// subcommand, such as tools or call, runs that instead of the server.
+func Main(args []string) int {
+	if len(args) > 0 {
+		if _, ok := subcommands[args[0]]; ok {
+			return runSubcommand(args[0], args[1:])
+		}
+	}
+
+	configFile := flag.String("config", DefaultConfigFile, "YAML configuration file, reloaded on SIGHUP; flags given on the command line override it")
+	toolsDir := flag.String("tools-dir", DefaultToolsDir, "directory of YAML or JSON tool definitions loaded alongside the built-in tools")
+	toolLimits := toolLimitFlag{}
+	workers := flag.Int("workers", DefaultWorkers, "maximum number of jobs running at once")
+	flag.Var(toolLimits, "tool-limit", "per-tool concurrency limit as tool=N (repeatable)")
+	stateDir := flag.String("state-dir", DefaultStateDir, "directory for persistent server state")
+	flag.Var(extraWatchPaths, "watch", "also record changes to this path in a tool's job artifacts, as tool=/path (repeatable)")
+	flag.BoolVar(&ptyEnabled, "pty", true, "run tools that write to /dev/tty under a pseudo-terminal")
+	flag.StringVar(&cgroupRoot, "cgroup-root", "", "cgroup v2 directory under which each tool gets its own cgroup (e.g. a2cmds-mcp.slice)")
+	dmsDir := flag.String("dms-dir", DefaultDMSDir, "docker-mailserver directory passed to a2wcrecalc_dms as DMS_DIR")
+	metricsListen := flag.String("metrics-listen", "", "address to serve Prometheus /metrics on (e.g. 127.0.0.1:9464); empty disables it")
+	otlpFile := flag.String("otlp-file", "", "append OTLP/JSON trace exports to this file")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "POST OTLP/JSON traces to this collector URL (e.g. http://127.0.0.1:4318/v1/traces)")
+	notifyConfig := flag.String("notify-config", DefaultNotifyConfig, "JSON file with global job notification rules, reloaded on SIGHUP")
+	idempotencyRetention := flag.Duration("idempotency-retention", DefaultIdempotencyRetention, "how long idempotency keys and their job results are kept")
+	inputTimeout := flag.Duration("input-timeout", DefaultInputTimeout, "close an interactive job's input when a prompt goes unanswered this long; 0 waits forever")
+	shutdownTimeout := flag.Duration("shutdown-timeout", DefaultShutdownTimeout, "how long to wait for running jobs on shutdown")
+	execHelper := flag.String("exec-helper", "", "run scripts through the privileged helper listening on this socket (e.g. "+DefaultHelperSocket+")")
+	listen := flag.String("listen", "", "also serve MCP clients on this unix socket (e.g. "+DefaultListenSocket+")")
+	listenGroup := flag.String("listen-group", "", "group that may connect to the -listen socket; without it only this user can")
+	httpListen := flag.String("http-listen", "", "serve the REST API on this address (e.g. 127.0.0.1:8080); needs auth.tokens in the config file")
+	stdio := flag.Bool("stdio", true, "serve a client on stdin and stdout; with -stdio=false the server runs until stopped by a signal")
+	helperListen := flag.String("helper-listen", "", "run as the privileged helper on this unix socket instead of as an MCP server")
+	helperGroup := flag.String("helper-group", "", "group that may connect to the helper socket; without it only root can")
+	helperAllow := flag.String("helper-allow", "", "comma-separated users besides root the helper runs commands for")
+	recordDir := flag.String("record", "", "save every script run as a fixture file in this directory")
+	replayDir := flag.String("replay", "", "replay the fixture files in this directory instead of running scripts")
+	replaySpeed := flag.Float64("replay-speed", 1, "speed up replayed scripts by this factor; 0 replays without delays")
+	hostsFile := flag.String("hosts", DefaultHostsFile, "JSON inventory of remote hosts tools can run on over SSH, reloaded on SIGHUP")
+	flag.CommandLine.Parse(args)
+
+	flags := configFlags{
+		"workers": func(c *Config) { c.Queue.Workers = *workers },
+		"tool-limit": func(c *Config) {
+			for tool, n := range toolLimits {
+				c.Queue.ToolLimits[tool] = n
+			}
+		},
+		"dms-dir":               func(c *Config) { c.DMSDir = *dmsDir },
+		"input-timeout":         func(c *Config) { c.Timeouts.Input = *inputTimeout },
+		"shutdown-timeout":      func(c *Config) { c.Timeouts.Shutdown = *shutdownTimeout },
+		"idempotency-retention": func(c *Config) { c.Retention.Idempotency = *idempotencyRetention },
+		"metrics-listen":        func(c *Config) { c.Transports.MetricsListen = *metricsListen },
+		"exec-helper":           func(c *Config) { c.Transports.ExecHelper = *execHelper },
+		"listen":                func(c *Config) { c.Transports.Listen = *listen },
+		"listen-group":          func(c *Config) { c.Transports.ListenGroup = *listenGroup },
+		"http-listen":           func(c *Config) { c.Transports.HTTPListen = *httpListen },
+		"tools-dir":             func(c *Config) { c.ToolsDir = *toolsDir },
+	}
+	conf, err := LoadConfig(*configFile, flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return 1
+	}
+	activeConfig.Store(conf)
+	watchConfig(*configFile, flags)
+
+	if *helperListen != "" {
+		uids, err := parseHelperUsers(*helperAllow)
+		if err == nil {
+			outputDir = filepath.Join(*stateDir, "helper-output")
+			err = runHelper(HelperConfig{Socket: *helperListen, Group: *helperGroup, AllowUIDs: uids})
+		}
+		fmt.Fprintf(os.Stderr, "Helper: %v\n", err)
+		return 1
+	}
+	if !*stdio && conf.Transports.Listen == "" && conf.Transports.HTTPListen == "" {
+		fmt.Fprintln(os.Stderr, "-stdio=false needs a socket or an HTTP address to listen on")
+		return 2
+	}
+	if *recordDir != "" && *replayDir != "" {
+		fmt.Fprintln(os.Stderr, "-record and -replay cannot be used together")
+		return 2
+	}
+	if *recordDir != "" {
+		r, err := NewRecorder(*recordDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		recorder = r
+		fmt.Fprintf(os.Stderr, "Recording script runs to %s\n", *recordDir)
+	}
+	if *replayDir != "" {
+		r, err := NewReplayer(*replayDir, *replaySpeed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		replayer = r
+		fmt.Fprintf(os.Stderr, "Replaying script runs from %s; no scripts will run\n", *replayDir)
+	}
+	inv, err := LoadHostInventory(*hostsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading hosts: %v\n", err)
+	}
+	notify, err := LoadNotifyConfig(*notifyConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading notification rules: %v\n", err)
+	}
+
+	server, err := NewServer(Options{Config: conf, StateDir: *stateDir, Hosts: inv, Notify: notify})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	handleSignals(server)
+	onSIGHUP(func() {
+		reloadHosts(*hostsFile)
+		reloadNotifyConfig(*notifyConfig)
+	})
+
+	if *otlpFile != "" || *otlpEndpoint != "" {
+		StartTracer(*otlpFile, *otlpEndpoint)
+	}
+
+	if conf.Transports.Listen != "" {
+		ln, err := listenUnix(conf.Transports.Listen, conf.Transports.ListenGroup)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Serving MCP clients on %s\n", conf.Transports.Listen)
+		go func() {
+			if err := server.Listen(ln); err != nil {
+				fmt.Fprintf(os.Stderr, "Error accepting clients: %v\n", err)
+			}
+		}()
+	}
+	if conf.Transports.HTTPListen != "" {
+		fmt.Fprintf(os.Stderr, "Serving the REST API on %s\n", conf.Transports.HTTPListen)
+		server.ServeREST(conf.Transports.HTTPListen)
+	}
+	if !*stdio {
+		// Runs until a signal shuts the server down
+		select {}
+	}
+
+	if err := server.Serve(NewStdioTransport(os.Stdin, os.Stdout)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
+		server.Shutdown("stdin read error")
+		return 1
+	}
+	server.Shutdown("stdin closed")
+	return 0
+}
@@ -0,0 +1,304 @@
+// Package client calls the tools of an a2cmds MCP server from Go. It
+// spawns the server as a subprocess or connects to one listening on a
+// socket, does the initialize handshake, and decodes tool results into
+// Go structs.
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+)
+
+// ProtocolVersion is the MCP revision the client speaks
+const ProtocolVersion = "2024-11-05"
+
+// ErrClosed is returned for calls on a closed connection
+var ErrClosed = errors.New("client: connection closed")
+
+// Client is a connection to an a2cmds MCP server. Its methods may be
+// called concurrently.
+type Client struct {
+	conn io.ReadWriteCloser
+	// wait reaps a spawned server once the connection is closed
+	wait func() error
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan response
+	err     error
+	done    chan struct{}
+
+	// Server is what the server reported about itself in initialize
+	Server ServerInfo
+	// OnToolsChanged, if set before the tool list changes, is called
+	// when the server reports it did
+	OnToolsChanged func()
+}
+
+// ServerInfo names the server
+type ServerInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// RPCError is a JSON-RPC error returned by the server
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	if len(e.Data) > 0 {
+		return fmt.Sprintf("%s (%d): %s", e.Message, e.Code, e.Data)
+	}
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// ToolError is a tool call the server reported as failed, such as
+// invalid arguments or an unknown job
+type ToolError struct {
+	Tool    string
+	Message string
+}
+
+func (e *ToolError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Tool, e.Message)
+}
+
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+type response struct {
+	result json.RawMessage
+	err    error
+}
+
+// Spawn starts the server binary at path with args and talks to it over
+// its stdin and stdout. Its stderr goes to this process's stderr.
+func Spawn(ctx context.Context, path string, args ...string) (*Client, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Stderr = os.Stderr
+	return SpawnCommand(ctx, cmd)
+}
+
+// SpawnCommand starts cmd, which must not have Stdin or Stdout set, as
+// the server. Closing the client closes its stdin and waits for it.
+func SpawnCommand(ctx context.Context, cmd *exec.Cmd) (*Client, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	c, err := newClient(ctx, pipeConn{stdout, stdin}, cmd.Wait)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	return c, nil
+}
+
+// pipeConn joins a subprocess's stdout and stdin
+type pipeConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// Dial connects to a server listening on network and address, such as
+// "unix" and the server's -listen socket
+func Dial(ctx context.Context, network, address string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return New(ctx, conn)
+}
+
+// New starts a session over conn, which carries one JSON-RPC message per
+// line, and does the initialize handshake
+func New(ctx context.Context, conn io.ReadWriteCloser) (*Client, error) {
+	return newClient(ctx, conn, nil)
+}
+
+func newClient(ctx context.Context, conn io.ReadWriteCloser, wait func() error) (*Client, error) {
+	c := &Client{
+		conn:    conn,
+		wait:    wait,
+		pending: make(map[int64]chan response),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+
+	var result struct {
+		ServerInfo ServerInfo `json:"serverInfo"`
+	}
+	err := c.call(ctx, "initialize", map[string]any{
+		"protocolVersion": ProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]string{"name": "a2cmds-go-client", "version": "1.0.0"},
+	}, &result)
+	if err == nil {
+		c.Server = result.ServerInfo
+		err = c.send(message{JSONRPC: "2.0", Method: "notifications/initialized"})
+	}
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("initialize: %w", err)
+	}
+	return c, nil
+}
+
+// Close ends the session. A spawned server then shuts down, waiting for
+// its running jobs as it does when its client goes away.
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	<-c.done
+	if c.wait != nil {
+		if werr := c.wait(); err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// readLoop delivers responses to their callers until the connection ends
+func (c *Client) readLoop() {
+	r := bufio.NewReader(c.conn)
+	var err error
+	for {
+		var line []byte
+		line, err = r.ReadBytes('\n')
+		if len(line) > 1 {
+			c.dispatch(line)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrClosed) {
+		err = ErrClosed
+	}
+
+	c.mu.Lock()
+	c.err = err
+	for id, ch := range c.pending {
+		ch <- response{err: err}
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	close(c.done)
+}
+
+func (c *Client) dispatch(line []byte) {
+	var msg message
+	if json.Unmarshal(line, &msg) != nil {
+		return
+	}
+	if msg.Method != "" {
+		if msg.Method == "notifications/tools/list_changed" && c.OnToolsChanged != nil {
+			go c.OnToolsChanged()
+		}
+		if len(msg.ID) > 0 {
+			// The client offers no capabilities the server could ask for
+			c.send(message{JSONRPC: "2.0", ID: msg.ID, Error: &RPCError{Code: -32601, Message: "Method not found"}})
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(string(msg.ID), 10, 64)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	ch, ok := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	if msg.Error != nil {
+		ch <- response{err: msg.Error}
+		return
+	}
+	ch <- response{result: msg.Result}
+}
+
+func (c *Client) send(msg message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = c.conn.Write(append(data, '\n'))
+	return err
+}
+
+// call sends a request and decodes its result into result. Cancelling
+// ctx stops waiting, but not the work the server started.
+func (c *Client) call(ctx context.Context, method string, params, result any) error {
+	ch := make(chan response, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	forget := func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}
+	if err := c.send(message{JSONRPC: "2.0", ID: json.RawMessage(strconv.FormatInt(id, 10)), Method: method, Params: params}); err != nil {
+		forget()
+		return err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.err != nil {
+			return resp.err
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.result, result)
+	case <-ctx.Done():
+		forget()
+		return ctx.Err()
+	}
+}
+
+// Ping checks that the server is answering
+func (c *Client) Ping(ctx context.Context) error {
+	return c.call(ctx, "ping", map[string]any{}, nil)
+}
@@ -0,0 +1,34 @@
+package client
+
+import "context"
+
+// Domain is a domain in the server's local domains database
+type Domain struct {
+	Domain    string `json:"domain"`
+	Status    string `json:"status"`
+	Registrar string `json:"registrar,omitempty"`
+	// DNSInit is nil until fqdnmgr has checked the initial DNS records
+	DNSInit *bool `json:"dnsInit,omitempty"`
+	// CertDate is when the certificate was issued, as YYYY-MM-DD
+	CertDate    string `json:"certDate,omitempty"`
+	CertExpires string `json:"certExpires,omitempty"`
+	// DaysLeft is nil without a certificate and negative once it expired
+	DaysLeft   *int `json:"daysLeft,omitempty"`
+	RenewalDue bool `json:"renewalDue,omitempty"`
+}
+
+// Domains returns the domains database of host, or of the server's own
+// machine when host is empty, with certificate expiry worked out
+func (c *Client) Domains(ctx context.Context, host string) ([]Domain, error) {
+	args := map[string]any{}
+	if host != "" {
+		args["host"] = host
+	}
+	var result struct {
+		Domains []Domain `json:"domains"`
+	}
+	if err := c.callStructured(ctx, "list_domains", args, &result); err != nil {
+		return nil, err
+	}
+	return result.Domains, nil
+}
@@ -0,0 +1,222 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// JobStatus is where a job is in its life
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job is the state of an async tool's job when the server answered
+type Job struct {
+	ID     string    `json:"jobId"`
+	Tool   string    `json:"tool"`
+	Host   string    `json:"host,omitempty"`
+	Status JobStatus `json:"status"`
+	// ExitCode is set once the job has finished
+	ExitCode *int `json:"exitCode,omitempty"`
+	// Cancelled is set when CancelJob stopped the job
+	Cancelled bool `json:"cancelled,omitempty"`
+
+	Priority       string     `json:"priority"`
+	QueuePosition  int        `json:"queuePosition,omitempty"`
+	WaitingFor     string     `json:"waitingFor,omitempty"`
+	EstimatedStart *time.Time `json:"estimatedStart,omitempty"`
+	Locks          []string   `json:"locks,omitempty"`
+
+	ParentID string `json:"parentId,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Steps    []Step `json:"steps,omitempty"`
+
+	Attempts    int     `json:"attempts,omitempty"`
+	MaxAttempts int     `json:"maxAttempts,omitempty"`
+	Prompt      *Prompt `json:"prompt,omitempty"`
+
+	// Output holds the last lines the job printed
+	Output    string       `json:"output,omitempty"`
+	Stderr    string       `json:"stderr,omitempty"`
+	Artifacts []FileChange `json:"artifacts,omitempty"`
+
+	QueuedAt  time.Time  `json:"queuedAt"`
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	EndedAt   *time.Time `json:"endedAt,omitempty"`
+
+	// CheckInterval is how often the server suggests polling a job it
+	// just started
+	CheckInterval string `json:"checkInterval,omitempty"`
+	// WaitReason says why a wait returned
+	WaitReason string `json:"waitReason,omitempty"`
+	// Duplicate marks a job an earlier call with the same idempotency
+	// key started; no new job was started
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// Done reports whether the job has finished
+func (j *Job) Done() bool {
+	return j.Status == JobCompleted || j.Status == JobFailed
+}
+
+// Succeeded reports whether the job finished with exit code 0
+func (j *Job) Succeeded() bool {
+	return j.Status == JobCompleted
+}
+
+// Step is one step of a pipeline job
+type Step struct {
+	ID        string   `json:"id"`
+	Tool      string   `json:"tool"`
+	Status    string   `json:"status"`
+	JobID     string   `json:"jobId,omitempty"`
+	Attempts  int      `json:"attempts,omitempty"`
+	ExitCode  int      `json:"exitCode"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// Prompt is a question an interactive job is waiting to have answered
+type Prompt struct {
+	Text string `json:"text"`
+	// Source is stdout, stderr or terminal
+	Source    string    `json:"source"`
+	Since     time.Time `json:"since"`
+	Sensitive bool      `json:"sensitive,omitempty"`
+}
+
+// FileChange is a watched file a tool changed
+type FileChange struct {
+	Path string `json:"path"`
+	// Change is created, modified or deleted
+	Change string `json:"change"`
+	Diff   string `json:"diff,omitempty"`
+}
+
+// CommandResult is how a sync tool's script ran
+type CommandResult struct {
+	ExitCode int          `json:"exitCode"`
+	Stdout   string       `json:"stdout"`
+	Stderr   string       `json:"stderr"`
+	Changes  []FileChange `json:"changes,omitempty"`
+}
+
+// OK reports whether the script exited with 0
+func (r *CommandResult) OK() bool {
+	return r.ExitCode == 0
+}
+
+// GetJob returns a job's current state
+func (c *Client) GetJob(ctx context.Context, jobID string) (*Job, error) {
+	return c.jobCall(ctx, "check_job_status", map[string]any{"jobId": jobID})
+}
+
+// ListJobs returns the server's jobs, newest first, without their output.
+// An empty status or tool lists all.
+func (c *Client) ListJobs(ctx context.Context, status JobStatus, tool string) ([]Job, error) {
+	args := map[string]any{}
+	if status != "" {
+		args["status"] = status
+	}
+	if tool != "" {
+		args["tool"] = tool
+	}
+	var result struct {
+		Jobs []Job `json:"jobs"`
+	}
+	if err := c.callStructured(ctx, "list_jobs", args, &result); err != nil {
+		return nil, err
+	}
+	return result.Jobs, nil
+}
+
+// WaitOptions bound one wait_job call
+type WaitOptions struct {
+	// Pattern also ends the wait when the output or stderr matches this
+	// regular expression
+	Pattern string
+	// MaxWait is capped by the server, at two minutes
+	MaxWait time.Duration
+}
+
+// WaitJob blocks in the server until the job finishes, asks for input,
+// matches opts.Pattern, or opts.MaxWait passes; Job.WaitReason says which
+func (c *Client) WaitJob(ctx context.Context, jobID string, opts WaitOptions) (*Job, error) {
+	args := map[string]any{"jobId": jobID}
+	if opts.Pattern != "" {
+		args["pattern"] = opts.Pattern
+	}
+	if opts.MaxWait > 0 {
+		secs := int(opts.MaxWait / time.Second)
+		if secs < 1 {
+			secs = 1
+		}
+		args["maxWaitSeconds"] = secs
+	}
+	return c.jobCall(ctx, "wait_job", args)
+}
+
+// Wait waits until the job finishes or asks for input, however long
+// that takes, and returns its state. Stop it with ctx.
+func (c *Client) Wait(ctx context.Context, jobID string) (*Job, error) {
+	for {
+		job, err := c.WaitJob(ctx, jobID, WaitOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if job.Done() || job.Prompt != nil {
+			return job, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return job, err
+		}
+	}
+}
+
+// Input answers an interactive job's prompt
+type Input struct {
+	// Text is sent as one line
+	Text string
+	// Target is stdin or terminal; by default where the prompt came from
+	Target string
+	// NoNewline sends Text without pressing Enter
+	NoNewline bool
+	// Secret keeps Text out of the job's output
+	Secret bool
+	// EOF closes the job's input after Text
+	EOF bool
+}
+
+// SendInput answers a job's prompt and returns its state a moment later
+func (c *Client) SendInput(ctx context.Context, jobID string, in Input) (*Job, error) {
+	args := map[string]any{
+		"jobId":   jobID,
+		"input":   in.Text,
+		"newline": !in.NoNewline,
+		"secret":  in.Secret,
+		"eof":     in.EOF,
+	}
+	if in.Target != "" {
+		args["target"] = in.Target
+	}
+	return c.jobCall(ctx, "send_job_input", args)
+}
+
+// CancelJob stops a queued or running job and returns its state once
+// it has ended, or after a few seconds if the script is slow to exit
+func (c *Client) CancelJob(ctx context.Context, jobID string) (*Job, error) {
+	return c.jobCall(ctx, "cancel_job", map[string]any{"jobId": jobID})
+}
+
+// jobCall calls a tool that reports on a job
+func (c *Client) jobCall(ctx context.Context, tool string, args any) (*Job, error) {
+	var job Job
+	if err := c.callStructured(ctx, tool, args, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
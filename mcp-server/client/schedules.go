@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// Schedule runs a tool on a cron schedule
+type Schedule struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name,omitempty"`
+	Cron      string         `json:"cron"`
+	Tool      string         `json:"tool"`
+	Arguments map[string]any `json:"arguments,omitempty"`
+	CreatedAt time.Time      `json:"createdAt"`
+
+	NextRun    time.Time `json:"nextRun"`
+	LastRun    time.Time `json:"lastRun"`
+	LastJobID  string    `json:"lastJobId,omitempty"`
+	LastStatus JobStatus `json:"lastStatus,omitempty"`
+	LastError  string    `json:"lastError,omitempty"`
+}
+
+// CreateSchedule runs tool with args whenever cron, a five-field
+// expression in the server's local time, matches
+func (c *Client) CreateSchedule(ctx context.Context, name, cron, tool string, args map[string]any) (*Schedule, error) {
+	var sch Schedule
+	err := c.callStructured(ctx, "schedule_create", map[string]any{
+		"name":      name,
+		"cron":      cron,
+		"tool":      tool,
+		"arguments": args,
+	}, &sch)
+	if err != nil {
+		return nil, err
+	}
+	return &sch, nil
+}
+
+// ListSchedules returns every schedule with its last and next run
+func (c *Client) ListSchedules(ctx context.Context) ([]Schedule, error) {
+	var result struct {
+		Schedules []Schedule `json:"schedules"`
+	}
+	if err := c.callStructured(ctx, "schedule_list", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Schedules, nil
+}
+
+// DeleteSchedule removes a schedule; jobs it started keep running
+func (c *Client) DeleteSchedule(ctx context.Context, id string) error {
+	_, err := c.CallTool(ctx, "schedule_delete", map[string]any{"scheduleId": id})
+	return err
+}
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"context"
+	"strings"
+)
+
+// JobOptions are accepted by every tool that starts a job
+type JobOptions struct {
+	// Priority is low, normal or high
+	Priority string `json:"priority,omitempty"`
+	// Notify lists where to report the job when it finishes
+	Notify []NotifyTarget `json:"notify,omitempty"`
+	// IdempotencyKey makes a repeated call return the original job
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// Host is the inventory host to run the script on; empty is local
+	Host string `json:"host,omitempty"`
+}
+
+// NotifyTarget is one place to report a finished job
+type NotifyTarget struct {
+	Webhook string `json:"webhook,omitempty"`
+	Email   string `json:"email,omitempty"`
+	// On is always, success or failure
+	On string `json:"on,omitempty"`
+}
+
+// SiteArgs configure a virtual host with a2sitemgr
+type SiteArgs struct {
+	FQDN string `json:"fqdn"`
+	// Mode is domain, proxypass or swc
+	Mode      string `json:"mode,omitempty"`
+	Registrar string `json:"registrar,omitempty"`
+	// Port is the backend port in proxypass mode
+	Port              int  `json:"port,omitempty"`
+	Secured           bool `json:"secured,omitempty"`
+	SetInitDNSRecords bool `json:"setInitDNSRecords,omitempty"`
+	Override          bool `json:"override,omitempty"`
+	Verbose           bool `json:"verbose,omitempty"`
+	// Interactive lets the script prompt; answer with SendInput
+	Interactive bool `json:"interactive,omitempty"`
+	JobOptions
+}
+
+// CreateSite configures an Apache virtual host
+func (c *Client) CreateSite(ctx context.Context, args SiteArgs) (*Job, error) {
+	return c.StartJob(ctx, "a2sitemgr", args)
+}
+
+// CheckDomainArgs check a domain with fqdnmgr
+type CheckDomainArgs struct {
+	FQDN string `json:"fqdn"`
+	// Registrar is asked instead of the local database
+	Registrar string `json:"registrar,omitempty"`
+	Verbose   bool   `json:"verbose,omitempty"`
+	Host      string `json:"host,omitempty"`
+}
+
+// CheckDomain reports whether a domain is free, owned, taken or
+// unavailable
+func (c *Client) CheckDomain(ctx context.Context, args CheckDomainArgs) (*CommandResult, error) {
+	return c.RunCommand(ctx, "fqdnmgr_check", args)
+}
+
+// PurchaseArgs buy a domain with fqdnmgr
+type PurchaseArgs struct {
+	FQDN      string `json:"fqdn"`
+	Registrar string `json:"registrar"`
+	Verbose   bool   `json:"verbose,omitempty"`
+	JobOptions
+}
+
+// Purchase buys a domain. Without an IdempotencyKey the server derives
+// one from the arguments, so a retried call does not buy it twice.
+func (c *Client) Purchase(ctx context.Context, args PurchaseArgs) (*Job, error) {
+	return c.StartJob(ctx, "fqdnmgr_purchase", args)
+}
+
+// ListDomainsArgs list domains with fqdnmgr
+type ListDomainsArgs struct {
+	Registrar string `json:"registrar,omitempty"`
+	// Source is local or remote
+	Source  string `json:"source,omitempty"`
+	Verbose bool   `json:"verbose,omitempty"`
+	Host    string `json:"host,omitempty"`
+}
+
+// ListDomains lists domains from the local database or a registrar
+func (c *Client) ListDomains(ctx context.Context, args ListDomainsArgs) (*CommandResult, error) {
+	return c.RunCommand(ctx, "fqdnmgr_list", args)
+}
+
+// InitDNSArgs set a domain's initial DNS records with fqdnmgr
+type InitDNSArgs struct {
+	Domains   []string `json:"-"`
+	Registrar string   `json:"registrar"`
+	Override  bool     `json:"override,omitempty"`
+	Verbose   bool     `json:"verbose,omitempty"`
+	// Interactive lets the script prompt; answer with SendInput
+	Interactive bool `json:"interactive,omitempty"`
+	JobOptions
+}
+
+// SetInitDNSRecords sets the A @, A * and MX @ records of domains. Poll
+// CheckInitDNS to see them propagate.
+func (c *Client) SetInitDNSRecords(ctx context.Context, args InitDNSArgs) (*Job, error) {
+	return c.StartJob(ctx, "fqdnmgr_setInitDNSRecords", struct {
+		Domains string `json:"domains,omitempty"`
+		InitDNSArgs
+	}{strings.Join(args.Domains, " "), args})
+}
+
+// SyncArgs are accepted by the sync tools that take only a host and
+// verbose output
+type SyncArgs struct {
+	Verbose bool   `json:"verbose,omitempty"`
+	Host    string `json:"host,omitempty"`
+}
+
+// CheckInitDNS checks whether a domain's initial DNS records have
+// propagated. The script exits non-zero until they have.
+func (c *Client) CheckInitDNS(ctx context.Context, fqdn string, args SyncArgs) (*CommandResult, error) {
+	return c.RunCommand(ctx, "fqdnmgr_checkInitDns", struct {
+		FQDN string `json:"fqdn"`
+		SyncArgs
+	}{fqdn, args})
+}
+
+// DeleteCredentials removes the stored credentials of a DNS provider
+func (c *Client) DeleteCredentials(ctx context.Context, provider string, args SyncArgs) (*CommandResult, error) {
+	return c.RunCommand(ctx, "fqdncredmgr_delete", struct {
+		Provider string `json:"provider"`
+		SyncArgs
+	}{provider, args})
+}
+
+// ListCredentials lists the stored DNS provider credentials, with the
+// usernames masked
+func (c *Client) ListCredentials(ctx context.Context, args SyncArgs) (*CommandResult, error) {
+	return c.RunCommand(ctx, "fqdncredmgr_list", args)
+}
+
+// RecalcWildcards recalculates Apache wildcard subdomain configurations,
+// for one wildcard domain such as "mail.*" or for all when it is empty
+func (c *Client) RecalcWildcards(ctx context.Context, wildcardDomain, host string) (*CommandResult, error) {
+	return c.RunCommand(ctx, "a2wcrecalc", map[string]any{
+		"wildcardDomain": wildcardDomain,
+		"host":           host,
+	})
+}
+
+// RecalcWildcardsDMS recalculates the wildcard configurations and the
+// SNI certificate maps of docker-mailserver. An empty dmsDir uses the
+// server's.
+func (c *Client) RecalcWildcardsDMS(ctx context.Context, dmsDir, host string) (*CommandResult, error) {
+	args := map[string]any{"host": host}
+	if dmsDir != "" {
+		args["dmsDir"] = dmsDir
+	}
+	return c.RunCommand(ctx, "a2wcrecalc_dms", args)
+}
+
+// RenewCertificates renews the certificates that expire within 10 days
+func (c *Client) RenewCertificates(ctx context.Context, opts JobOptions) (*Job, error) {
+	return c.StartJob(ctx, "a2certrenew", opts)
+}
+
+// PipelineStep is one tool call in a pipeline
+type PipelineStep struct {
+	// ID names the step for DependsOn; step1, step2 and so on by default
+	ID        string         `json:"id,omitempty"`
+	Tool      string         `json:"tool"`
+	Arguments map[string]any `json:"arguments,omitempty"`
+	DependsOn []string       `json:"dependsOn,omitempty"`
+	// OnFailure is abort, skip or continue
+	OnFailure string `json:"onFailure,omitempty"`
+	// Poll reruns a sync step until it succeeds
+	Poll *Poll `json:"poll,omitempty"`
+}
+
+// Poll reruns a step every IntervalSeconds until it succeeds or
+// TimeoutSeconds pass
+type Poll struct {
+	IntervalSeconds int `json:"intervalSeconds,omitempty"`
+	TimeoutSeconds  int `json:"timeoutSeconds,omitempty"`
+}
+
+// RunPipeline runs tool calls in dependency order as one job
+func (c *Client) RunPipeline(ctx context.Context, steps []PipelineStep, opts JobOptions) (*Job, error) {
+	return c.StartJob(ctx, "run_pipeline", struct {
+		Steps []PipelineStep `json:"steps"`
+		JobOptions
+	}{steps, opts})
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Tool describes one of the server's tools
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+}
+
+// Result is a tool call's result
+type Result struct {
+	// Text is the result as the server words it for people
+	Text    string
+	IsError bool
+	// Structured is the result as JSON, when the tool gives one
+	Structured json.RawMessage
+}
+
+// Decode decodes the structured result into v
+func (r *Result) Decode(v any) error {
+	if len(r.Structured) == 0 {
+		return errors.New("client: the result has no structured content")
+	}
+	return json.Unmarshal(r.Structured, v)
+}
+
+// ListTools returns the tools the server offers
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var result struct {
+		Tools []Tool `json:"tools"`
+	}
+	if err := c.call(ctx, "tools/list", map[string]any{}, &result); err != nil {
+		return nil, err
+	}
+	return result.Tools, nil
+}
+
+// CallTool calls a tool with args, a struct or map that encodes to a
+// JSON object, or nil. A call the server reports as failed returns the
+// result together with a *ToolError.
+func (c *Client) CallTool(ctx context.Context, name string, args any) (*Result, error) {
+	if args == nil {
+		args = map[string]any{}
+	}
+	var result struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		IsError           bool            `json:"isError"`
+		StructuredContent json.RawMessage `json:"structuredContent"`
+	}
+	if err := c.call(ctx, "tools/call", map[string]any{"name": name, "arguments": args}, &result); err != nil {
+		return nil, err
+	}
+
+	r := &Result{IsError: result.IsError, Structured: result.StructuredContent}
+	for _, block := range result.Content {
+		if block.Type == "text" {
+			r.Text += block.Text
+		}
+	}
+	if r.IsError {
+		return r, &ToolError{Tool: name, Message: r.Text}
+	}
+	return r, nil
+}
+
+// callStructured calls a tool and decodes its structured result into v
+func (c *Client) callStructured(ctx context.Context, name string, args, v any) error {
+	r, err := c.CallTool(ctx, name, args)
+	if err != nil {
+		return err
+	}
+	if err := r.Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// StartJob calls an async tool, including one from the server's tools
+// directory, and returns the job it started
+func (c *Client) StartJob(ctx context.Context, tool string, args any) (*Job, error) {
+	return c.jobCall(ctx, tool, args)
+}
+
+// RunCommand calls a sync tool, including one from the server's tools
+// directory, and returns how its script ran. A script that exits non-zero
+// is not an error; check CommandResult.ExitCode.
+func (c *Client) RunCommand(ctx context.Context, tool string, args any) (*CommandResult, error) {
+	var res CommandResult
+	if err := c.callStructured(ctx, tool, args, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
@@ -0,0 +1,12 @@
+// Command a2cmds-mcp serves the a2cmds scripts to MCP clients over stdio
+package main
+
+import (
+	"os"
+
+	mcpserver "github.com/a2cmds/mcp-server"
+)
+
+func main() {
+	os.Exit(mcpserver.Main(os.Args[1:]))
+}
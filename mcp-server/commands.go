@@ -0,0 +1,281 @@
+package mcpserver
+
+import (
+	"errors"
+	"fmt"
+)
+
+// commandBuilder turns tool arguments into the command the tool runs
+type commandBuilder func(args map[string]any) (JobSpec, error)
+
+// toolCommands maps every script-backed tool to its command builder.
+// Handlers, pipelines and other callers share these so a tool always runs
+// the same argv with the same locks.
+var toolCommands = map[string]commandBuilder{
+	"a2sitemgr":                 buildA2SiteMgr,
+	"fqdnmgr_check":             buildFQDNMgrCheck,
+	"fqdnmgr_purchase":          buildFQDNMgrPurchase,
+	"fqdnmgr_list":              buildFQDNMgrList,
+	"fqdnmgr_setInitDNSRecords": buildFQDNMgrSetInitDNS,
+	"fqdnmgr_checkInitDns":      buildFQDNMgrCheckInitDns,
+	"fqdncredmgr_delete":        buildFQDNCredMgrDelete,
+	"fqdncredmgr_list":          buildFQDNCredMgrList,
+	"a2wcrecalc":                buildA2WCRecalc,
+	"a2wcrecalc_dms":            buildA2WCRecalcDMS,
+	"a2certrenew":               buildA2CertRenew,
+}
+
+// buildToolCommand builds the command for a tool call by name
+func buildToolCommand(tool string, args map[string]any) (JobSpec, error) {
+	build, ok := toolCommands[tool]
+	if def := definedTool(tool); !ok && def != nil {
+		build, ok = def.build, true
+	}
+	if !ok {
+		return JobSpec{}, fmt.Errorf("unknown tool: %s", tool)
+	}
+	if !toolEnabled(tool) {
+		return JobSpec{}, fmt.Errorf("tool %s is disabled in the server configuration", tool)
+	}
+	// Pipeline steps and schedules come here without ExecuteTool
+	args = withToolDefaults(tool, args)
+	spec, err := build(args)
+	if err != nil {
+		return JobSpec{}, err
+	}
+	if err := validateArgv(tool, spec.Name, spec.Args); err != nil {
+		return JobSpec{}, err
+	}
+	if spec.Host, err = resolveHost(getString(args, "host", "")); err != nil {
+		return JobSpec{}, err
+	}
+	spec.Tool = tool
+	spec.Retry = retryPolicyFor(tool)
+	spec.PTY = spec.PTY || DefaultPTYTools[tool]
+	spec.Trace = traceParentFromArgs(args)
+	spec.Source = getString(args, sourceArgKey, "")
+	spec.Caller = getString(args, callerArgKey, "")
+	if spec.Host != "" {
+		// Each host has its own Apache; domains and registrars are shared
+		for i, key := range spec.Locks {
+			if key == LockApacheConfig {
+				spec.Locks[i] = hostLock(key, spec.Host)
+			}
+		}
+	} else {
+		// Artifacts are only recorded for local files
+		spec.Watch = watchPathsFor(tool, args)
+	}
+	return spec, nil
+}
+
+// buildA2SiteMgr - Configure Apache2 virtual hosts
+func buildA2SiteMgr(args map[string]any) (JobSpec, error) {
+	fqdn := getString(args, "fqdn", "")
+	if fqdn == "" {
+		return JobSpec{}, errors.New("fqdn is required")
+	}
+
+	interactive := getBool(args, "interactive", false)
+	cmdArgs := []string{"-d", fqdn}
+	if !interactive {
+		cmdArgs = append(cmdArgs, "-ni")
+	}
+	locks := []string{fqdnLock(fqdn), LockApacheConfig}
+
+	mode := getString(args, "mode", "domain")
+	if mode != "" && mode != "domain" {
+		cmdArgs = append(cmdArgs, "-m", mode)
+	}
+
+	if registrar := getString(args, "registrar", ""); registrar != "" {
+		cmdArgs = append(cmdArgs, "-r", registrar)
+		locks = append(locks, registrarLock(registrar))
+	}
+
+	if port := getInt(args, "port", 0); port > 0 {
+		cmdArgs = append(cmdArgs, "-p", fmt.Sprintf("%d", port))
+	}
+
+	if getBool(args, "secured", false) {
+		cmdArgs = append(cmdArgs, "-s")
+	}
+
+	if getBool(args, "setInitDNSRecords", false) {
+		cmdArgs = append(cmdArgs, "--setInitDNSRecords")
+	}
+
+	if getBool(args, "override", false) {
+		cmdArgs = append(cmdArgs, "-o")
+	}
+
+	if getBool(args, "verbose", true) {
+		cmdArgs = append(cmdArgs, "-v")
+	}
+
+	return JobSpec{Name: "a2sitemgr", Args: cmdArgs, Locks: locks, Interactive: interactive}, nil
+}
+
+// buildFQDNMgrPurchase - Purchase domain
+func buildFQDNMgrPurchase(args map[string]any) (JobSpec, error) {
+	fqdn := getString(args, "fqdn", "")
+	registrar := getString(args, "registrar", "")
+
+	if fqdn == "" || registrar == "" {
+		return JobSpec{}, errors.New("fqdn and registrar are required")
+	}
+
+	cmdArgs := []string{"purchase", fqdn, registrar, "-ni"}
+
+	if getBool(args, "verbose", true) {
+		cmdArgs = append(cmdArgs, "-v")
+	}
+
+	locks := []string{fqdnLock(fqdn), registrarLock(registrar)}
+
+	return JobSpec{Name: "fqdnmgr", Args: cmdArgs, Locks: locks}, nil
+}
+
+// buildFQDNMgrSetInitDNS - Set initial DNS records
+func buildFQDNMgrSetInitDNS(args map[string]any) (JobSpec, error) {
+	domains := getString(args, "domains", "")
+	registrar := getString(args, "registrar", "")
+	interactive := getBool(args, "interactive", false)
+
+	// Without domains, fqdnmgr lists the registrar's domains and asks
+	// which ones to set up
+	if registrar == "" || (domains == "" && !interactive) {
+		return JobSpec{}, errors.New("domains and registrar are required (domains may be omitted with interactive: true)")
+	}
+
+	cmdArgs := []string{"setInitDNSRecords"}
+	if domains != "" {
+		cmdArgs = append(cmdArgs, "-d", domains)
+	}
+	cmdArgs = append(cmdArgs, "-r", registrar)
+	if !interactive {
+		cmdArgs = append(cmdArgs, "-ni")
+	}
+
+	if getBool(args, "override", false) {
+		cmdArgs = append(cmdArgs, "-o")
+	}
+
+	if getBool(args, "verbose", true) {
+		cmdArgs = append(cmdArgs, "-v")
+	}
+
+	locks := append(domainLocks(domains), registrarLock(registrar))
+
+	return JobSpec{Name: "fqdnmgr", Args: cmdArgs, Locks: locks, Interactive: interactive}, nil
+}
+
+// buildA2CertRenew - Certificate renewal
+func buildA2CertRenew(args map[string]any) (JobSpec, error) {
+	return JobSpec{Name: "a2certrenew", Locks: []string{LockApacheConfig}}, nil
+}
+
+// buildFQDNMgrCheck - Check domain status
+func buildFQDNMgrCheck(args map[string]any) (JobSpec, error) {
+	fqdn := getString(args, "fqdn", "")
+	if fqdn == "" {
+		return JobSpec{}, errors.New("fqdn is required")
+	}
+
+	cmdArgs := []string{"check", fqdn, "-ni"}
+
+	if registrar := getString(args, "registrar", ""); registrar != "" {
+		cmdArgs = append(cmdArgs, registrar)
+	}
+
+	if getBool(args, "verbose", false) {
+		cmdArgs = append(cmdArgs, "-v")
+	}
+
+	return JobSpec{Name: "fqdnmgr", Args: cmdArgs}, nil
+}
+
+// buildFQDNMgrList - List domains
+func buildFQDNMgrList(args map[string]any) (JobSpec, error) {
+	cmdArgs := []string{"list", "-ni"}
+
+	if registrar := getString(args, "registrar", ""); registrar != "" {
+		cmdArgs = append(cmdArgs, registrar)
+	}
+
+	source := getString(args, "source", "local")
+	if source != "" {
+		cmdArgs = append(cmdArgs, source)
+	}
+
+	if getBool(args, "verbose", false) {
+		cmdArgs = append(cmdArgs, "-v")
+	}
+
+	return JobSpec{Name: "fqdnmgr", Args: cmdArgs}, nil
+}
+
+// buildFQDNMgrCheckInitDns - Check DNS propagation
+func buildFQDNMgrCheckInitDns(args map[string]any) (JobSpec, error) {
+	fqdn := getString(args, "fqdn", "")
+	if fqdn == "" {
+		return JobSpec{}, errors.New("fqdn is required")
+	}
+
+	cmdArgs := []string{"checkInitDns", fqdn, "-ni"}
+
+	if getBool(args, "verbose", false) {
+		cmdArgs = append(cmdArgs, "-v")
+	}
+
+	return JobSpec{Name: "fqdnmgr", Args: cmdArgs}, nil
+}
+
+// buildFQDNCredMgrDelete - Delete credentials
+func buildFQDNCredMgrDelete(args map[string]any) (JobSpec, error) {
+	provider := getString(args, "provider", "")
+	if provider == "" {
+		return JobSpec{}, errors.New("provider is required")
+	}
+
+	cmdArgs := []string{"delete", provider}
+
+	if getBool(args, "verbose", false) {
+		cmdArgs = append(cmdArgs, "-v")
+	}
+
+	return JobSpec{Name: "fqdncredmgr", Args: cmdArgs, Locks: []string{registrarLock(provider)}}, nil
+}
+
+// buildFQDNCredMgrList - List credentials
+func buildFQDNCredMgrList(args map[string]any) (JobSpec, error) {
+	cmdArgs := []string{"list"}
+
+	if getBool(args, "verbose", false) {
+		cmdArgs = append(cmdArgs, "-v")
+	}
+
+	return JobSpec{Name: "fqdncredmgr", Args: cmdArgs}, nil
+}
+
+// buildA2WCRecalc - Recalculate wildcard subdomains
+func buildA2WCRecalc(args map[string]any) (JobSpec, error) {
+	var cmdArgs []string
+
+	if wildcardDomain := getString(args, "wildcardDomain", ""); wildcardDomain != "" {
+		cmdArgs = append(cmdArgs, wildcardDomain)
+	}
+
+	return JobSpec{Name: "a2wcrecalc", Args: cmdArgs, Locks: []string{LockApacheConfig}}, nil
+}
+
+// buildA2WCRecalcDMS - Recalculate for Docker-Mailserver
+func buildA2WCRecalcDMS(args map[string]any) (JobSpec, error) {
+	var cmdArgs []string
+
+	if dmsDir := getString(args, "dmsDir", ""); dmsDir != "" {
+		cmdArgs = append(cmdArgs, dmsDir)
+	}
+
+	return JobSpec{Name: "a2wcrecalc-dms", Args: cmdArgs, Locks: []string{LockApacheConfig}}, nil
+}
@@ -0,0 +1,565 @@
+package mcpserver
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigFile is read at startup and again on SIGHUP
+const DefaultConfigFile = "/etc/a2cmds-mcp/config.yaml"
+
+// DefaultCheckIntervals is how often clients are told to poll a tool's
+// jobs, or to check DNS propagation again
+var DefaultCheckIntervals = map[string]time.Duration{
+	"a2sitemgr":                 30 * time.Second,
+	"fqdnmgr_purchase":          30 * time.Second,
+	"fqdnmgr_setInitDNSRecords": 60 * time.Second,
+	"fqdnmgr_checkInitDns":      60 * time.Second,
+	"a2certrenew":               60 * time.Second,
+}
+
+// Config is the server configuration file. Every setting has a default,
+// so the file only lists what differs. Command-line flags override it.
+type Config struct {
+	// Binaries maps script names to the paths they run from instead of
+	// being looked up in PATH. Remote hosts always use their PATH.
+	Binaries map[string]string `yaml:"binaries"`
+	// DMSDir is passed to a2wcrecalc_dms as DMS_DIR
+	DMSDir string `yaml:"dmsDir"`
+	// MaxOutputLines is how much of a job's output is kept
+	MaxOutputLines int `yaml:"maxOutputLines"`
+
+	Timeouts  ConfigTimeouts  `yaml:"timeouts"`
+	Retention ConfigRetention `yaml:"retention"`
+	Queue     ConfigQueue     `yaml:"queue"`
+
+	// ToolsDir holds tool definitions; see LoadToolDefinitions
+	ToolsDir string `yaml:"toolsDir"`
+	// Tools holds per-tool settings by tool name
+	Tools map[string]*ToolConfig `yaml:"tools"`
+
+	// Transports are read at startup only
+	Transports ConfigTransports `yaml:"transports"`
+	// Auth says which callers may call which tools
+	Auth ConfigAuth `yaml:"auth"`
+	// AuditLog appends a JSON line for every tool call; empty disables it
+	AuditLog string `yaml:"auditLog"`
+	// Notify limits the notifications tool calls may ask for
+	Notify ConfigNotify `yaml:"notify"`
+
+	// definitions are the tools loaded from ToolsDir
+	definitions map[string]*ToolDefinition
+}
+
+// ConfigTimeouts bound how long the server waits
+type ConfigTimeouts struct {
+	// Input closes an interactive job's input once a prompt goes
+	// unanswered this long; 0 waits forever
+	Input time.Duration `yaml:"input"`
+	// Shutdown is how long running jobs are waited for on shutdown
+	Shutdown time.Duration `yaml:"shutdown"`
+}
+
+// ConfigRetention says how long finished work is remembered
+type ConfigRetention struct {
+	// Jobs is how long finished jobs stay queryable
+	Jobs time.Duration `yaml:"jobs"`
+	// Idempotency is how long idempotency keys are kept
+	Idempotency time.Duration `yaml:"idempotency"`
+}
+
+// ConfigQueue sizes the worker pool
+type ConfigQueue struct {
+	Workers    int            `yaml:"workers"`
+	ToolLimits map[string]int `yaml:"toolLimits"`
+}
+
+// ToolConfig holds one tool's settings
+type ToolConfig struct {
+	// Enabled false hides the tool and refuses calls to it
+	Enabled *bool `yaml:"enabled"`
+	// Defaults are used for arguments a call leaves out
+	Defaults map[string]any `yaml:"defaults"`
+	// CheckInterval is how often clients are told to check on the tool
+	CheckInterval time.Duration `yaml:"checkInterval"`
+	// Retry changes when the tool's failed jobs are rerun
+	Retry *ConfigRetry `yaml:"retry"`
+	// Profile changes the environment and resources of the tool's scripts
+	Profile *ConfigProfile `yaml:"profile"`
+}
+
+// ConfigProfile changes a tool's execution profile. Settings left out keep
+// the tool's built-in profile; 0 turns a limit, nice or ioClass off.
+type ConfigProfile struct {
+	CPUSeconds  *uint64 `yaml:"cpuSeconds"`
+	MemoryBytes *uint64 `yaml:"memoryBytes"`
+	OpenFiles   *uint64 `yaml:"openFiles"`
+	Nice        *int    `yaml:"nice"`
+	IOClass     *int    `yaml:"ioClass"`
+	IOPriority  *int    `yaml:"ioPriority"`
+	// Env replaces the variables passed through from the server's
+	// environment; SetEnv adds to the tool's variables
+	Env    []string          `yaml:"env"`
+	SetEnv map[string]string `yaml:"setEnv"`
+	Dir    string            `yaml:"dir"`
+	Cgroup string            `yaml:"cgroup"`
+}
+
+// ConfigRetry changes a tool's retry policy. Settings left out keep the
+// tool's default policy, or the transient failures of the built-in
+// policies for a tool that has none. maxAttempts 1 turns retries off.
+type ConfigRetry struct {
+	MaxAttempts    int           `yaml:"maxAttempts"`
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+	// ExitCodes and Patterns replace the retryable exit codes and the
+	// regular expressions matched against stderr
+	ExitCodes []int    `yaml:"exitCodes"`
+	Patterns  []string `yaml:"patterns"`
+
+	patterns []*regexp.Regexp
+}
+
+// ConfigNotify limits the notify argument of tool calls. The global rules
+// in the notify config file are the administrator's and are not limited.
+type ConfigNotify struct {
+	// WebhookHosts are the hosts a call's webhook may point at, as
+	// "hooks.example.com" or "*.example.com"; empty refuses webhooks
+	WebhookHosts []string `yaml:"webhookHosts"`
+	// EmailDomains are the domains a call's email may be sent to, as
+	// "example.com" or "*.example.com"; empty refuses email
+	EmailDomains []string `yaml:"emailDomains"`
+}
+
+// ConfigTransports are the endpoints the server uses besides stdio
+type ConfigTransports struct {
+	// MetricsListen serves Prometheus /metrics; empty disables it
+	MetricsListen string `yaml:"metricsListen"`
+	// ExecHelper runs scripts through the privileged helper on this socket
+	ExecHelper string `yaml:"execHelper"`
+	// Listen serves MCP clients on this unix socket besides stdio
+	Listen string `yaml:"listen"`
+	// ListenGroup may connect to the Listen socket; without it only the
+	// server's user can
+	ListenGroup string `yaml:"listenGroup"`
+	// HTTPListen serves the REST API on this address; empty disables it
+	HTTPListen string `yaml:"httpListen"`
+}
+
+// ConfigAuth identifies callers besides the stdio client, which is
+// always trusted
+type ConfigAuth struct {
+	// Tokens are the REST API's bearer tokens, by caller name
+	Tokens map[string]*TokenConfig `yaml:"tokens"`
+	// Users are the Unix users that may call tools over the Listen
+	// socket. When it is empty, anyone who can connect may.
+	Users map[string]*AccessConfig `yaml:"users"`
+}
+
+// AccessConfig limits a caller to some tools
+type AccessConfig struct {
+	// Tools the caller may call; empty allows all
+	Tools []string `yaml:"tools"`
+	// Admin may see and act on every caller's jobs, not only its own
+	Admin bool `yaml:"admin"`
+}
+
+// TokenConfig is one REST API token
+type TokenConfig struct {
+	// SHA256 is the hex SHA-256 of the token, so the file does not
+	// hold the token itself
+	SHA256       string `yaml:"sha256"`
+	AccessConfig `yaml:",inline"`
+}
+
+// defaultConfig is the configuration without a file
+func defaultConfig() *Config {
+	limits := make(map[string]int, len(DefaultToolConcurrency))
+	for tool, n := range DefaultToolConcurrency {
+		limits[tool] = n
+	}
+	return &Config{
+		DMSDir:         DefaultDMSDir,
+		ToolsDir:       DefaultToolsDir,
+		MaxOutputLines: MaxOutputLines,
+		Timeouts: ConfigTimeouts{
+			Input:    DefaultInputTimeout,
+			Shutdown: DefaultShutdownTimeout,
+		},
+		Retention: ConfigRetention{
+			Jobs:        JobCleanupTimeout,
+			Idempotency: DefaultIdempotencyRetention,
+		},
+		Queue: ConfigQueue{
+			Workers:    DefaultWorkers,
+			ToolLimits: limits,
+		},
+	}
+}
+
+var activeConfig atomic.Pointer[Config]
+
+// config returns the configuration in effect. It is replaced as a whole
+// on reload, so a caller sees one consistent version.
+func config() *Config {
+	if c := activeConfig.Load(); c != nil {
+		return c
+	}
+	return defaultConfig()
+}
+
+// LoadConfig reads a configuration file, applies the command-line flags
+// and loads the tool definitions, then validates the result. A missing
+// file gives the defaults.
+func LoadConfig(path string, flags configFlags) (*Config, error) {
+	c := defaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	flags.apply(c)
+
+	if c.definitions, err = LoadToolDefinitions(c.ToolsDir); err != nil {
+		return nil, fmt.Errorf("tool definitions: %v", err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// validate checks every setting, so a bad file is refused as a whole
+func (c *Config) validate() error {
+	scripts := make(map[string]bool)
+	for _, rule := range argvRules {
+		scripts[rule.Script] = true
+	}
+	for _, def := range c.definitions {
+		scripts[def.Command] = true
+	}
+	for name, path := range c.Binaries {
+		if !scripts[name] {
+			return fmt.Errorf("binaries: unknown script %q", name)
+		}
+		if err := checkPath(path); err != nil {
+			return fmt.Errorf("binaries: %s: %v", name, err)
+		}
+	}
+	if err := checkPath(c.DMSDir); err != nil {
+		return fmt.Errorf("dmsDir: %v", err)
+	}
+	if c.MaxOutputLines < 1 {
+		return fmt.Errorf("maxOutputLines must be at least 1")
+	}
+	if c.Timeouts.Input < 0 || c.Timeouts.Shutdown < 0 {
+		return fmt.Errorf("timeouts cannot be negative")
+	}
+	if c.Retention.Jobs <= 0 || c.Retention.Idempotency <= 0 {
+		return fmt.Errorf("retention periods must be positive")
+	}
+	if c.Queue.Workers < 1 {
+		return fmt.Errorf("queue: workers must be at least 1")
+	}
+	if c.AuditLog != "" {
+		if err := checkPath(c.AuditLog); err != nil {
+			return fmt.Errorf("auditLog: %v", err)
+		}
+	}
+
+	tools := make(map[string]Tool)
+	for _, t := range append(append(builtinTools(), registeredTools()...), definedTools(c.definitions)...) {
+		tools[t.Name] = t
+	}
+	for name, n := range c.Queue.ToolLimits {
+		if _, ok := tools[name]; !ok {
+			return fmt.Errorf("queue: toolLimits: unknown tool %q", name)
+		}
+		if n < 1 {
+			return fmt.Errorf("queue: toolLimits: %s must be at least 1", name)
+		}
+	}
+	if err := c.Auth.validate(tools); err != nil {
+		return fmt.Errorf("auth: %v", err)
+	}
+	if c.Transports.HTTPListen != "" && len(c.Auth.Tokens) == 0 {
+		return fmt.Errorf("transports: httpListen needs auth.tokens")
+	}
+	if err := checkNotifyHosts(c.Notify.WebhookHosts); err != nil {
+		return fmt.Errorf("notify: webhookHosts: %v", err)
+	}
+	if err := checkNotifyHosts(c.Notify.EmailDomains); err != nil {
+		return fmt.Errorf("notify: emailDomains: %v", err)
+	}
+	for name, tc := range c.Tools {
+		tool, ok := tools[name]
+		if !ok {
+			return fmt.Errorf("tools: unknown tool %q", name)
+		}
+		if tc == nil {
+			c.Tools[name] = &ToolConfig{}
+			continue
+		}
+		if tc.CheckInterval < 0 {
+			return fmt.Errorf("tools: %s: checkInterval cannot be negative", name)
+		}
+		if tc.Retry != nil {
+			if err := tc.Retry.compile(name); err != nil {
+				return fmt.Errorf("tools: %s: retry: %v", name, err)
+			}
+		}
+		if tc.Profile != nil {
+			if err := tc.Profile.check(); err != nil {
+				return fmt.Errorf("tools: %s: profile: %v", name, err)
+			}
+		}
+		for arg, v := range tc.Defaults {
+			prop, ok := tool.InputSchema.Properties[arg]
+			if !ok || strings.HasPrefix(arg, "_") {
+				return fmt.Errorf("tools: %s: defaults: unknown argument %q", name, arg)
+			}
+			if err := checkDefaultType(prop, v); err != nil {
+				return fmt.Errorf("tools: %s: defaults: %s: %v", name, arg, err)
+			}
+		}
+	}
+	return nil
+}
+
+// compile checks the retry settings and compiles the patterns
+func (r *ConfigRetry) compile(tool string) error {
+	if neverRetried[tool] && r.MaxAttempts != 1 {
+		return fmt.Errorf("%s is never retried; only maxAttempts: 1 is allowed", tool)
+	}
+	if r.MaxAttempts < 0 || r.InitialBackoff < 0 || r.MaxBackoff < 0 {
+		return fmt.Errorf("values cannot be negative")
+	}
+	r.patterns = nil
+	if r.Patterns != nil {
+		r.patterns = make([]*regexp.Regexp, 0, len(r.Patterns))
+	}
+	for _, p := range r.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("patterns: %v", err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return nil
+}
+
+// check rejects profile settings nice, ionice or exec would refuse
+func (p *ConfigProfile) check() error {
+	if p.Nice != nil && (*p.Nice < -20 || *p.Nice > 19) {
+		return fmt.Errorf("nice must be between -20 and 19")
+	}
+	if p.IOClass != nil && (*p.IOClass < 0 || *p.IOClass > 3) {
+		return fmt.Errorf("ioClass must be between 0 and 3")
+	}
+	if p.IOPriority != nil && (*p.IOPriority < 0 || *p.IOPriority > 7) {
+		return fmt.Errorf("ioPriority must be between 0 and 7")
+	}
+	for _, name := range p.Env {
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("env: invalid variable name %q", name)
+		}
+	}
+	for name := range p.SetEnv {
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("setEnv: invalid variable name %q", name)
+		}
+	}
+	if p.Dir != "" && !filepath.IsAbs(p.Dir) {
+		return fmt.Errorf("dir must be an absolute path")
+	}
+	return nil
+}
+
+// checkDefaultType checks a default against the argument's schema type
+func checkDefaultType(prop Property, v any) error {
+	ok := true
+	switch prop.Type {
+	case "string":
+		_, ok = v.(string)
+	case "boolean":
+		_, ok = v.(bool)
+	case "integer":
+		_, ok = v.(int)
+	case "number":
+		switch v.(type) {
+		case int, float64:
+		default:
+			ok = false
+		}
+	}
+	if !ok {
+		return fmt.Errorf("must be a %s", prop.Type)
+	}
+	if s, isString := v.(string); isString && len(prop.Enum) > 0 {
+		for _, e := range prop.Enum {
+			if s == e {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(prop.Enum, ", "))
+	}
+	return nil
+}
+
+// toolEnabled reports whether a tool may be listed and called
+func toolEnabled(name string) bool {
+	tc := config().Tools[name]
+	return tc == nil || tc.Enabled == nil || *tc.Enabled
+}
+
+// withToolDefaults returns args with the tool's configured defaults
+// added for the arguments it leaves out
+func withToolDefaults(name string, args map[string]any) map[string]any {
+	tc := config().Tools[name]
+	if tc == nil || len(tc.Defaults) == 0 {
+		return args
+	}
+	merged := make(map[string]any, len(args)+len(tc.Defaults))
+	for k, v := range tc.Defaults {
+		// Arguments arrive as JSON, where every number is a float64
+		if n, ok := v.(int); ok {
+			v = float64(n)
+		}
+		merged[k] = v
+	}
+	for k, v := range args {
+		merged[k] = v
+	}
+	return merged
+}
+
+// checkInterval is how often clients are told to check on a tool
+func checkInterval(tool string) string {
+	d := DefaultCheckIntervals[tool]
+	if def := definedTool(tool); def != nil {
+		d = def.CheckInterval
+	}
+	if tc := config().Tools[tool]; tc != nil && tc.CheckInterval > 0 {
+		d = tc.CheckInterval
+	}
+	if d < 2*time.Minute && d%time.Second == 0 {
+		return fmt.Sprintf("%d seconds", int(d/time.Second))
+	}
+	return d.String()
+}
+
+// binaryPath is the executable a script runs from
+func binaryPath(name string) string {
+	if path, ok := config().Binaries[name]; ok {
+		return path
+	}
+	return name
+}
+
+// configFlags are the command-line flags that override the file, by
+// flag name
+type configFlags map[string]func(c *Config)
+
+// apply overrides c with the flags given on the command line
+func (f configFlags) apply(c *Config) {
+	flag.Visit(func(fl *flag.Flag) {
+		if set, ok := f[fl.Name]; ok {
+			set(c)
+		}
+	})
+}
+
+var reloadMu sync.Mutex
+
+// reloadConfig reads the file again and swaps it in. Running and queued
+// jobs keep the settings they started with; an invalid file is ignored.
+func reloadConfig(path string, flags configFlags) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	c, err := LoadConfig(path, flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Config reload failed, keeping the current configuration: %v\n", err)
+		return
+	}
+	old := config()
+	if c.Transports != old.Transports {
+		fmt.Fprintln(os.Stderr, "Config reload: transports change only after a restart")
+		c.Transports = old.Transports
+	}
+	activeConfig.Store(c)
+
+	if jobMgr != nil {
+		jobMgr.SetLimits(c.Queue.Workers, c.Queue.ToolLimits)
+	}
+	if idempotency != nil {
+		idempotency.SetRetention(c.Retention.Idempotency)
+	}
+	if !reflect.DeepEqual(disabledTools(old), disabledTools(c)) || !reflect.DeepEqual(definedTools(old.definitions), definedTools(c.definitions)) {
+		notifyToolsChanged()
+	}
+	fmt.Fprintf(os.Stderr, "Config reloaded from %s\n", path)
+}
+
+// disabledTools lists the tools a configuration disables
+func disabledTools(c *Config) []string {
+	var disabled []string
+	for name, tc := range c.Tools {
+		if tc.Enabled != nil && !*tc.Enabled {
+			disabled = append(disabled, name)
+		}
+	}
+	sort.Strings(disabled)
+	return disabled
+}
+
+// watchConfig reloads the configuration on SIGHUP
+func watchConfig(path string, flags configFlags) {
+	onSIGHUP(func() { reloadConfig(path, flags) })
+}
+
+// onSIGHUP calls reload for every SIGHUP the server gets
+func onSIGHUP(reload func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reload()
+		}
+	}()
+}
+
+// checkNotifyHosts checks and lowercases a list of host names, each of
+// which may start with "*." to match its subdomains
+func checkNotifyHosts(hosts []string) error {
+	for i, host := range hosts {
+		name := strings.TrimPrefix(host, "*.")
+		if name == "" || strings.ContainsAny(name, "/:*@ ") {
+			return fmt.Errorf("invalid host %q", host)
+		}
+		hosts[i] = strings.ToLower(host)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package mcpserver
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// useConfigFile writes body, with toolsDir pointing at an empty
+// directory, to a config file and starts the test from the defaults with
+// a fresh job queue. It returns the file's path and the queue.
+func useConfigFile(t *testing.T, body string) (string, *JobQueue) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	body = "toolsDir: " + dir + "\n" + body
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	oldConfig, oldJobMgr := activeConfig.Load(), jobMgr
+	jq := NewJobQueue(1, nil)
+	jobMgr = jq
+	conf := defaultConfig()
+	conf.ToolsDir = dir
+	conf.Transports.Listen = "/run/a2cmds-mcp/mcp.sock"
+	activeConfig.Store(conf)
+	t.Cleanup(func() {
+		activeConfig.Store(oldConfig)
+		jobMgr = oldJobMgr
+	})
+	return path, jq
+}
+
+func TestReloadConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantWorkers int
+		// wantQueue is the job queue's workers; it starts with 1
+		wantQueue  int
+		wantListen string
+	}{
+		{
+			name:        "new settings",
+			body:        "queue:\n  workers: 3\n",
+			wantWorkers: 3,
+			wantQueue:   3,
+			wantListen:  "/run/a2cmds-mcp/mcp.sock",
+		},
+		{
+			name:        "invalid file keeps the configuration",
+			body:        "queue:\n  workers: 3\nunknownSetting: true\n",
+			wantWorkers: DefaultWorkers,
+			wantQueue:   1,
+			wantListen:  "/run/a2cmds-mcp/mcp.sock",
+		},
+		{
+			name:        "transports wait for a restart",
+			body:        "queue:\n  workers: 3\ntransports:\n  listen: /tmp/other.sock\n",
+			wantWorkers: 3,
+			wantQueue:   3,
+			wantListen:  "/run/a2cmds-mcp/mcp.sock",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, jq := useConfigFile(t, tt.body)
+			reloadConfig(path, nil)
+
+			c := config()
+			if c.Queue.Workers != tt.wantWorkers {
+				t.Errorf("workers = %d, want %d", c.Queue.Workers, tt.wantWorkers)
+			}
+			if c.Transports.Listen != tt.wantListen {
+				t.Errorf("listen = %q, want %q", c.Transports.Listen, tt.wantListen)
+			}
+			jq.mu.RLock()
+			workers := jq.workers
+			jq.mu.RUnlock()
+			if workers != tt.wantQueue {
+				t.Errorf("job queue has %d workers, want %d", workers, tt.wantQueue)
+			}
+		})
+	}
+}
+
+func TestReloadConfigOnSIGHUP(t *testing.T) {
+	path, _ := useConfigFile(t, "maxOutputLines: 50\n")
+
+	// The handler outlives the test, so it only reports the signal
+	hup := make(chan struct{}, 1)
+	onSIGHUP(func() {
+		select {
+		case hup <- struct{}{}:
+		default:
+		}
+	})
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-hup:
+		reloadConfig(path, nil)
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGHUP did not reach the handler")
+	}
+	if got := config().MaxOutputLines; got != 50 {
+		t.Errorf("maxOutputLines = %d after SIGHUP, want 50", got)
+	}
+}
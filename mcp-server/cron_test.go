@@ -0,0 +1,88 @@
+package mcpserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"x * * * *",
+		"* * * foo *",
+	}
+	for _, expr := range tests {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// A Thursday
+	from := time.Date(2026, 10, 1, 9, 30, 45, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 10, 1, 9, 31, 0, 0, time.UTC)},
+		{"30 9 * * *", time.Date(2026, 10, 2, 9, 30, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 1, 9, 45, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2026, 10, 1, 9, 45, 0, 0, time.UTC)},
+		{"0 3,15 * * *", time.Date(2026, 10, 1, 15, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2026, 10, 4, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * sat", time.Date(2026, 10, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 10, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 feb *", time.Date(2027, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2026, 10, 31, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either one matches
+		{"0 0 15 * mon", time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC)},
+		// A stepped day of week is a restriction too
+		{"0 0 13 * */7", time.Date(2026, 10, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := c.Next(from); !got.Equal(tt.want) {
+			t.Errorf("ParseCron(%q).Next(%s) = %s, want %s", tt.expr, from, got, tt.want)
+		}
+	}
+}
+
+func TestCronRange(t *testing.T) {
+	tests := []struct {
+		lo, hi int
+		want   uint64
+	}{
+		{0, 0, 0b1},
+		{0, 6, 0b1111111},
+		{1, 3, 0b1110},
+		{5, 5, 0b100000},
+	}
+	for _, tt := range tests {
+		if got := cronRange(tt.lo, tt.hi); got != tt.want {
+			t.Errorf("cronRange(%d, %d) = %b, want %b", tt.lo, tt.hi, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package mcpserver
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns lines "1" to "n", each with a newline
+func numberedLines(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	return b.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	twenty := numberedLines(20)
+
+	tests := []struct {
+		name          string
+		before, after string
+		want          string
+	}{
+		{
+			name:   "equal",
+			before: "a\nb\n",
+			after:  "a\nb\n",
+			want:   "",
+		},
+		{
+			name:   "binary",
+			before: "a\x00b",
+			after:  "a\x00c",
+			want:   "Binary file /x.conf changed\n",
+		},
+		{
+			name:   "changed line",
+			before: "a\nb\nc\n",
+			after:  "a\nB\nc\n",
+			want:   "--- a/x.conf\n+++ b/x.conf\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name:   "created",
+			before: "",
+			after:  "a\nb\n",
+			want:   "--- /dev/null\n+++ b/x.conf\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:   "removed",
+			before: "a\n",
+			after:  "",
+			want:   "--- a/x.conf\n+++ /dev/null\n@@ -1,1 +0,0 @@\n-a\n",
+		},
+		{
+			name:   "appended",
+			before: "a\nb\nc\nd\ne\n",
+			after:  "a\nb\nc\nd\ne\nf\n",
+			want:   "--- a/x.conf\n+++ b/x.conf\n@@ -3,3 +3,4 @@\n c\n d\n e\n+f\n",
+		},
+		{
+			name:   "separate hunks",
+			before: twenty,
+			after:  strings.Replace(strings.Replace(twenty, "2\n", "two\n", 1), "\n18\n", "\n", 1),
+			want: "--- a/x.conf\n+++ b/x.conf\n" +
+				"@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n" +
+				"@@ -15,6 +15,5 @@\n 15\n 16\n 17\n-18\n 19\n 20\n",
+		},
+		{
+			name:   "merged hunks",
+			before: numberedLines(10),
+			after:  strings.Replace(strings.Replace(numberedLines(10), "2\n", "two\n", 1), "\n8\n", "\neight\n", 1),
+			want: "--- a/x.conf\n+++ b/x.conf\n" +
+				"@@ -1,10 +1,10 @@\n 1\n-2\n+two\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n 9\n 10\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("/x.conf", tt.before, tt.after); got != tt.want {
+				t.Errorf("unifiedDiff =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
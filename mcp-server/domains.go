@@ -0,0 +1,130 @@
+package mcpserver
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// CertValidityDays and CertRenewalDays match a2certrenew, which renews a
+// certificate once 10 or fewer of its 90 days are left
+const (
+	CertValidityDays = 90
+	CertRenewalDays  = 10
+)
+
+// domainContent is a row of domains.db in structuredContent
+type domainContent struct {
+	Domain    string `json:"domain"`
+	Status    string `json:"status"`
+	Registrar string `json:"registrar,omitempty"`
+	// DNSInit is unset until fqdnmgr has checked the initial DNS records
+	DNSInit *bool `json:"dnsInit,omitempty"`
+	// CertDate is when the certificate was issued, as YYYY-MM-DD
+	CertDate    string `json:"certDate,omitempty"`
+	CertExpires string `json:"certExpires,omitempty"`
+	// DaysLeft is negative once the certificate has expired
+	DaysLeft   *int `json:"daysLeft,omitempty"`
+	RenewalDue bool `json:"renewalDue,omitempty"`
+}
+
+// handleListDomains - List domains.db with certificate expiry (sync)
+func handleListDomains(args map[string]any) ToolCallResult {
+	host, err := resolveHost(getString(args, "host", ""))
+	if err != nil {
+		return errorResult(err.Error())
+	}
+	within := getInt(args, "expiringWithinDays", -1)
+
+	spec := JobSpec{
+		Tool:  "list_domains",
+		Name:  "fqdnmgr",
+		Args:  []string{"list", "-l", "-ni"},
+		Host:  host,
+		Trace: traceParentFromArgs(args),
+	}
+	if err := validateArgv(spec.Tool, spec.Name, spec.Args); err != nil {
+		return errorResult(err.Error())
+	}
+	stdout, stderr, exitCode, _, err := runSyncLocked(spec)
+	if err != nil {
+		return errorResult(err.Error())
+	}
+	if exitCode != 0 {
+		return errorResult(formatOutput(stdout, stderr, exitCode))
+	}
+
+	domains := []domainContent{}
+	var result strings.Builder
+	for _, d := range parseDomainRows(stdout, time.Now()) {
+		if within >= 0 && (d.DaysLeft == nil || *d.DaysLeft > within) {
+			continue
+		}
+		domains = append(domains, d)
+		result.WriteString(formatDomain(d))
+	}
+	if len(domains) == 0 {
+		result.WriteString("No domains.")
+	}
+	return withStructured(textResult(result.String()), map[string]any{"domains": domains})
+}
+
+// parseDomainRows reads the domain|status|registrar|dns_init|cert_date
+// lines of fqdnmgr list -l, working out certificate expiry as of now
+func parseDomainRows(out string, now time.Time) []domainContent {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	var domains []domainContent
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		if len(fields) != 5 || fields[0] == "" {
+			continue
+		}
+		d := domainContent{Domain: fields[0], Status: fields[1], Registrar: fields[2], CertDate: fields[4]}
+		if fields[3] != "" {
+			initialized := fields[3] == "1"
+			d.DNSInit = &initialized
+		}
+		if issued, err := time.ParseInLocation("2006-01-02", d.CertDate, time.Local); err == nil {
+			expires := issued.AddDate(0, 0, CertValidityDays)
+			days := int(math.Round(expires.Sub(today).Hours() / 24))
+			d.CertExpires = expires.Format("2006-01-02")
+			d.DaysLeft = &days
+			d.RenewalDue = days <= CertRenewalDays
+		}
+		domains = append(domains, d)
+	}
+	return domains
+}
+
+// formatDomain renders a domain as one line of list_domains text
+func formatDomain(d domainContent) string {
+	var line strings.Builder
+	line.WriteString(fmt.Sprintf("%s  %s", d.Domain, d.Status))
+	if d.Registrar != "" {
+		line.WriteString(" at " + d.Registrar)
+	}
+	switch {
+	case d.DNSInit == nil:
+	case *d.DNSInit:
+		line.WriteString(", DNS initialized")
+	default:
+		line.WriteString(", DNS not initialized")
+	}
+	switch {
+	case d.DaysLeft != nil && *d.DaysLeft < 0:
+		line.WriteString(fmt.Sprintf(", certificate expired %s", d.CertExpires))
+	case d.DaysLeft != nil:
+		line.WriteString(fmt.Sprintf(", certificate expires %s (%d days)", d.CertExpires, *d.DaysLeft))
+	case d.CertDate != "":
+		line.WriteString(fmt.Sprintf(", certificate issued %s", d.CertDate))
+	default:
+		line.WriteString(", no certificate")
+	}
+	if d.RenewalDue {
+		line.WriteString(", renewal due")
+	}
+	line.WriteString("\n")
+	return line.String()
+}
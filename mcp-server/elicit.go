@@ -0,0 +1,126 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// pendingCall is a request the server sent to a client
+type pendingCall struct {
+	sess       *session
+	onResponse func(result json.RawMessage, rpcErr *JSONRPCError)
+}
+
+// Requests the server sent to clients, by ID, waiting for a response
+var (
+	pendingMu     sync.Mutex
+	pendingCalls  = map[string]pendingCall{}
+	nextRequestID atomic.Int64
+)
+
+// ElicitResult is the client's reply to elicitation/create
+type ElicitResult struct {
+	Action  string         `json:"action"` // accept, decline or cancel
+	Content map[string]any `json:"content,omitempty"`
+}
+
+// sendRequest sends a request to a client; onResponse runs when its
+// response arrives
+func (s *session) sendRequest(method string, params any, onResponse func(result json.RawMessage, rpcErr *JSONRPCError)) {
+	id := fmt.Sprintf("a2cmds-%d", nextRequestID.Add(1))
+	raw, err := json.Marshal(params)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error marshaling %s request: %v\n", method, err)
+		return
+	}
+
+	pendingMu.Lock()
+	pendingCalls[id] = pendingCall{sess: s, onResponse: onResponse}
+	pendingMu.Unlock()
+
+	s.write(JSONRPCRequest{JSONRPC: "2.0", ID: id, Method: method, Params: raw})
+}
+
+// handleClientResponse routes a response from a client to the request
+// it answers
+func (s *session) handleClientResponse(line []byte) {
+	var resp struct {
+		ID     any             `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *JSONRPCError   `json:"error"`
+	}
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return
+	}
+	id, _ := resp.ID.(string)
+
+	pendingMu.Lock()
+	call, ok := pendingCalls[id]
+	if ok && call.sess == s {
+		delete(pendingCalls, id)
+	}
+	pendingMu.Unlock()
+
+	if ok && call.sess == s {
+		call.onResponse(resp.Result, resp.Error)
+	}
+}
+
+// elicitingSession returns the first connected client that can answer
+// elicitations and may use the job, or nil
+func elicitingSession(spec JobSpec) *session {
+	for _, s := range openSessions() {
+		if s.elicitation.Load() && s.caller.mayUseJob(spec.Tool, spec.Caller) == nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// elicitPrompt asks a client's user to answer a job's prompt. Prompts
+// for passwords and keys are left to send_job_input with secret: true.
+func elicitPrompt(jobID string, spec JobSpec, prompt JobPrompt) {
+	sess := elicitingSession(spec)
+	if sess == nil || prompt.Sensitive {
+		return
+	}
+
+	params := map[string]any{
+		"message": fmt.Sprintf("%s job %s asks:\n\n%s", spec.Tool, jobID, prompt.Text),
+		"requestedSchema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"input": map[string]any{
+					"type":        "string",
+					"title":       "Answer",
+					"description": "Sent to the job as one line; leave empty to just press Enter",
+				},
+			},
+		},
+	}
+	sess.sendRequest("elicitation/create", params, func(result json.RawMessage, rpcErr *JSONRPCError) {
+		if rpcErr != nil {
+			jobMgr.AppendOutput(jobID, fmt.Sprintf("Could not ask the client for input: %s; answer with send_job_input", rpcErr.Message))
+			return
+		}
+
+		var res ElicitResult
+		if err := json.Unmarshal(result, &res); err != nil || res.Action != "accept" {
+			switch res.Action {
+			case "decline":
+				jobMgr.AppendOutput(jobID, "The client declined to answer; answer with send_job_input")
+			case "cancel":
+				jobMgr.AppendOutput(jobID, "The client dismissed the question; answer with send_job_input")
+			}
+			return
+		}
+
+		text, _ := res.Content["input"].(string)
+		if err := jobMgr.SendInput(jobID, JobInput{Text: text, Seq: prompt.Seq}); err != nil {
+			jobMgr.AppendOutput(jobID, fmt.Sprintf("Could not send the client's answer: %v", err))
+		}
+	})
+}
@@ -0,0 +1,308 @@
+package mcpserver
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Executor starts the scripts behind the tools. The server runs them
+// itself, or asks the privileged helper to when it runs unprivileged.
+type Executor interface {
+	Start(req ExecRequest) (*Process, error)
+}
+
+// executor is what jobs and sync tools run their commands with
+var executor Executor = localExecutor{}
+
+// ExecRequest describes one run of a tool's script
+type ExecRequest struct {
+	Tool string   `json:"tool"`
+	Name string   `json:"name"`
+	Args []string `json:"args"`
+
+	// TraceParent is passed to the script as TRACEPARENT
+	TraceParent string `json:"traceParent,omitempty"`
+	// Stdin keeps the script's stdin open for input; otherwise it reads
+	// from /dev/null
+	Stdin bool `json:"stdin,omitempty"`
+	// PTY gives the script a controlling terminal whose output is read
+	// from Process.TTY
+	PTY bool `json:"pty,omitempty"`
+}
+
+// execRequest returns the request for running a spec's command
+func execRequest(spec JobSpec, trace SpanContext) ExecRequest {
+	req := ExecRequest{
+		Tool:  spec.Tool,
+		Name:  spec.Name,
+		Args:  spec.Args,
+		Stdin: spec.Interactive,
+		PTY:   spec.PTY && ptyEnabled,
+	}
+	if trace.IsValid() {
+		req.TraceParent = trace.Traceparent()
+	}
+	return req
+}
+
+// Process is a started script
+type Process struct {
+	// Argv is the command line as run, including profile wrappers
+	Argv []string
+	Pid  int
+
+	Stdout io.Reader
+	Stderr io.Reader
+	// Stdin is nil unless the request asked for it
+	Stdin io.WriteCloser
+	// TTY is the terminal's master side, nil without a PTY. PTYErr says
+	// why a requested PTY could not be set up; the script runs anyway.
+	TTY    io.ReadWriteCloser
+	PTYErr error
+
+	// OutputFiles are the files stdout and stderr are written to, which
+	// are left behind if the output is not read to the end
+	OutputFiles []string
+
+	wait func() (int, error)
+	kill func() error
+}
+
+// NewProcess returns a Process for an Executor outside this package,
+// which sets the streams and Pid on it. wait is called by Wait; kill
+// may be nil if the process cannot be stopped.
+func NewProcess(wait func() (int, error), kill func() error) *Process {
+	return &Process{wait: wait, kill: kill}
+}
+
+// Wait waits for the script to exit and returns its exit code, or -1 if
+// it was killed by a signal. err is only set if the exit status could
+// not be collected. Stdout and Stderr are closed once it returns, even
+// if a background process the script left behind still holds them.
+func (p *Process) Wait() (exitCode int, err error) {
+	return p.wait()
+}
+
+// Kill stops the script, which then exits with -1. Wait must still be
+// called.
+func (p *Process) Kill() error {
+	if p.kill == nil {
+		return errors.New("the process cannot be stopped")
+	}
+	return p.kill()
+}
+
+// localExecutor runs scripts as child processes of the server
+type localExecutor struct{}
+
+func (localExecutor) Start(req ExecRequest) (*Process, error) {
+	cmd, cleanup, err := newCommand(JobSpec{Tool: req.Tool, Name: req.Name, Args: req.Args, PTY: req.PTY})
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	if req.TraceParent != "" {
+		cmd.Env = append(cmd.Env, "TRACEPARENT="+req.TraceParent)
+	}
+
+	// Output goes to files rather than pipes, so a script the server
+	// leaves running at shutdown can still write without getting SIGPIPE
+	outW, err := createOutputFile("out")
+	if err != nil {
+		return nil, err
+	}
+	errW, err := createOutputFile("err")
+	if err != nil {
+		outW.Close()
+		os.Remove(outW.Name())
+		return nil, err
+	}
+	files := []string{outW.Name(), errW.Name()}
+	outR, err := os.Open(outW.Name())
+	if err != nil {
+		outW.Close()
+		errW.Close()
+		removeFiles(files)
+		return nil, err
+	}
+	errR, err := os.Open(errW.Name())
+	if err != nil {
+		outR.Close()
+		outW.Close()
+		errW.Close()
+		removeFiles(files)
+		return nil, err
+	}
+	cmd.Stdout, cmd.Stderr = outW, errW
+	closeAll := func() {
+		for _, f := range []*os.File{outR, outW, errR, errW} {
+			f.Close()
+		}
+		removeFiles(files)
+	}
+
+	var stdin io.WriteCloser
+	if req.Stdin {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			closeAll()
+			return nil, err
+		}
+	}
+
+	proc := &Process{Stdin: stdin}
+
+	var master *os.File
+	if req.PTY {
+		m, slave, err := openPTY()
+		if err != nil {
+			proc.PTYErr = err
+		} else {
+			attachTTY(cmd, slave)
+			// The child has its own copy once started
+			defer slave.Close()
+			master = m
+		}
+	}
+
+	// In its own process group, a script does not get the signals a
+	// terminal sends the server's group, such as SIGINT on Ctrl-C
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		if master != nil {
+			master.Close()
+		}
+		closeAll()
+		return nil, err
+	}
+	// The child has its own copies
+	outW.Close()
+	errW.Close()
+
+	proc.Argv = cmd.Args
+	proc.Pid = cmd.Process.Pid
+	proc.OutputFiles = files
+	proc.kill = cmd.Process.Kill
+	if master != nil {
+		proc.TTY = master
+	}
+	exited := make(chan struct{})
+	outPipe, outDone := tailOutput(outR, exited)
+	errPipe, errDone := tailOutput(errR, exited)
+	proc.Stdout, proc.Stderr = outPipe, errPipe
+
+	proc.wait = func() (int, error) {
+		err := cmd.Wait()
+		close(exited)
+
+		// Whoever reads the output may have gone, like a server that
+		// exited while the helper ran its script. Then the files are
+		// kept, so what the script wrote is not lost.
+		grace := time.NewTimer(outputGrace)
+		defer grace.Stop()
+		read := true
+		for _, done := range []<-chan struct{}{outDone, errDone} {
+			select {
+			case <-done:
+			case <-grace.C:
+				read = false
+				outPipe.Close()
+				errPipe.Close()
+			}
+		}
+		<-outDone
+		<-errDone
+		outR.Close()
+		errR.Close()
+		if read {
+			removeFiles(files)
+		}
+
+		if err == nil {
+			return 0, nil
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), nil
+		}
+		return -1, err
+	}
+	return proc, nil
+}
+
+// outputDir holds the files scripts write their output to while they run.
+// Empty uses the system's temporary directory.
+var outputDir string
+
+// createOutputFile creates a file for a script's stdout or stderr
+func createOutputFile(suffix string) (*os.File, error) {
+	if outputDir != "" {
+		if err := os.MkdirAll(outputDir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	return os.CreateTemp(outputDir, "a2cmds-job-*."+suffix)
+}
+
+func removeFiles(paths []string) {
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
+
+// tailInterval is how often an output file is checked for more output
+const tailInterval = 100 * time.Millisecond
+
+// tailOutput copies what is written to f into a pipe for the caller until
+// exited is closed and f is read to its end. Output a background process
+// writes after the script exits is not read. done is closed once the copy
+// ends, either way.
+func tailOutput(f *os.File, exited <-chan struct{}) (*io.PipeReader, <-chan struct{}) {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer pw.Close()
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := f.Read(buf)
+			if n > 0 {
+				if _, err := pw.Write(buf[:n]); err != nil {
+					return
+				}
+				continue
+			}
+			if err != nil && err != io.EOF {
+				pw.CloseWithError(err)
+				return
+			}
+			select {
+			case <-exited:
+				// Everything the script wrote is in the file by now
+				io.Copy(pw, f)
+				return
+			case <-time.After(tailInterval):
+			}
+		}
+	}()
+	return pr, done
+}
+
+// outputGrace is how long output is still read after a script exits
+const outputGrace = 2 * time.Second
+
+// relayOutput copies r into a pipe for the caller. done is closed once
+// everything read from r has been taken by the caller.
+func relayOutput(r io.Reader) (io.Reader, <-chan struct{}) {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(pw, r)
+		pw.Close()
+	}()
+	return pr, done
+}
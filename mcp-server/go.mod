@@ -3,3 +3,11 @@ module github.com/a2cmds/mcp-server
 go 1.21
 
 require github.com/google/uuid v1.6.0
+
+require (
+	golang.org/x/crypto v0.31.0
+	golang.org/x/term v0.27.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.28.0 // indirect
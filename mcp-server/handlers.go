@@ -1,14 +1,26 @@
-package main
+package mcpserver
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
-	"os/exec"
+	"io"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 // ExecuteTool dispatches tool calls to the appropriate handler
 func ExecuteTool(name string, args map[string]any) ToolCallResult {
+	if !toolEnabled(name) {
+		return errorResult(fmt.Sprintf("Tool %s is disabled in the server configuration", name))
+	}
+	args = withToolDefaults(name, args)
+
 	switch name {
 	case "a2sitemgr":
 		return handleA2SiteMgr(args)
@@ -18,6 +30,8 @@ func ExecuteTool(name string, args map[string]any) ToolCallResult {
 		return handleFQDNMgrPurchase(args)
 	case "fqdnmgr_list":
 		return handleFQDNMgrList(args)
+	case "list_domains":
+		return handleListDomains(args)
 	case "fqdnmgr_setInitDNSRecords":
 		return handleFQDNMgrSetInitDNS(args)
 	case "fqdnmgr_checkInitDns":
@@ -32,9 +46,31 @@ func ExecuteTool(name string, args map[string]any) ToolCallResult {
 		return handleA2WCRecalcDMS(args)
 	case "a2certrenew":
 		return handleA2CertRenew(args)
+	case "run_pipeline":
+		return handleRunPipeline(args)
+	case "schedule_create":
+		return handleScheduleCreate(args)
+	case "schedule_list":
+		return handleScheduleList(args)
+	case "schedule_delete":
+		return handleScheduleDelete(args)
 	case "check_job_status":
 		return handleCheckJobStatus(args)
+	case "list_jobs":
+		return handleListJobs(args)
+	case "cancel_job":
+		return handleCancelJob(args)
+	case "wait_job":
+		return handleWaitJob(args)
+	case "send_job_input":
+		return handleSendJobInput(args)
 	default:
+		if tool, handler := registeredHandler(name); handler != nil {
+			return callRegistered(tool, handler, args)
+		}
+		if def := definedTool(name); def != nil {
+			return def.execute(args)
+		}
 		return errorResult(fmt.Sprintf("Unknown tool: %s", name))
 	}
 }
@@ -85,308 +121,671 @@ func textResult(msg string) ToolCallResult {
 }
 
 func jobStartedResult(jobID string, checkInterval string) ToolCallResult {
-	msg := fmt.Sprintf("Job started with ID: %s\n\nUse check_job_status with this jobId to monitor progress. Check again in %s.", jobID, checkInterval)
-	return textResult(msg)
+	info, ok := jobMgr.GetJobStatus(jobID)
+	if !ok {
+		info = JobInfo{ID: jobID}
+	}
+	content := newJobContent(info)
+	content.CheckInterval = checkInterval
+
+	if info.Status == JobStatusQueued && ok {
+		msg := fmt.Sprintf("Job queued with ID: %s\nQueue position: %d, waiting for %s.\n\nUse wait_job with this jobId to wait for progress, or check_job_status to poll. Expected check interval: %s.", jobID, info.QueuePosition, waitReason(info), checkInterval)
+		return withStructured(textResult(msg), content)
+	}
+	msg := fmt.Sprintf("Job started with ID: %s\n\nUse wait_job with this jobId to wait for progress, or check_job_status to poll. Expected check interval: %s.", jobID, checkInterval)
+	return withStructured(textResult(msg), content)
 }
 
-// runSync executes a command synchronously and returns stdout/stderr
-func runSync(name string, args ...string) (stdout string, stderr string, exitCode int, err error) {
-	cmd := exec.Command(name, args...)
-	var stdoutBuf, stderrBuf bytes.Buffer
-	cmd.Stdout = &stdoutBuf
-	cmd.Stderr = &stderrBuf
+// waitReason describes what a queued job is waiting for
+func waitReason(info JobInfo) string {
+	if info.BlockedBy != "" {
+		return fmt.Sprintf("%s (held by %s)", info.WaitingFor, info.BlockedBy)
+	}
+	return info.WaitingFor
+}
 
-	err = cmd.Run()
-	exitCode = 0
+// submitJob queues a job, taking its priority and notification targets
+// from the call arguments
+func submitJob(args map[string]any, spec JobSpec) (string, error) {
+	priority, err := parsePriority(getString(args, "priority", ""))
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			exitCode = exitErr.ExitCode()
-		}
+		return "", err
 	}
-
-	return stdoutBuf.String(), stderrBuf.String(), exitCode, nil
+	spec.Priority = priority
+	if spec.Notify, err = parseNotify(args["notify"]); err != nil {
+		return "", err
+	}
+	return jobMgr.StartJob(spec)
 }
 
-// ==================== ASYNC HANDLERS ====================
-
-// handleA2SiteMgr - Configure Apache2 virtual hosts (async)
-func handleA2SiteMgr(args map[string]any) ToolCallResult {
-	fqdn := getString(args, "fqdn", "")
-	if fqdn == "" {
-		return errorResult("fqdn is required")
+// startToolJob builds a tool's command and queues it as a job
+func startToolJob(tool string, args map[string]any, checkInterval string) ToolCallResult {
+	spec, err := buildToolCommand(tool, args)
+	if err != nil {
+		return errorResult(err.Error())
 	}
 
-	cmdArgs := []string{"-d", fqdn, "-ni"}
-
-	mode := getString(args, "mode", "domain")
-	if mode != "" && mode != "domain" {
-		cmdArgs = append(cmdArgs, "-m", mode)
+	rec, existing, err := submitIdempotent(tool, args, func() (string, error) {
+		return submitJob(args, spec)
+	})
+	if err != nil {
+		return errorResult(fmt.Sprintf("Failed to start job: %v", err))
 	}
-
-	if registrar := getString(args, "registrar", ""); registrar != "" {
-		cmdArgs = append(cmdArgs, "-r", registrar)
+	if existing {
+		return duplicateJobResult(rec)
 	}
+	return jobStartedResult(rec.JobID, checkInterval)
+}
 
-	if port := getInt(args, "port", 0); port > 0 {
-		cmdArgs = append(cmdArgs, "-p", fmt.Sprintf("%d", port))
+// duplicateJobResult reports the job an earlier call with the same
+// idempotency key started, instead of starting another
+func duplicateJobResult(rec IdempotencyRecord) ToolCallResult {
+	info, found := jobMgr.GetJobStatus(rec.JobID)
+	if !found {
+		info = rec.info()
 	}
+	msg := fmt.Sprintf("Duplicate request: job %s was already started for this call at %s. No new job was started.\nTo run it again anyway, pass a new idempotencyKey.\n\nJob ID: %s\n%s",
+		rec.JobID, rec.CreatedAt.Format(time.RFC3339), rec.JobID, formatJobStatus(info))
+	content := newJobContent(info)
+	content.Duplicate = true
+	return withStructured(textResult(msg), content)
+}
 
-	if getBool(args, "secured", false) {
-		cmdArgs = append(cmdArgs, "-s")
-	}
+// runSync executes a spec's command synchronously and returns stdout/stderr
+func runSync(spec JobSpec) (stdout string, stderr string, exitCode int, err error) {
+	span := StartSpan("exec "+spec.Name, spec.Trace, time.Now())
+	defer span.End()
 
-	if getBool(args, "setInitDNSRecords", false) {
-		cmdArgs = append(cmdArgs, "--setInitDNSRecords")
+	// Sync tools never prompt and need no terminal
+	req := execRequest(spec, span.Context())
+	req.Stdin, req.PTY = false, false
+	var proc *Process
+	ex, err := executorFor(spec.Host)
+	if err == nil {
+		proc, err = ex.Start(req)
 	}
-
-	if getBool(args, "override", false) {
-		cmdArgs = append(cmdArgs, "-o")
+	if err != nil {
+		span.SetError(err.Error())
+		metrics.SpawnError(spec.Tool)
+		return "", "", 0, fmt.Errorf("failed to start %s: %v", spec.Name, err)
 	}
+	span.SetAttr("process.command_args", strings.Join(proc.Argv, " "))
 
-	if getBool(args, "verbose", true) {
-		cmdArgs = append(cmdArgs, "-v")
+	var timedOut atomic.Bool
+	if spec.Timeout > 0 {
+		timer := time.AfterFunc(spec.Timeout, func() {
+			timedOut.Store(true)
+			proc.Kill()
+		})
+		defer timer.Stop()
 	}
 
-	jobID, err := jobMgr.StartJob("a2sitemgr", cmdArgs...)
+	var stdoutBuf, stderrBuf bytes.Buffer
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(&stdoutBuf, proc.Stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(&stderrBuf, proc.Stderr)
+	}()
+
+	exitCode, err = proc.Wait()
+	wg.Wait()
+	if timedOut.Load() {
+		fmt.Fprintf(&stderrBuf, "\nTimed out after %s, stopped %s", spec.Timeout, spec.Name)
+	}
 	if err != nil {
-		return errorResult(fmt.Sprintf("Failed to start job: %v", err))
+		span.SetError(err.Error())
+		return "", "", 0, fmt.Errorf("failed to run %s: %v", spec.Name, err)
+	}
+	if exitCode != 0 {
+		span.SetError(fmt.Sprintf("exit code %d", exitCode))
 	}
+	span.SetAttr("process.exit_code", exitCode)
 
-	return jobStartedResult(jobID, "30 seconds")
+	return stdoutBuf.String(), stderrBuf.String(), exitCode, nil
 }
 
-// handleFQDNMgrPurchase - Purchase domain (async)
-func handleFQDNMgrPurchase(args map[string]any) ToolCallResult {
-	fqdn := getString(args, "fqdn", "")
-	registrar := getString(args, "registrar", "")
-
-	if fqdn == "" || registrar == "" {
-		return errorResult("fqdn and registrar are required")
+// runSyncLocked runs a command synchronously once it holds the spec's
+// locks. It also returns the changes to the spec's watched paths.
+func runSyncLocked(spec JobSpec) (stdout string, stderr string, exitCode int, changes []FileChange, err error) {
+	owner := fmt.Sprintf("sync %s call %s", spec.Tool, uuid.New().String()[:8])
+	release, err := jobMgr.AcquireLocks(owner, spec.Locks, SyncLockTimeout)
+	if err != nil {
+		return "", "", 0, nil, err
 	}
+	defer release()
 
-	cmdArgs := []string{"purchase", fqdn, registrar, "-ni"}
+	before := takeSnapshot(spec.Watch)
+	stdout, stderr, exitCode, err = runSync(spec)
+	if err != nil {
+		return "", "", 0, nil, err
+	}
+	if before != nil {
+		changes = diffSnapshots(before, takeSnapshot(spec.Watch))
+	}
+	return stdout, stderr, exitCode, changes, nil
+}
 
-	if getBool(args, "verbose", true) {
-		cmdArgs = append(cmdArgs, "-v")
+// runToolSync builds a tool's command, runs it and formats its output
+func runToolSync(tool string, args map[string]any) ToolCallResult {
+	spec, err := buildToolCommand(tool, args)
+	if err != nil {
+		return errorResult(err.Error())
 	}
 
-	jobID, err := jobMgr.StartJob("fqdnmgr", cmdArgs...)
+	stdout, stderr, exitCode, changes, err := runSyncLocked(spec)
 	if err != nil {
-		return errorResult(fmt.Sprintf("Failed to start job: %v", err))
+		return errorResult(err.Error())
 	}
 
-	return jobStartedResult(jobID, "30 seconds")
+	output := formatOutput(stdout, stderr, exitCode)
+	if len(changes) > 0 {
+		output += "\n\n--- Changed files ---\n" + formatChanges(changes, true)
+	}
+	return withStructured(textResult(output), commandContent{
+		ExitCode: exitCode,
+		Stdout:   stdout,
+		Stderr:   stderr,
+		Changes:  newChangeContents(changes),
+	})
 }
 
-// handleFQDNMgrSetInitDNS - Set initial DNS records (async)
-func handleFQDNMgrSetInitDNS(args map[string]any) ToolCallResult {
-	domains := getString(args, "domains", "")
-	registrar := getString(args, "registrar", "")
-
-	if domains == "" || registrar == "" {
-		return errorResult("domains and registrar are required")
+// domainLocks returns fqdn lock keys for a space- or comma-separated domain list
+func domainLocks(domains string) []string {
+	var locks []string
+	for _, d := range strings.Fields(strings.ReplaceAll(domains, ",", " ")) {
+		locks = append(locks, fqdnLock(d))
 	}
+	return locks
+}
 
-	cmdArgs := []string{"setInitDNSRecords", "-d", domains, "-r", registrar, "-ni"}
-
-	if getBool(args, "override", false) {
-		cmdArgs = append(cmdArgs, "-o")
-	}
+// ==================== ASYNC HANDLERS ====================
 
-	if getBool(args, "verbose", true) {
-		cmdArgs = append(cmdArgs, "-v")
-	}
+// handleA2SiteMgr - Configure Apache2 virtual hosts (async)
+func handleA2SiteMgr(args map[string]any) ToolCallResult {
+	return startToolJob("a2sitemgr", args, checkInterval("a2sitemgr"))
+}
 
-	jobID, err := jobMgr.StartJob("fqdnmgr", cmdArgs...)
-	if err != nil {
-		return errorResult(fmt.Sprintf("Failed to start job: %v", err))
-	}
+// handleFQDNMgrPurchase - Purchase domain (async)
+func handleFQDNMgrPurchase(args map[string]any) ToolCallResult {
+	return startToolJob("fqdnmgr_purchase", args, checkInterval("fqdnmgr_purchase"))
+}
 
-	return jobStartedResult(jobID, "60 seconds. DNS propagation typically takes 5-10 minutes")
+// handleFQDNMgrSetInitDNS - Set initial DNS records (async)
+func handleFQDNMgrSetInitDNS(args map[string]any) ToolCallResult {
+	return startToolJob("fqdnmgr_setInitDNSRecords", args, checkInterval("fqdnmgr_setInitDNSRecords")+". DNS propagation typically takes 5-10 minutes")
 }
 
 // handleA2CertRenew - Certificate renewal (async)
 func handleA2CertRenew(args map[string]any) ToolCallResult {
-	jobID, err := jobMgr.StartJob("a2certrenew")
-	if err != nil {
-		return errorResult(fmt.Sprintf("Failed to start job: %v", err))
-	}
-
-	return jobStartedResult(jobID, "60 seconds")
+	return startToolJob("a2certrenew", args, checkInterval("a2certrenew"))
 }
 
-// ==================== SYNC HANDLERS ====================
-
-// handleFQDNMgrCheck - Check domain status (sync)
-func handleFQDNMgrCheck(args map[string]any) ToolCallResult {
-	fqdn := getString(args, "fqdn", "")
-	if fqdn == "" {
-		return errorResult("fqdn is required")
+// handleRunPipeline - Run dependent tool calls as one parent job (async)
+func handleRunPipeline(args map[string]any) ToolCallResult {
+	steps, err := parsePipelineSteps(args["steps"])
+	if err != nil {
+		return errorResult(err.Error())
 	}
 
-	cmdArgs := []string{"check", fqdn, "-ni"}
+	priority, err := parsePriority(getString(args, "priority", ""))
+	if err != nil {
+		return errorResult(err.Error())
+	}
+	notify, err := parseNotify(args["notify"])
+	if err != nil {
+		return errorResult(err.Error())
+	}
 
-	if registrar := getString(args, "registrar", ""); registrar != "" {
-		cmdArgs = append(cmdArgs, registrar)
+	queue, ok := jobMgr.(*JobQueue)
+	if !ok {
+		return errorResult("Pipelines need the built-in job queue")
 	}
+	jobID := queue.StartPipeline(steps, JobSpec{Priority: priority, Trace: traceParentFromArgs(args), Source: getString(args, sourceArgKey, ""), Caller: getString(args, callerArgKey, ""), Notify: notify})
 
-	if getBool(args, "verbose", false) {
-		cmdArgs = append(cmdArgs, "-v")
+	var msg strings.Builder
+	msg.WriteString(fmt.Sprintf("Pipeline started with ID: %s\n\nSteps:\n", jobID))
+	for _, st := range steps {
+		msg.WriteString(fmt.Sprintf("  - %s (%s)", st.ID, st.Tool))
+		if len(st.DependsOn) > 0 {
+			msg.WriteString(fmt.Sprintf(" after %s", strings.Join(st.DependsOn, ", ")))
+		}
+		msg.WriteString("\n")
 	}
+	msg.WriteString("\nUse wait_job with this jobId to wait for completion, or check_job_status to see per-step progress.")
+	info, _ := jobMgr.GetJobStatus(jobID)
+	return withStructured(textResult(msg.String()), newJobContent(info))
+}
 
-	stdout, stderr, exitCode, _ := runSync("fqdnmgr", cmdArgs...)
+// ==================== SYNC HANDLERS ====================
 
-	output := formatOutput(stdout, stderr, exitCode)
-	return textResult(output)
+// handleFQDNMgrCheck - Check domain status (sync)
+func handleFQDNMgrCheck(args map[string]any) ToolCallResult {
+	return runToolSync("fqdnmgr_check", args)
 }
 
 // handleFQDNMgrList - List domains (sync)
 func handleFQDNMgrList(args map[string]any) ToolCallResult {
-	cmdArgs := []string{"list", "-ni"}
-
-	if registrar := getString(args, "registrar", ""); registrar != "" {
-		cmdArgs = append(cmdArgs, registrar)
-	}
-
-	source := getString(args, "source", "local")
-	if source != "" {
-		cmdArgs = append(cmdArgs, source)
-	}
-
-	if getBool(args, "verbose", false) {
-		cmdArgs = append(cmdArgs, "-v")
-	}
-
-	stdout, stderr, exitCode, _ := runSync("fqdnmgr", cmdArgs...)
-
-	output := formatOutput(stdout, stderr, exitCode)
-	return textResult(output)
+	return runToolSync("fqdnmgr_list", args)
 }
 
 // handleFQDNMgrCheckInitDns - Check DNS propagation (sync)
 func handleFQDNMgrCheckInitDns(args map[string]any) ToolCallResult {
-	fqdn := getString(args, "fqdn", "")
-	if fqdn == "" {
-		return errorResult("fqdn is required")
+	spec, err := buildToolCommand("fqdnmgr_checkInitDns", args)
+	if err != nil {
+		return errorResult(err.Error())
 	}
 
-	cmdArgs := []string{"checkInitDns", fqdn, "-ni"}
-
-	if getBool(args, "verbose", false) {
-		cmdArgs = append(cmdArgs, "-v")
+	stdout, stderr, exitCode, _, err := runSyncLocked(spec)
+	if err != nil {
+		return errorResult(err.Error())
 	}
 
-	stdout, stderr, exitCode, _ := runSync("fqdnmgr", cmdArgs...)
-
 	output := formatOutput(stdout, stderr, exitCode)
 
 	// Add guidance based on result
 	if exitCode != 0 {
-		output += "\n\n⏳ DNS not fully propagated. Check again in 60 seconds."
+		output += "\n\n⏳ DNS not fully propagated. Check again in " + checkInterval("fqdnmgr_checkInitDns") + "."
 	} else {
 		output += "\n\n✅ DNS propagation complete."
 	}
 
-	return textResult(output)
+	return withStructured(textResult(output), commandContent{ExitCode: exitCode, Stdout: stdout, Stderr: stderr})
 }
 
 // handleFQDNCredMgrDelete - Delete credentials (sync)
 func handleFQDNCredMgrDelete(args map[string]any) ToolCallResult {
-	provider := getString(args, "provider", "")
-	if provider == "" {
-		return errorResult("provider is required")
+	return runToolSync("fqdncredmgr_delete", args)
+}
+
+// handleFQDNCredMgrList - List credentials (sync)
+func handleFQDNCredMgrList(args map[string]any) ToolCallResult {
+	return runToolSync("fqdncredmgr_list", args)
+}
+
+// handleA2WCRecalc - Recalculate wildcard subdomains (sync)
+func handleA2WCRecalc(args map[string]any) ToolCallResult {
+	return runToolSync("a2wcrecalc", args)
+}
+
+// handleA2WCRecalcDMS - Recalculate for Docker-Mailserver (sync)
+func handleA2WCRecalcDMS(args map[string]any) ToolCallResult {
+	return runToolSync("a2wcrecalc_dms", args)
+}
+
+// handleScheduleCreate - Run a tool on a cron schedule
+func handleScheduleCreate(args map[string]any) ToolCallResult {
+	cron := getString(args, "cron", "")
+	tool := getString(args, "tool", "")
+	if cron == "" || tool == "" {
+		return errorResult("cron and tool are required")
 	}
 
-	cmdArgs := []string{"delete", provider}
+	toolArgs, _ := args["arguments"].(map[string]any)
+	if toolArgs == nil {
+		toolArgs = map[string]any{}
+	}
 
-	if getBool(args, "verbose", false) {
-		cmdArgs = append(cmdArgs, "-v")
+	sch, err := scheduler.Create(getString(args, "name", ""), cron, tool, toolArgs)
+	if sch == nil {
+		return errorResult(err.Error())
 	}
 
-	stdout, stderr, exitCode, _ := runSync("fqdncredmgr", cmdArgs...)
+	msg := fmt.Sprintf("Schedule created with ID: %s\nTool: %s\nCron: %s\nNext run: %s", sch.ID, sch.Tool, sch.Cron, sch.NextRun.Format(time.RFC3339))
+	if err != nil {
+		msg += fmt.Sprintf("\n\n⚠️ Schedule could not be saved and will be lost on restart: %v", err)
+	}
+	return withStructured(textResult(msg), sch)
+}
 
-	output := formatOutput(stdout, stderr, exitCode)
-	return textResult(output)
+// handleScheduleList - List schedules with last/next run
+func handleScheduleList(args map[string]any) ToolCallResult {
+	list := scheduler.List()
+	content := map[string]any{"schedules": list}
+	if len(list) == 0 {
+		return withStructured(textResult("No schedules."), content)
+	}
+
+	var result strings.Builder
+	for i, sch := range list {
+		if i > 0 {
+			result.WriteString("\n")
+		}
+		result.WriteString(fmt.Sprintf("ID: %s\n", sch.ID))
+		if sch.Name != "" {
+			result.WriteString(fmt.Sprintf("Name: %s\n", sch.Name))
+		}
+		result.WriteString(fmt.Sprintf("Tool: %s\n", sch.Tool))
+		if len(sch.Arguments) > 0 {
+			argsJSON, _ := json.Marshal(sch.Arguments)
+			result.WriteString(fmt.Sprintf("Arguments: %s\n", argsJSON))
+		}
+		result.WriteString(fmt.Sprintf("Cron: %s\n", sch.Cron))
+		result.WriteString(fmt.Sprintf("Next run: %s\n", sch.NextRun.Format(time.RFC3339)))
+		if sch.LastRun.IsZero() {
+			result.WriteString("Last run: never\n")
+			continue
+		}
+		result.WriteString(fmt.Sprintf("Last run: %s", sch.LastRun.Format(time.RFC3339)))
+		if sch.LastJobID != "" {
+			result.WriteString(fmt.Sprintf(" (job %s)", sch.LastJobID))
+		}
+		if sch.LastStatus != "" {
+			result.WriteString(fmt.Sprintf(", %s", sch.LastStatus))
+		} else {
+			result.WriteString(", running")
+		}
+		if sch.LastError != "" {
+			result.WriteString(fmt.Sprintf(": %s", sch.LastError))
+		}
+		result.WriteString("\n")
+	}
+
+	return withStructured(textResult(result.String()), content)
 }
 
-// handleFQDNCredMgrList - List credentials (sync)
-func handleFQDNCredMgrList(args map[string]any) ToolCallResult {
-	cmdArgs := []string{"list"}
+// handleScheduleDelete - Delete a schedule
+func handleScheduleDelete(args map[string]any) ToolCallResult {
+	id := getString(args, "scheduleId", "")
+	if id == "" {
+		return errorResult("scheduleId is required")
+	}
 
-	if getBool(args, "verbose", false) {
-		cmdArgs = append(cmdArgs, "-v")
+	if err := scheduler.Delete(id); err != nil {
+		return errorResult(err.Error())
 	}
 
-	stdout, stderr, exitCode, _ := runSync("fqdncredmgr", cmdArgs...)
+	return textResult(fmt.Sprintf("Schedule %s deleted.", id))
+}
 
-	output := formatOutput(stdout, stderr, exitCode)
-	return textResult(output)
+// handleCheckJobStatus - Check async job status
+func handleCheckJobStatus(args map[string]any) ToolCallResult {
+	jobID := getString(args, "jobId", "")
+	if jobID == "" {
+		return errorResult("jobId is required")
+	}
+
+	info, found := jobMgr.GetJobStatus(jobID)
+	if !found && idempotency != nil {
+		// Jobs started with an idempotency key keep their result longer
+		if rec, ok := idempotency.ByJobID(jobID); ok {
+			info, found = rec.info(), true
+		}
+	}
+	if !found {
+		return jobNotFound(jobID)
+	}
+	if err := jobAllowed(args, info); err != nil {
+		return errorResult(fmt.Sprintf("Not allowed: %v", err))
+	}
+
+	return withStructured(textResult(formatJobStatus(info)), newJobContent(info))
 }
 
-// handleA2WCRecalc - Recalculate wildcard subdomains (sync)
-func handleA2WCRecalc(args map[string]any) ToolCallResult {
-	var cmdArgs []string
+// jobRefused returns the refusal for a job the caller may not use. Jobs
+// the server does not know are left to the handler to report.
+func jobRefused(args map[string]any, jobID string) (ToolCallResult, bool) {
+	info, found := jobMgr.GetJobStatus(jobID)
+	if !found {
+		return ToolCallResult{}, false
+	}
+	if err := jobAllowed(args, info); err != nil {
+		return errorResult(fmt.Sprintf("Not allowed: %v", err)), true
+	}
+	return ToolCallResult{}, false
+}
 
-	if wildcardDomain := getString(args, "wildcardDomain", ""); wildcardDomain != "" {
-		cmdArgs = append(cmdArgs, wildcardDomain)
+// handleListJobs - List the jobs the server still knows (sync)
+func handleListJobs(args map[string]any) ToolCallResult {
+	status := JobStatus(getString(args, "status", ""))
+	switch status {
+	case "", JobStatusQueued, JobStatusRunning, JobStatusCompleted, JobStatusFailed:
+	default:
+		return errorResult(fmt.Sprintf("Invalid status %q, expected queued, running, completed or failed", status))
 	}
+	tool := getString(args, "tool", "")
 
-	stdout, stderr, exitCode, _ := runSync("a2wcrecalc", cmdArgs...)
+	jobs := []*jobContent{}
+	var result strings.Builder
+	for _, info := range jobMgr.ListJobs() {
+		if (status != "" && info.Status != status) || (tool != "" && info.Tool != tool) {
+			continue
+		}
+		if jobAllowed(args, info) != nil {
+			continue
+		}
+		content := newJobContent(info)
+		content.Output, content.Stderr, content.Artifacts = "", "", nil
+		jobs = append(jobs, content)
 
-	output := formatOutput(stdout, stderr, exitCode)
-	return textResult(output)
+		result.WriteString(fmt.Sprintf("%s  %-9s  %s", info.ID, info.Status, info.Tool))
+		if info.Host != "" {
+			result.WriteString(" on " + info.Host)
+		}
+		if info.ParentID != "" {
+			result.WriteString(fmt.Sprintf(" (step of %s)", info.ParentID))
+		}
+		result.WriteString(fmt.Sprintf(", queued %s\n", info.QueueTime.Format(time.RFC3339)))
+	}
+	if len(jobs) == 0 {
+		result.WriteString("No jobs.")
+	}
+	return withStructured(textResult(result.String()), map[string]any{"jobs": jobs})
 }
 
-// handleA2WCRecalcDMS - Recalculate for Docker-Mailserver (sync)
-func handleA2WCRecalcDMS(args map[string]any) ToolCallResult {
-	var cmdArgs []string
+// handleCancelJob - Stop a queued or running job (sync)
+func handleCancelJob(args map[string]any) ToolCallResult {
+	jobID := getString(args, "jobId", "")
+	if jobID == "" {
+		return errorResult("jobId is required")
+	}
+	if refusal, refused := jobRefused(args, jobID); refused {
+		return refusal
+	}
 
-	if dmsDir := getString(args, "dmsDir", ""); dmsDir != "" {
-		cmdArgs = append(cmdArgs, dmsDir)
+	if err := jobMgr.CancelJob(jobID); err != nil {
+		return errorResult(fmt.Sprintf("Cannot cancel job %s: %v", jobID, err))
 	}
 
-	stdout, stderr, exitCode, _ := runSync("a2wcrecalc-dms", cmdArgs...)
+	// Give a killed script a moment to exit so the reply shows the end
+	select {
+	case <-jobMgr.Done(jobID):
+	case <-time.After(CancelSettleTime):
+	}
+	info, found := jobMgr.GetJobStatus(jobID)
+	if !found {
+		return textResult(fmt.Sprintf("Job %s cancelled.", jobID))
+	}
+	return withStructured(textResult(fmt.Sprintf("Job %s cancelled.\n\n%s", jobID, formatJobStatus(info))), newJobContent(info))
+}
 
-	output := formatOutput(stdout, stderr, exitCode)
-	return textResult(output)
+// handleWaitJob - Block until a job finishes or prints a pattern
+func handleWaitJob(args map[string]any) ToolCallResult {
+	jobID := getString(args, "jobId", "")
+	if jobID == "" {
+		return errorResult("jobId is required")
+	}
+	if refusal, refused := jobRefused(args, jobID); refused {
+		return refusal
+	}
+
+	var pattern *regexp.Regexp
+	if p := getString(args, "pattern", ""); p != "" {
+		var err error
+		if pattern, err = regexp.Compile(p); err != nil {
+			return errorResult(fmt.Sprintf("Invalid pattern: %v", err))
+		}
+	}
+
+	maxWait := time.Duration(getInt(args, "maxWaitSeconds", int(DefaultWaitJob/time.Second))) * time.Second
+	if maxWait <= 0 || maxWait > MaxWaitJob {
+		maxWait = MaxWaitJob
+	}
+
+	start := time.Now()
+	info, reason, found := jobMgr.WaitJob(jobID, pattern, maxWait)
+	if !found {
+		return jobNotFound(jobID)
+	}
+
+	waited := time.Since(start).Round(time.Second)
+	content := newJobContent(info)
+	content.WaitReason = reason
+	return withStructured(textResult(fmt.Sprintf("Wait ended after %s: %s\n\n%s", waited, reason, formatJobStatus(info))), content)
 }
 
-// handleCheckJobStatus - Check async job status
-func handleCheckJobStatus(args map[string]any) ToolCallResult {
+// handleSendJobInput - Answer a prompt of a running interactive job (sync)
+func handleSendJobInput(args map[string]any) ToolCallResult {
 	jobID := getString(args, "jobId", "")
 	if jobID == "" {
 		return errorResult("jobId is required")
 	}
+	in := JobInput{
+		Text:      getString(args, "input", ""),
+		Target:    getString(args, "target", ""),
+		NoNewline: !getBool(args, "newline", true),
+		Secret:    getBool(args, "secret", false),
+		EOF:       getBool(args, "eof", false),
+	}
+	if strings.ContainsAny(in.Text, "\r\n") {
+		return errorResult("input must be a single line; send one line per call")
+	}
+	if refusal, refused := jobRefused(args, jobID); refused {
+		return refusal
+	}
+
+	if err := jobMgr.SendInput(jobID, in); err != nil {
+		return errorResult(fmt.Sprintf("Cannot send input to job %s: %v", jobID, err))
+	}
 
-	status, exitCode, output, stderr, found := jobMgr.GetJobStatus(jobID)
+	// Give the script a moment to react so the reply shows its next step
+	info, reason, found := jobMgr.WaitJob(jobID, nil, InputSettleTime)
 	if !found {
-		return errorResult(fmt.Sprintf("Job not found: %s (may have expired after 10 minutes)", jobID))
+		return textResult("Input sent.")
 	}
+	content := newJobContent(info)
+	content.WaitReason = reason
+	return withStructured(textResult(fmt.Sprintf("Input sent. After %s: %s\n\n%s", InputSettleTime, reason, formatJobStatus(info))), content)
+}
+
+// jobNotFound reports an unknown job ID, which may have expired
+func jobNotFound(jobID string) ToolCallResult {
+	return errorResult(fmt.Sprintf("Job not found: %s (finished jobs expire after %s)", jobID, config().Retention.Jobs))
+}
 
+// formatJobStatus renders a job's state for check_job_status and wait_job
+func formatJobStatus(info JobInfo) string {
 	var result strings.Builder
-	result.WriteString(fmt.Sprintf("Status: %s\n", status))
+	result.WriteString(fmt.Sprintf("Status: %s\n", info.Status))
 
-	if status != JobStatusRunning {
-		result.WriteString(fmt.Sprintf("Exit Code: %d\n", exitCode))
+	if info.Status == JobStatusQueued {
+		result.WriteString(fmt.Sprintf("Priority: %s\n", info.Priority))
+		if info.QueuePosition > 0 {
+			result.WriteString(fmt.Sprintf("Queue position: %d\n", info.QueuePosition))
+		}
+		result.WriteString(fmt.Sprintf("Waiting for: %s\n", waitReason(info)))
+		if !info.EstimatedStart.IsZero() {
+			wait := time.Until(info.EstimatedStart).Round(time.Second)
+			result.WriteString(fmt.Sprintf("Estimated start: %s (in ~%s)\n", info.EstimatedStart.Format(time.RFC3339), wait))
+		}
+	} else if info.Status == JobStatusRunning && len(info.Locks) > 0 {
+		result.WriteString(fmt.Sprintf("Locks held: %s\n", strings.Join(info.Locks, ", ")))
 	}
 
-	if output != "" {
-		result.WriteString(fmt.Sprintf("\n--- Output (last %d lines) ---\n%s", MaxOutputLines, output))
+	if info.Prompt != nil {
+		result.WriteString(fmt.Sprintf("Waiting for input on %s since %s: %s\n", info.Prompt.Source, info.Prompt.Since.Format(time.RFC3339), info.Prompt.Text))
 	}
 
-	if stderr != "" {
-		result.WriteString(fmt.Sprintf("\n--- Stderr ---\n%s", stderr))
+	if info.Status != JobStatusRunning && info.Status != JobStatusQueued {
+		result.WriteString(fmt.Sprintf("Exit Code: %d\n", info.ExitCode))
+	}
+
+	if info.Host != "" {
+		result.WriteString(fmt.Sprintf("Host: %s\n", info.Host))
+	}
+
+	if info.ParentID != "" {
+		result.WriteString(fmt.Sprintf("Pipeline: %s\n", info.ParentID))
 	}
 
-	if status == JobStatusRunning {
-		result.WriteString("\n\n⏳ Job still running. Check again in 30-60 seconds.")
-	} else if status == JobStatusCompleted {
+	if info.Source != "" {
+		result.WriteString(fmt.Sprintf("Started by: %s\n", info.Source))
+	}
+
+	if len(info.Attempts) > 1 || (len(info.Attempts) == 1 && info.Attempts[0].Retry) {
+		result.WriteString(fmt.Sprintf("\n--- Attempts (max %d) ---\n", info.MaxAttempts))
+		for _, a := range info.Attempts {
+			result.WriteString(fmt.Sprintf("#%d exit code %d after %s", a.Number, a.ExitCode, a.EndTime.Sub(a.StartTime).Round(time.Second)))
+			if a.Reason != "" {
+				result.WriteString(fmt.Sprintf(": %s", a.Reason))
+			}
+			if a.Retry {
+				result.WriteString(", retrying")
+			}
+			result.WriteString("\n")
+			if a.Retry && a.StderrTail != "" {
+				result.WriteString(fmt.Sprintf("   stderr: %s\n", strings.ReplaceAll(strings.TrimSpace(a.StderrTail), "\n", "\n   ")))
+			}
+		}
+	}
+
+	if len(info.Steps) > 0 {
+		result.WriteString("\n--- Steps ---\n")
+		for _, st := range info.Steps {
+			result.WriteString(fmt.Sprintf("[%s] %s (%s)", st.Status, st.ID, st.Tool))
+			if st.JobID != "" {
+				result.WriteString(fmt.Sprintf(" job %s", st.JobID))
+			}
+			if st.Attempts > 1 {
+				result.WriteString(fmt.Sprintf(", %d attempts", st.Attempts))
+			}
+			if st.Status == StepFailed {
+				result.WriteString(fmt.Sprintf(", exit code %d", st.ExitCode))
+			}
+			result.WriteString("\n")
+		}
+	}
+
+	if info.Output != "" {
+		result.WriteString(fmt.Sprintf("\n--- Output (last %d lines) ---\n%s", strings.Count(strings.TrimRight(info.Output, "\n"), "\n")+1, info.Output))
+	}
+
+	if info.Stderr != "" {
+		result.WriteString(fmt.Sprintf("\n--- Stderr ---\n%s", info.Stderr))
+	}
+
+	if len(info.Artifacts) > 0 {
+		result.WriteString(fmt.Sprintf("\n--- Changed files (%d) ---\n", len(info.Artifacts)))
+		diffs := formatChanges(info.Artifacts, true)
+		if len(diffs) > MaxStatusDiff {
+			diffs = formatChanges(info.Artifacts, false) + fmt.Sprintf("\nDiffs are too long to show here; read the %s resource for them.\n", artifactURI(info.ID))
+		}
+		result.WriteString(diffs)
+	}
+
+	if info.Status == JobStatusQueued {
+		result.WriteString("\n\n⏳ Job queued. Use wait_job to wait for it.")
+	} else if info.Prompt != nil {
+		msg := "\n\n⌨️ Job is waiting for input. Answer it with send_job_input"
+		if info.Prompt.Sensitive {
+			msg += " (secret: true keeps the answer out of the log)"
+		}
+		result.WriteString(msg + ".")
+	} else if info.Status == JobStatusRunning {
+		result.WriteString("\n\n⏳ Job still running. Use wait_job to wait for it.")
+	} else if info.Status == JobStatusCompleted {
 		result.WriteString("\n\n✅ Job completed successfully.")
+	} else if info.Cancelled {
+		result.WriteString("\n\n⏹️ Job was cancelled.")
 	} else {
 		result.WriteString("\n\n❌ Job failed. Review stderr for details.")
 	}
 
-	return textResult(result.String())
+	return result.String()
 }
 
 // formatOutput formats command output for display
@@ -0,0 +1,396 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHelperSocket is where the privileged helper listens
+const DefaultHelperSocket = "/run/a2cmds-mcp/helper.sock"
+
+// helperFrame is one message on a helper connection, sent as a JSON line.
+// The server sends "start" and then "stdin", "stdin-eof", "tty" and "kill"; the
+// helper answers "started" or "error", then streams "stdout", "stderr"
+// and "tty" and ends with "exit".
+type helperFrame struct {
+	Type    string       `json:"type"`
+	Request *ExecRequest `json:"request,omitempty"`
+	Data    []byte       `json:"data,omitempty"`
+
+	// started
+	Pid      int      `json:"pid,omitempty"`
+	Argv     []string `json:"argv,omitempty"`
+	TTY      bool     `json:"tty,omitempty"`
+	PTYError string   `json:"ptyError,omitempty"`
+	// OutputFiles are on the helper's machine, readable by root
+	OutputFiles []string `json:"outputFiles,omitempty"`
+
+	// exit and error
+	ExitCode int    `json:"exitCode,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// frameConn serialises frame writes on a connection
+type frameConn struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (c *frameConn) send(f helperFrame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enc.Encode(f)
+}
+
+// frameWriter sends whatever is written to it as frames of one type
+type frameWriter struct {
+	conn  *frameConn
+	typ   string
+	close func() error
+}
+
+func (w frameWriter) Write(p []byte) (int, error) {
+	if err := w.conn.send(helperFrame{Type: w.typ, Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w frameWriter) Close() error {
+	if w.close == nil {
+		return nil
+	}
+	return w.close()
+}
+
+// ==================== SERVER SIDE ====================
+
+// helperExecutor runs scripts through the privileged helper, so the MCP
+// server itself can run unprivileged
+type helperExecutor struct {
+	socket string
+}
+
+func (h helperExecutor) Start(req ExecRequest) (*Process, error) {
+	conn, err := net.DialTimeout("unix", h.socket, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("privileged helper: %v", err)
+	}
+	fc := &frameConn{enc: json.NewEncoder(conn)}
+	dec := json.NewDecoder(conn)
+
+	// A helper that refuses the connection says why before it hangs up,
+	// possibly before reading the request
+	sendErr := fc.send(helperFrame{Type: "start", Request: &req})
+	var started helperFrame
+	if err := dec.Decode(&started); err != nil {
+		conn.Close()
+		if sendErr != nil {
+			err = sendErr
+		}
+		return nil, fmt.Errorf("privileged helper: %v", err)
+	}
+	if started.Type != "started" {
+		conn.Close()
+		if started.Error == "" {
+			started.Error = "unexpected " + started.Type + " reply"
+		}
+		return nil, fmt.Errorf("privileged helper refused: %s", started.Error)
+	}
+
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	proc := &Process{Argv: started.Argv, Pid: started.Pid, Stdout: stdoutR, Stderr: stderrR, OutputFiles: started.OutputFiles}
+	if started.PTYError != "" {
+		proc.PTYErr = errors.New(started.PTYError)
+	}
+	if req.Stdin {
+		proc.Stdin = frameWriter{conn: fc, typ: "stdin", close: func() error {
+			return fc.send(helperFrame{Type: "stdin-eof"})
+		}}
+	}
+	var ttyR *io.PipeReader
+	var ttyW *io.PipeWriter
+	if started.TTY {
+		ttyR, ttyW = io.Pipe()
+		proc.TTY = helperTTY{PipeReader: ttyR, in: frameWriter{conn: fc, typ: "tty"}}
+	}
+	proc.kill = func() error {
+		return fc.send(helperFrame{Type: "kill"})
+	}
+
+	done := make(chan struct{})
+	exitCode, exitErr := -1, error(nil)
+	go func() {
+		defer close(done)
+		defer conn.Close()
+		for {
+			var f helperFrame
+			if err := dec.Decode(&f); err != nil {
+				exitErr = fmt.Errorf("lost connection to the privileged helper: %v", err)
+				break
+			}
+			switch f.Type {
+			case "stdout":
+				stdoutW.Write(f.Data)
+				continue
+			case "stderr":
+				stderrW.Write(f.Data)
+				continue
+			case "tty":
+				if ttyW != nil {
+					ttyW.Write(f.Data)
+				}
+				continue
+			case "exit":
+				exitCode = f.ExitCode
+				if f.Error != "" {
+					exitErr = errors.New(f.Error)
+				}
+			default:
+				exitErr = fmt.Errorf("unexpected %q frame from the privileged helper", f.Type)
+			}
+			break
+		}
+		stdoutW.Close()
+		stderrW.Close()
+		if ttyW != nil {
+			ttyW.Close()
+		}
+	}()
+
+	proc.wait = func() (int, error) {
+		<-done
+		return exitCode, exitErr
+	}
+	return proc, nil
+}
+
+// helperTTY reads terminal output relayed by the helper and sends input
+// to it
+type helperTTY struct {
+	*io.PipeReader
+	in frameWriter
+}
+
+func (t helperTTY) Write(p []byte) (int, error) {
+	return t.in.Write(p)
+}
+
+// ==================== HELPER SIDE ====================
+
+// HelperConfig controls who may use the helper
+type HelperConfig struct {
+	Socket string
+	// Group owns the socket, which is then 0660; without it only root
+	// can connect
+	Group string
+	// AllowUIDs may run commands besides root
+	AllowUIDs map[uint32]bool
+}
+
+// parseHelperUsers resolves a comma-separated list of user names or IDs
+func parseHelperUsers(list string) (map[uint32]bool, error) {
+	uids := map[uint32]bool{0: true}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		u, err := user.Lookup(name)
+		if err != nil {
+			if u, err = user.LookupId(name); err != nil {
+				return nil, fmt.Errorf("unknown user %q", name)
+			}
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("user %q: %v", name, err)
+		}
+		uids[uint32(uid)] = true
+	}
+	return uids, nil
+}
+
+// runHelper serves the privileged helper until the process is stopped.
+// It runs only the allowlisted scripts in argvRules, with their tools'
+// execution profiles, for the users in cfg.AllowUIDs.
+func runHelper(cfg HelperConfig) error {
+	if os.Geteuid() != 0 {
+		fmt.Fprintln(os.Stderr, "Warning: the helper is not running as root; scripts that need root will fail")
+	}
+
+	ln, err := listenUnix(cfg.Socket, cfg.Group)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	fmt.Fprintf(os.Stderr, "Privileged helper listening on %s\n", cfg.Socket)
+	for {
+		conn, err := ln.AcceptUnix()
+		if err != nil {
+			return err
+		}
+		go serveHelperConn(conn, cfg)
+	}
+}
+
+// listenUnix listens on a unix socket that only its owner can connect
+// to, or also group when one is given
+func listenUnix(path, group string) (*net.UnixListener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+
+	mode := os.FileMode(0600)
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		gid, _ := strconv.Atoi(g.Gid)
+		if err := os.Chown(path, -1, gid); err != nil {
+			ln.Close()
+			return nil, err
+		}
+		mode = 0660
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// serveHelperConn runs one command for one connection
+func serveHelperConn(conn *net.UnixConn, cfg HelperConfig) {
+	defer conn.Close()
+	fc := &frameConn{enc: json.NewEncoder(conn)}
+	dec := json.NewDecoder(conn)
+
+	refuse := func(format string, a ...any) {
+		msg := fmt.Sprintf(format, a...)
+		fmt.Fprintf(os.Stderr, "Helper: refused: %s\n", msg)
+		fc.send(helperFrame{Type: "error", Error: msg})
+	}
+
+	uid, err := peerUID(conn)
+	if err != nil {
+		refuse("cannot identify peer: %v", err)
+		return
+	}
+	if !cfg.AllowUIDs[uid] {
+		refuse("uid %d is not allowed", uid)
+		return
+	}
+
+	var start helperFrame
+	if err := dec.Decode(&start); err != nil || start.Type != "start" || start.Request == nil {
+		refuse("expected a start request from uid %d", uid)
+		return
+	}
+	req := *start.Request
+	if err := validateArgv(req.Tool, req.Name, req.Args); err != nil {
+		refuse("uid %d: %v", uid, err)
+		return
+	}
+	if _, ok := parseTraceparent(req.TraceParent); !ok {
+		req.TraceParent = ""
+	}
+
+	proc, err := localExecutor{}.Start(req)
+	if err != nil {
+		refuse("uid %d: starting %s: %v", uid, req.Name, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Helper: uid %d started %s %s (pid %d)\n", uid, req.Name, strings.Join(req.Args, " "), proc.Pid)
+
+	started := helperFrame{Type: "started", Pid: proc.Pid, Argv: proc.Argv, TTY: proc.TTY != nil, OutputFiles: proc.OutputFiles}
+	if proc.PTYErr != nil {
+		started.PTYError = proc.PTYErr.Error()
+	}
+	fc.send(started)
+
+	// Input from the server. If the server goes away the script keeps
+	// running, as it would if the server had been killed.
+	go func() {
+		for {
+			var f helperFrame
+			if err := dec.Decode(&f); err != nil {
+				return
+			}
+			switch {
+			case f.Type == "stdin" && proc.Stdin != nil:
+				proc.Stdin.Write(f.Data)
+			case f.Type == "stdin-eof" && proc.Stdin != nil:
+				proc.Stdin.Close()
+			case f.Type == "tty" && proc.TTY != nil:
+				proc.TTY.Write(f.Data)
+			case f.Type == "kill":
+				fmt.Fprintf(os.Stderr, "Helper: uid %d stopped pid %d\n", uid, proc.Pid)
+				proc.Kill()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(frameWriter{conn: fc, typ: "stdout"}, proc.Stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(frameWriter{conn: fc, typ: "stderr"}, proc.Stderr)
+	}()
+	ttyDone := make(chan struct{})
+	if proc.TTY != nil {
+		go func() {
+			defer close(ttyDone)
+			io.Copy(frameWriter{conn: fc, typ: "tty"}, proc.TTY)
+			// Without the server, keep reading so the script does not
+			// block once the terminal's buffer is full
+			io.Copy(io.Discard, proc.TTY)
+		}()
+	} else {
+		close(ttyDone)
+	}
+
+	exitCode, err := proc.Wait()
+	wg.Wait()
+	select {
+	case <-ttyDone:
+	case <-time.After(outputGrace):
+		proc.TTY.Close()
+		<-ttyDone
+	}
+	if proc.TTY != nil {
+		proc.TTY.Close()
+	}
+
+	exit := helperFrame{Type: "exit", ExitCode: exitCode}
+	if err != nil {
+		exit.Error = err.Error()
+	}
+	fc.send(exit)
+	fmt.Fprintf(os.Stderr, "Helper: pid %d exited with code %d\n", proc.Pid, exitCode)
+}
@@ -0,0 +1,151 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// startHelper serves helper connections on a socket in a temporary
+// directory with cfg, and returns the socket's path
+func startHelper(t *testing.T, cfg HelperConfig) string {
+	t.Helper()
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "helper.sock")
+	ln, err := listenUnix(socket, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	oldOutputDir := outputDir
+	outputDir = filepath.Join(dir, "output")
+	t.Cleanup(func() { outputDir = oldOutputDir })
+
+	go func() {
+		for {
+			conn, err := ln.AcceptUnix()
+			if err != nil {
+				return
+			}
+			go serveHelperConn(conn, cfg)
+		}
+	}()
+	return socket
+}
+
+// selfOnly allows this test's user to use the helper
+func selfOnly() map[uint32]bool {
+	return map[uint32]bool{uint32(os.Getuid()): true}
+}
+
+func TestHelperRefuses(t *testing.T) {
+	tests := []struct {
+		name    string
+		allow   map[uint32]bool
+		start   *helperFrame
+		wantErr string
+	}{
+		{
+			name:    "peer not allowed",
+			allow:   map[uint32]bool{uint32(os.Getuid()) + 1: true},
+			start:   &helperFrame{Type: "start", Request: &ExecRequest{Tool: "fqdncredmgr_list", Name: "fqdncredmgr", Args: []string{"list"}}},
+			wantErr: "is not allowed",
+		},
+		{
+			name:    "no request",
+			allow:   selfOnly(),
+			start:   &helperFrame{Type: "stdin", Data: []byte("x")},
+			wantErr: "expected a start request",
+		},
+		{
+			name:    "unknown tool",
+			allow:   selfOnly(),
+			start:   &helperFrame{Type: "start", Request: &ExecRequest{Tool: "shell", Name: "sh", Args: []string{"-c", "id"}}},
+			wantErr: `tool "shell" is not allowed to run commands`,
+		},
+		{
+			name:    "other script",
+			allow:   selfOnly(),
+			start:   &helperFrame{Type: "start", Request: &ExecRequest{Tool: "fqdncredmgr_list", Name: "sh", Args: []string{"list"}}},
+			wantErr: "runs fqdncredmgr",
+		},
+		{
+			name:    "other subcommand",
+			allow:   selfOnly(),
+			start:   &helperFrame{Type: "start", Request: &ExecRequest{Tool: "fqdncredmgr_list", Name: "fqdncredmgr", Args: []string{"delete", "namecheap.com"}}},
+			wantErr: "list subcommand",
+		},
+		{
+			name:    "unlisted option",
+			allow:   selfOnly(),
+			start:   &helperFrame{Type: "start", Request: &ExecRequest{Tool: "a2sitemgr", Name: "a2sitemgr", Args: []string{"-d", "example.com", "--exec", "id"}}},
+			wantErr: `option "--exec" is not allowed`,
+		},
+		{
+			name:    "bad value",
+			allow:   selfOnly(),
+			start:   &helperFrame{Type: "start", Request: &ExecRequest{Tool: "a2sitemgr", Name: "a2sitemgr", Args: []string{"-d", "example.com/../../etc"}}},
+			wantErr: "a2sitemgr: -d:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			socket := startHelper(t, HelperConfig{AllowUIDs: tt.allow})
+			conn, err := net.Dial("unix", socket)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			json.NewEncoder(conn).Encode(tt.start)
+			var reply helperFrame
+			if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+				t.Fatalf("reading the reply: %v", err)
+			}
+			if reply.Type != "error" || !strings.Contains(reply.Error, tt.wantErr) {
+				t.Errorf("reply %s %q, want an error containing %q", reply.Type, reply.Error, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHelperRunsAllowedCommand(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "fqdncredmgr")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$1\"\necho oops >&2\nexit 3\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	useConfig(t, func(c *Config) { c.Binaries = map[string]string{"fqdncredmgr": script} })
+	socket := startHelper(t, HelperConfig{AllowUIDs: selfOnly()})
+
+	proc, err := helperExecutor{socket: socket}.Start(ExecRequest{Tool: "fqdncredmgr_list", Name: "fqdncredmgr", Args: []string{"list"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The helper relays both streams over one connection, so read them
+	// together as the job queue does
+	stderrDone := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(proc.Stderr)
+		stderrDone <- b
+	}()
+	stdout, _ := io.ReadAll(proc.Stdout)
+	stderr := <-stderrDone
+	code, err := proc.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stdout) != "list\n" || string(stderr) != "oops\n" || code != 3 {
+		t.Errorf("got stdout %q, stderr %q, exit code %d; want \"list\\n\", \"oops\\n\", 3", stdout, stderr, code)
+	}
+
+	_, err = helperExecutor{socket: socket}.Start(ExecRequest{Tool: "fqdncredmgr_list", Name: "fqdncredmgr", Args: []string{"list", "-x"}})
+	if err == nil || !strings.Contains(err.Error(), "privileged helper refused") {
+		t.Errorf("Start with a refused option = %v, want the helper's refusal", err)
+	}
+}
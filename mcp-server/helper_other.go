@@ -0,0 +1,12 @@
+//go:build !linux
+
+package mcpserver
+
+import (
+	"errors"
+	"net"
+)
+
+func peerUID(conn *net.UnixConn) (uint32, error) {
+	return 0, errors.New("peer credentials are only supported on Linux")
+}
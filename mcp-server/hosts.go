@@ -0,0 +1,139 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultHostsFile lists the remote Apache hosts tools can run on
+const DefaultHostsFile = "/etc/a2cmds-mcp/hosts.json"
+
+// LocalHost names the machine the server runs on
+const LocalHost = "local"
+
+// RemoteHost is a machine with a2tools installed, reached over SSH
+type RemoteHost struct {
+	// Address is host or host:port; port 22 is the default
+	Address string `json:"address"`
+	User    string `json:"user"`
+	// IdentityFile is the private key to log in with
+	IdentityFile string `json:"identityFile"`
+	// KnownHosts overrides the inventory's known_hosts file
+	KnownHosts string `json:"knownHosts,omitempty"`
+	// Sudo runs scripts with sudo -n for users other than root
+	Sudo bool `json:"sudo,omitempty"`
+	// DMSDir is passed to a2wcrecalc_dms as DMS_DIR on this host
+	DMSDir string `json:"dmsDir,omitempty"`
+}
+
+// HostInventory is the hosts file
+type HostInventory struct {
+	// KnownHosts is the known_hosts file host keys are checked against
+	KnownHosts string                 `json:"knownHosts"`
+	Hosts      map[string]*RemoteHost `json:"hosts"`
+}
+
+var activeHosts atomic.Pointer[HostInventory]
+
+// hosts returns the loaded inventory; empty means everything runs locally
+func hosts() *HostInventory {
+	if inv := activeHosts.Load(); inv != nil {
+		return inv
+	}
+	return &HostInventory{}
+}
+
+// reloadHosts reads the hosts file again and swaps it in. Jobs already
+// started keep the host they were built for; an invalid file is ignored.
+func reloadHosts(path string) {
+	inv, err := LoadHostInventory(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Hosts reload failed, keeping the current hosts: %v\n", err)
+		return
+	}
+	activeHosts.Store(inv)
+	fmt.Fprintf(os.Stderr, "Hosts reloaded from %s\n", path)
+}
+
+// LoadHostInventory reads the hosts file. A missing file is an empty
+// inventory.
+func LoadHostInventory(path string) (*HostInventory, error) {
+	inv := &HostInventory{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return inv, nil
+	}
+	if err != nil {
+		return inv, err
+	}
+	if err := json.Unmarshal(data, inv); err != nil {
+		return &HostInventory{}, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for name, h := range inv.Hosts {
+		if err := checkName(name); err != nil || name == LocalHost {
+			return &HostInventory{}, fmt.Errorf("%s: invalid host name %q", path, name)
+		}
+		if h.Address == "" || h.User == "" || h.IdentityFile == "" {
+			return &HostInventory{}, fmt.Errorf("%s: host %s needs address, user and identityFile", path, name)
+		}
+		if h.KnownHosts == "" {
+			h.KnownHosts = inv.KnownHosts
+		}
+		if h.KnownHosts == "" {
+			return &HostInventory{}, fmt.Errorf("%s: host %s has no knownHosts file; host keys are always checked", path, name)
+		}
+	}
+	return inv, nil
+}
+
+// Names returns the inventory's host names, sorted
+func (inv *HostInventory) Names() []string {
+	names := make([]string, 0, len(inv.Hosts))
+	for name := range inv.Hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveHost checks a tool's host argument. The local machine is "".
+func resolveHost(host string) (string, error) {
+	host = strings.TrimSpace(host)
+	if host == "" || host == LocalHost {
+		return "", nil
+	}
+	if replayer != nil && replayer.HasHost(host) {
+		return host, nil
+	}
+	inv := hosts()
+	if _, ok := inv.Hosts[host]; !ok {
+		known := append([]string{LocalHost}, inv.Names()...)
+		return "", fmt.Errorf("unknown host %q; known hosts: %s", host, strings.Join(known, ", "))
+	}
+	return host, nil
+}
+
+// executorFor returns the executor that runs commands on host, or
+// replays or records them with -replay and -record
+func executorFor(host string) (Executor, error) {
+	if replayer != nil {
+		return replayer.forHost(host), nil
+	}
+	ex := executor
+	if host != "" {
+		h, ok := hosts().Hosts[host]
+		if !ok {
+			return nil, fmt.Errorf("unknown host %q", host)
+		}
+		ex = sshExecutorFor(host, h)
+	}
+	if recorder != nil {
+		ex = recorder.wrap(host, ex)
+	}
+	return ex, nil
+}
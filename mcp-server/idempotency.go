@@ -0,0 +1,271 @@
+package mcpserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultIdempotencyRetention = 24 * time.Hour
+
+// derivedIdempotencyKeys derive a key from the arguments that decide what
+// a call does, for tools that cost money or clobber DNS when run twice and
+// are called without an idempotencyKey. Flags that only change how the
+// script runs, such as verbose, interactive and priority, are left out,
+// and domains are compared case-insensitively. idempotencyKeyFor adds the
+// host the call runs on.
+var derivedIdempotencyKeys = map[string]func(args map[string]any) string{
+	"fqdnmgr_purchase": func(args map[string]any) string {
+		return normalizeKeyPart(getString(args, "fqdn", "")) + "\x00" + normalizeKeyPart(getString(args, "registrar", ""))
+	},
+	"fqdnmgr_setInitDNSRecords": func(args map[string]any) string {
+		var domains []string
+		for _, d := range strings.Fields(strings.ReplaceAll(getString(args, "domains", ""), ",", " ")) {
+			domains = append(domains, normalizeKeyPart(d))
+		}
+		// Without domains the user picks them at the prompt
+		if len(domains) == 0 {
+			return ""
+		}
+		sort.Strings(domains)
+		domains = slices.Compact(domains)
+		// A run with override replaces the records a run without it
+		// refused to touch, so it is a different call
+		override := strconv.FormatBool(getBool(args, "override", false))
+		return strings.Join(domains, ",") + "\x00" + normalizeKeyPart(getString(args, "registrar", "")) + "\x00" + override
+	},
+}
+
+func normalizeKeyPart(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// IdempotencyRecord remembers the job started for a key and, once it
+// finishes, its result
+type IdempotencyRecord struct {
+	Key       string    `json:"key"`
+	Tool      string    `json:"tool"`
+	JobID     string    `json:"jobId"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Caller started the job, as Caller.id
+	Caller string `json:"caller,omitempty"`
+
+	Status   JobStatus `json:"status"`
+	ExitCode int       `json:"exitCode"`
+	Output   string    `json:"output,omitempty"`
+	Stderr   string    `json:"stderr,omitempty"`
+	EndTime  time.Time `json:"endTime"`
+}
+
+// IdempotencyStore maps idempotency keys to jobs and keeps them in a JSON
+// file so a retry after a restart still finds the original job
+type IdempotencyStore struct {
+	path      string
+	retention time.Duration
+
+	mu      sync.Mutex
+	records map[string]*IdempotencyRecord
+	byJob   map[string]*IdempotencyRecord
+}
+
+// idempotency is set up by NewServer
+var idempotency *IdempotencyStore
+
+// NewIdempotencyStore loads the keys saved at path and records the results
+// of jobs jm finishes. Jobs that were unfinished when the server stopped
+// keep their key: their outcome is unknown, so they are not rerun.
+func NewIdempotencyStore(path string, retention time.Duration, jm JobManager) (*IdempotencyStore, error) {
+	s := &IdempotencyStore{
+		path:      path,
+		retention: retention,
+		records:   make(map[string]*IdempotencyRecord),
+		byJob:     make(map[string]*IdempotencyRecord),
+	}
+	jm.OnJobFinished(s.jobFinished)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+
+	var list []*IdempotencyRecord
+	if err := json.Unmarshal(data, &list); err != nil {
+		return s, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	for _, rec := range list {
+		if rec.Status == JobStatusQueued || rec.Status == JobStatusRunning {
+			rec.Status = JobStatusFailed
+			rec.ExitCode = -1
+			rec.Stderr += "The server stopped before this job finished; its outcome is unknown. Check the current state (e.g. with fqdnmgr_check) before running it again with a new idempotencyKey.\n"
+		}
+		s.records[rec.Key] = rec
+		s.byJob[rec.JobID] = rec
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	return s, s.saveLocked()
+}
+
+// idempotencyKeyFor returns the key for a tool call: the caller's
+// idempotencyKey, or one derived from the arguments for tools that must
+// not run twice. Other calls have no key.
+func idempotencyKeyFor(tool string, args map[string]any) string {
+	if key := getString(args, "idempotencyKey", ""); key != "" {
+		return "key:" + tool + ":" + key
+	}
+	derive, ok := derivedIdempotencyKeys[tool]
+	if !ok {
+		return ""
+	}
+	semantic := derive(args)
+	if semantic == "" {
+		return ""
+	}
+	host := normalizeKeyPart(getString(args, "host", ""))
+	sum := sha256.Sum256([]byte(tool + "\x00" + host + "\x00" + semantic))
+	return "auto:" + hex.EncodeToString(sum[:])
+}
+
+// submitIdempotent calls start unless the call has an idempotency key
+// that already started a job; then it returns that job's record with
+// existing set. Tool calls, pipeline steps and schedules all start their
+// jobs through here.
+func submitIdempotent(tool string, args map[string]any, start func() (string, error)) (rec IdempotencyRecord, existing bool, err error) {
+	key := idempotencyKeyFor(tool, args)
+	if key == "" || idempotency == nil {
+		jobID, err := start()
+		return IdempotencyRecord{Tool: tool, JobID: jobID}, false, err
+	}
+	return idempotency.Submit(key, tool, start)
+}
+
+// Submit returns the record for key if it exists and has not expired.
+// Otherwise it calls start and records the job it started. Concurrent
+// calls with the same key start one job.
+func (s *IdempotencyStore) Submit(key, tool string, start func() (string, error)) (rec IdempotencyRecord, existing bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.pruneLocked(now)
+	if r, ok := s.records[key]; ok {
+		return *r, true, nil
+	}
+
+	jobID, err := start()
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+
+	r := &IdempotencyRecord{
+		Key:       key,
+		Tool:      tool,
+		JobID:     jobID,
+		CreatedAt: now,
+		Status:    JobStatusQueued,
+	}
+	s.records[key] = r
+	s.byJob[jobID] = r
+	if err := s.saveLocked(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving idempotency keys: %v\n", err)
+	}
+	return *r, false, nil
+}
+
+// ByJobID returns the stored result of a job started with a key
+func (s *IdempotencyStore) ByJobID(jobID string) (IdempotencyRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.byJob[jobID]
+	if !ok {
+		return IdempotencyRecord{}, false
+	}
+	return *r, true
+}
+
+// jobFinished stores the result of a job that has a key. A job that
+// failed without running its command, because it was cancelled while
+// queued or could not be spawned, did nothing, so its key is forgotten
+// and the same call may be made again.
+func (s *IdempotencyStore) jobFinished(spec JobSpec, info JobInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.byJob[info.ID]
+	if !ok {
+		return
+	}
+	if info.Status == JobStatusFailed && len(info.Attempts) == 0 {
+		delete(s.records, r.Key)
+		delete(s.byJob, r.JobID)
+		if err := s.saveLocked(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving idempotency keys: %v\n", err)
+		}
+		return
+	}
+	r.Caller = spec.Caller
+	r.Status = info.Status
+	r.ExitCode = info.ExitCode
+	r.Output = info.Output
+	r.Stderr = info.Stderr
+	r.EndTime = info.EndTime
+	if err := s.saveLocked(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving idempotency keys: %v\n", err)
+	}
+}
+
+// SetRetention changes how long keys are kept
+func (s *IdempotencyStore) SetRetention(retention time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retention = retention
+}
+
+// pruneLocked drops keys older than the retention window. Callers hold s.mu.
+func (s *IdempotencyStore) pruneLocked(now time.Time) {
+	for key, r := range s.records {
+		if now.Sub(r.CreatedAt) > s.retention {
+			delete(s.records, key)
+			delete(s.byJob, r.JobID)
+		}
+	}
+}
+
+// saveLocked writes all keys to disk atomically. Callers hold s.mu.
+func (s *IdempotencyStore) saveLocked() error {
+	list := make([]*IdempotencyRecord, 0, len(s.records))
+	for _, r := range s.records {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].CreatedAt.Before(list[k].CreatedAt) })
+	return saveJSON(s.path, list)
+}
+
+// info turns a stored record into the job info check_job_status shows
+func (r IdempotencyRecord) info() JobInfo {
+	return JobInfo{
+		ID:        r.JobID,
+		Tool:      r.Tool,
+		Caller:    r.Caller,
+		Status:    r.Status,
+		ExitCode:  r.ExitCode,
+		Output:    r.Output,
+		Stderr:    r.Stderr,
+		QueueTime: r.CreatedAt,
+		EndTime:   r.EndTime,
+	}
+}
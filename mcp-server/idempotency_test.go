@@ -0,0 +1,100 @@
+package mcpserver
+
+import "testing"
+
+func TestDerivedIdempotencyKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		tool string
+		a, b map[string]any
+		same bool
+	}{
+		{
+			name: "purchase case and spaces",
+			tool: "fqdnmgr_purchase",
+			a:    map[string]any{"fqdn": "Example.com", "registrar": "namecheap.com"},
+			b:    map[string]any{"fqdn": " example.com", "registrar": "NameCheap.com"},
+			same: true,
+		},
+		{
+			name: "purchase verbose and priority",
+			tool: "fqdnmgr_purchase",
+			a:    map[string]any{"fqdn": "example.com", "registrar": "namecheap.com"},
+			b:    map[string]any{"fqdn": "example.com", "registrar": "namecheap.com", "verbose": false, "priority": "high"},
+			same: true,
+		},
+		{
+			name: "purchase other domain",
+			tool: "fqdnmgr_purchase",
+			a:    map[string]any{"fqdn": "example.com", "registrar": "namecheap.com"},
+			b:    map[string]any{"fqdn": "example.org", "registrar": "namecheap.com"},
+		},
+		{
+			name: "purchase other registrar",
+			tool: "fqdnmgr_purchase",
+			a:    map[string]any{"fqdn": "example.com", "registrar": "namecheap.com"},
+			b:    map[string]any{"fqdn": "example.com", "registrar": "godaddy.com"},
+		},
+		{
+			name: "purchase other host",
+			tool: "fqdnmgr_purchase",
+			a:    map[string]any{"fqdn": "example.com", "registrar": "namecheap.com"},
+			b:    map[string]any{"fqdn": "example.com", "registrar": "namecheap.com", "host": "web1"},
+		},
+		{
+			name: "DNS domain order and duplicates",
+			tool: "fqdnmgr_setInitDNSRecords",
+			a:    map[string]any{"domains": "b.com,a.com", "registrar": "namecheap.com"},
+			b:    map[string]any{"domains": "A.com b.com, a.com", "registrar": "namecheap.com", "interactive": true},
+			same: true,
+		},
+		{
+			name: "DNS override",
+			tool: "fqdnmgr_setInitDNSRecords",
+			a:    map[string]any{"domains": "a.com", "registrar": "namecheap.com"},
+			b:    map[string]any{"domains": "a.com", "registrar": "namecheap.com", "override": true},
+		},
+		{
+			name: "DNS other host",
+			tool: "fqdnmgr_setInitDNSRecords",
+			a:    map[string]any{"domains": "a.com", "registrar": "namecheap.com", "host": "web1"},
+			b:    map[string]any{"domains": "a.com", "registrar": "namecheap.com", "host": "web2"},
+		},
+		{
+			name: "DNS other domains",
+			tool: "fqdnmgr_setInitDNSRecords",
+			a:    map[string]any{"domains": "a.com", "registrar": "namecheap.com"},
+			b:    map[string]any{"domains": "a.com,b.com", "registrar": "namecheap.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := idempotencyKeyFor(tt.tool, tt.a), idempotencyKeyFor(tt.tool, tt.b)
+			if a == "" || b == "" {
+				t.Fatalf("no key derived: %q, %q", a, b)
+			}
+			if (a == b) != tt.same {
+				t.Errorf("keys equal = %v, want %v", a == b, tt.same)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeyFor(t *testing.T) {
+	tests := []struct {
+		name string
+		tool string
+		args map[string]any
+		want string
+	}{
+		{"caller key", "a2sitemgr", map[string]any{"fqdn": "a.com", "idempotencyKey": "k1"}, "key:a2sitemgr:k1"},
+		{"no derived key", "a2sitemgr", map[string]any{"fqdn": "a.com"}, ""},
+		{"domains picked at the prompt", "fqdnmgr_setInitDNSRecords", map[string]any{"registrar": "namecheap.com", "interactive": true}, ""},
+	}
+	for _, tt := range tests {
+		if got := idempotencyKeyFor(tt.tool, tt.args); got != tt.want {
+			t.Errorf("%s: idempotencyKeyFor = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
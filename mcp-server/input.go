@@ -0,0 +1,342 @@
+package mcpserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Prompt detection. A job is waiting for input when it has been quiet for
+// promptIdle after printing a partial line, or a line that reads like a
+// question. Scripts print their prompts with printf or read -p, which
+// leave the cursor on the prompt line.
+const (
+	promptIdle     = time.Second
+	promptInterval = 250 * time.Millisecond
+	// maxPartialLine is how much of an unterminated line is held back
+	// before it is logged anyway
+	maxPartialLine = 64 << 10
+	// InputSettleTime is how long send_job_input waits for the job to
+	// respond before returning its status
+	InputSettleTime = 3 * time.Second
+)
+
+// DefaultInputTimeout is how long a prompt may go unanswered before the
+// job's input is closed so the script takes its default or gives up
+const DefaultInputTimeout = 10 * time.Minute
+
+// promptLine matches complete lines that ask for input, such as the
+// domain selection in fqdnmgr setInitDNSRecords ("Press Enter for all")
+var promptLine = regexp.MustCompile(`(?i)([?:>]|[\[(]y/n[\])])\s*$|press enter|^enter |^(select|choose) `)
+
+// sensitivePrompt matches prompts whose answer must not be logged or
+// requested through the client's elicitation UI
+var sensitivePrompt = regexp.MustCompile(`(?i)pass(word|phrase)?\b|secret|api[ _-]?key|token|credential`)
+
+// Where a prompt was printed, and where answers are written
+const (
+	InputStdin    = "stdin"
+	InputTerminal = "terminal"
+)
+
+// JobPrompt is a question a running job is waiting on
+type JobPrompt struct {
+	Text      string
+	Source    string // "stdout", "stderr" or "terminal"
+	Since     time.Time
+	Sensitive bool
+	// Seq numbers the prompts of a job
+	Seq int
+}
+
+// inputTarget returns where answers to this prompt go by default. Prompts
+// shown on the terminal are usually read from it too, as a2sitemgr does
+// when it runs fqdnmgr with </dev/tty.
+func (p *JobPrompt) inputTarget() string {
+	if p != nil && p.Source == InputTerminal {
+		return InputTerminal
+	}
+	return InputStdin
+}
+
+// readLines reads r and calls line for every complete line and partial
+// with whatever follows the last newline, after each read. Long partial
+// lines are passed to line once they reach maxPartialLine.
+func readLines(r io.Reader, line func(string), partial func(string)) {
+	buf := make([]byte, 32*1024)
+	var pending []byte
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			pending = append(pending, buf[:n]...)
+			for {
+				i := bytes.IndexByte(pending, '\n')
+				if i < 0 {
+					break
+				}
+				line(string(bytes.TrimSuffix(pending[:i], []byte("\r"))))
+				pending = pending[i+1:]
+			}
+			if len(pending) >= maxPartialLine {
+				line(string(pending))
+				pending = nil
+			}
+			partial(string(pending))
+		}
+		if err != nil {
+			if len(pending) > 0 {
+				line(string(pending))
+			}
+			return
+		}
+	}
+}
+
+// outputSeenLocked records that the job printed something to source.
+// Any pending prompt is considered answered or abandoned. Callers hold j.mu.
+func (j *Job) outputSeenLocked(source, partial string) {
+	j.lastOutput = time.Now()
+	j.lastSource = source
+	if j.partial == nil {
+		j.partial = map[string]string{}
+	}
+	j.partial[source] = partial
+	j.promptArmed = true
+	if j.prompt != nil {
+		j.prompt = nil
+		j.notifyLocked()
+	}
+}
+
+// stderrPartial returns the unterminated tail of the job's stderr
+func (j *Job) stderrPartial() string {
+	b := j.stderrBuffer.Bytes()
+	return string(b[bytes.LastIndexByte(b, '\n')+1:])
+}
+
+// detectPromptLocked reports a new prompt if the job has gone quiet on
+// one. Callers hold j.mu.
+func (j *Job) detectPromptLocked(now time.Time) *JobPrompt {
+	if !j.promptArmed || j.prompt != nil || now.Sub(j.lastOutput) < promptIdle {
+		return nil
+	}
+
+	// An unterminated line is the strongest sign of a prompt. stdout and
+	// stderr are read separately, so the prompt need not be the output
+	// that arrived last.
+	source := j.lastSource
+	text := strings.TrimSpace(ansiEscape.ReplaceAllString(j.partial[source], ""))
+	for _, src := range []string{"stderr", InputTerminal, "stdout"} {
+		if text != "" {
+			break
+		}
+		source = src
+		text = strings.TrimSpace(ansiEscape.ReplaceAllString(j.partial[src], ""))
+	}
+	if text == "" {
+		source = j.lastSource
+		// A complete line only counts if it reads like a question
+		last := ""
+		if j.lastSource == "stderr" {
+			lines := strings.Split(strings.TrimRight(j.stderrBuffer.String(), "\n"), "\n")
+			last = lines[len(lines)-1]
+		} else if len(j.outputLines) > 0 {
+			last = j.outputLines[len(j.outputLines)-1]
+		}
+		if !promptLine.MatchString(strings.TrimSpace(last)) {
+			return nil
+		}
+		text = strings.TrimSpace(last)
+	}
+
+	j.promptArmed = false
+	j.promptSeq++
+	j.prompt = &JobPrompt{
+		Text:      text,
+		Source:    source,
+		Since:     now,
+		Sensitive: sensitivePrompt.MatchString(text),
+		Seq:       j.promptSeq,
+	}
+	j.notifyLocked()
+	p := *j.prompt
+	return &p
+}
+
+// watchPrompts looks for prompts while an interactive job's attempt runs,
+// and closes its input once a prompt has waited the input timeout
+func (jm *JobQueue) watchPrompts(job *Job, attemptDone <-chan struct{}) {
+	ticker := time.NewTicker(promptInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-attemptDone:
+			return
+		case now := <-ticker.C:
+			job.mu.Lock()
+			prompt := job.detectPromptLocked(now)
+			timeout := config().Timeouts.Input
+			expired := job.prompt != nil && timeout > 0 && now.Sub(job.prompt.Since) >= timeout
+			var target string
+			if expired {
+				target = job.prompt.inputTarget()
+				job.appendOutputLocked(fmt.Sprintf("No input for %s, closing %s", timeout, target))
+				job.prompt = nil
+			}
+			job.mu.Unlock()
+
+			if prompt != nil {
+				jm.mu.RLock()
+				hooks := jm.promptHooks
+				jm.mu.RUnlock()
+				for _, fn := range hooks {
+					fn(job.ID, job.Spec, *prompt)
+				}
+			}
+			if expired {
+				job.closeInput(target)
+			}
+		}
+	}
+}
+
+// OnJobPrompt registers fn to be called when a job starts waiting for input
+func (jm *JobQueue) OnJobPrompt(fn func(jobID string, spec JobSpec, prompt JobPrompt)) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	jm.promptHooks = append(jm.promptHooks, fn)
+}
+
+// JobInput is text to relay into a running job
+type JobInput struct {
+	Text string
+	// Target is InputStdin, InputTerminal or "" to answer the current
+	// prompt where it was asked
+	Target string
+	// NoNewline sends Text as is instead of as a line
+	NoNewline bool
+	// Secret keeps Text out of the job output
+	Secret bool
+	// EOF closes the input after Text is sent
+	EOF bool
+	// Seq, when non-zero, only answers that prompt; if the job has moved
+	// on, nothing is sent
+	Seq int
+}
+
+// SendInput writes input into a running interactive job
+func (jm *JobQueue) SendInput(jobID string, in JobInput) error {
+	job := jm.GetJob(jobID)
+	if job == nil {
+		return fmt.Errorf("job not found: %s", jobID)
+	}
+
+	job.mu.Lock()
+	if !job.Spec.Interactive {
+		job.mu.Unlock()
+		return errors.New("job was not started with interactive: true, so it has no input")
+	}
+	if job.Status != JobStatusRunning {
+		job.mu.Unlock()
+		return fmt.Errorf("job is %s, not running", job.Status)
+	}
+	if in.Seq != 0 && (job.prompt == nil || job.prompt.Seq != in.Seq) {
+		job.mu.Unlock()
+		return errors.New("the prompt was already answered")
+	}
+
+	target := in.Target
+	if target == "" {
+		target = job.prompt.inputTarget()
+	}
+	var w io.Writer
+	switch target {
+	case InputStdin:
+		if job.stdin != nil {
+			w = job.stdin
+		}
+	case InputTerminal:
+		if job.tty != nil {
+			w = job.tty
+		}
+	default:
+		job.mu.Unlock()
+		return fmt.Errorf("invalid target %q: use %s or %s", target, InputStdin, InputTerminal)
+	}
+	if w == nil {
+		job.mu.Unlock()
+		return fmt.Errorf("job has no %s to write to", target)
+	}
+
+	secret := in.Secret || (job.prompt != nil && job.prompt.Sensitive)
+	// The terminal echoes input itself unless the script turned echo off
+	if target == InputStdin {
+		shown := in.Text
+		if secret {
+			shown = "(hidden)"
+		}
+		job.appendOutputLocked("> " + shown)
+	}
+	job.prompt = nil
+	job.promptArmed = false
+	job.lastOutput = time.Now()
+	job.notifyLocked()
+	job.mu.Unlock()
+
+	data := in.Text
+	if !in.NoNewline {
+		data += "\n"
+	}
+	if data != "" {
+		if _, err := io.WriteString(w, data); err != nil {
+			return fmt.Errorf("writing to %s: %v", target, err)
+		}
+	}
+	if in.EOF {
+		return job.closeInput(target)
+	}
+	return nil
+}
+
+// closeInput ends a job's input: stdin is closed, the terminal gets an
+// end-of-file character
+func (j *Job) closeInput(target string) error {
+	j.mu.Lock()
+	stdin, tty := j.stdin, j.tty
+	j.mu.Unlock()
+
+	if target == InputTerminal {
+		if tty == nil {
+			return nil
+		}
+		_, err := tty.Write([]byte{4})
+		return err
+	}
+	if stdin == nil {
+		return nil
+	}
+	return stdin.Close()
+}
+
+// CloseInputs ends the input of every running interactive job, so
+// scripts waiting on a prompt take their default or give up
+func (jm *JobQueue) CloseInputs() {
+	jm.mu.RLock()
+	var jobs []*Job
+	for _, job := range jm.running {
+		if job.Spec.Interactive {
+			jobs = append(jobs, job)
+		}
+	}
+	jm.mu.RUnlock()
+
+	for _, job := range jobs {
+		job.closeInput(InputStdin)
+		job.closeInput(InputTerminal)
+	}
+}
@@ -1,10 +1,13 @@
-package main
+package mcpserver
 
 import (
-	"bufio"
 	"bytes"
+	"fmt"
 	"io"
-	"os/exec"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,119 +17,921 @@ import (
 const (
 	MaxOutputLines    = 50
 	JobCleanupTimeout = 10 * time.Minute
+	SyncLockTimeout   = 2 * time.Minute
+
+	// wait_job blocks for at most MaxWaitJob per call
+	DefaultWaitJob = 60 * time.Second
+	MaxWaitJob     = 120 * time.Second
+
+	// CancelSettleTime is how long cancel_job waits for a killed script
+	// to exit before returning the job's status
+	CancelSettleTime = 5 * time.Second
 )
 
 type JobStatus string
 
 const (
+	JobStatusQueued    JobStatus = "queued"
 	JobStatusRunning   JobStatus = "running"
 	JobStatusCompleted JobStatus = "completed"
 	JobStatusFailed    JobStatus = "failed"
 )
 
+// JobSpec describes the command a job runs and the resources it needs
+type JobSpec struct {
+	Tool     string
+	Name     string
+	Args     []string
+	Locks    []string
+	Priority JobPriority
+
+	// ParentID links a pipeline step's job to its pipeline job
+	ParentID string
+	// Source says what started the job when it was not a direct tool call
+	Source string
+	// Caller is the Caller.id of the tool call that started the job
+	Caller string
+	// Retry reruns the job after transient failures; nil means never
+	Retry *RetryPolicy
+	// PTY gives the command a pseudo-terminal so /dev/tty output is logged
+	PTY bool
+	// Trace is the span the job's span is a child of
+	Trace SpanContext
+	// Notify lists where to report the job when it finishes
+	Notify []NotifyTarget
+	// Watch lists files and directories whose changes are recorded as
+	// the job's artifacts
+	Watch []string
+	// Interactive keeps the command's stdin open for send_job_input
+	Interactive bool
+	// Host is the inventory host the command runs on; empty is local
+	Host string
+	// Timeout stops each attempt that runs longer; 0 means no limit
+	Timeout time.Duration
+}
+
 type Job struct {
 	ID        string
-	Cmd       *exec.Cmd
+	Spec      JobSpec
+	Proc      *Process
 	Status    JobStatus
 	ExitCode  int
+	QueueTime time.Time
 	StartTime time.Time
 	EndTime   time.Time
 
-	mu           sync.Mutex
-	outputLines  []string
-	stderrBuffer bytes.Buffer
+	// Set while queued: what the job waits for and, for locks, who holds it
+	WaitingFor string
+	BlockedBy  string
+
+	// Attempts records every finished run of the command
+	Attempts []JobAttempt
+
+	// Artifacts lists the watched files the job changed, set when it ends
+	Artifacts []FileChange
+
+	mu       sync.Mutex
+	done     chan struct{}
+	changed  chan struct{}
+	spawnErr error
+	pipeline *pipelineRun
+	// cancelled is set by CancelJob; the job is neither retried nor
+	// requeued
+	cancelled   bool
+	outputLines []string
+	// maxOutputLines is config().MaxOutputLines when the job was created,
+	// so a reload does not trim output the job already kept
+	maxOutputLines int
+	stderrBuffer   bytes.Buffer
+
+	// ttyDone is closed once the current run's terminal output is read;
+	// ttyLast says whether the last output line came from the terminal
+	tty     io.ReadWriteCloser
+	ttyDone chan struct{}
+	ttyLast bool
+
+	// snapshot holds the watched paths as they were before the first attempt
+	snapshot *fileSnapshot
+
+	// Interactive jobs: the write end of stdin, and the state prompt
+	// detection works from
+	stdin       io.WriteCloser
+	lastOutput  time.Time
+	lastSource  string
+	partial     map[string]string
+	prompt      *JobPrompt
+	promptArmed bool
+	promptSeq   int
+	attemptDone chan struct{}
+
+	// outputDone receives once when stdout and once when stderr is read
+	outputDone chan struct{}
+	// timeout stops the current attempt once Spec.Timeout has passed
+	timeout *time.Timer
+
+	// span covers the job from queueing to its end, attemptSpan the
+	// current run of its command
+	span        *Span
+	attemptSpan *Span
 }
 
-type JobManager struct {
-	mu   sync.RWMutex
-	jobs map[string]*Job
+// JobInfo is a point-in-time copy of a job's state
+type JobInfo struct {
+	ID   string
+	Tool string
+	Host string
+	PID  int
+	// OutputFiles hold the running script's stdout and stderr
+	OutputFiles []string
+	Status      JobStatus
+	ExitCode    int
+	// Cancelled is set when CancelJob stopped the job
+	Cancelled  bool
+	Output     string
+	Stderr     string
+	Locks      []string
+	WaitingFor string
+	BlockedBy  string
+
+	Priority       JobPriority
+	QueuePosition  int
+	EstimatedStart time.Time
+
+	ParentID string
+	Source   string
+	Caller   string
+	Steps    []StepInfo
+
+	// Command is the script and arguments the job runs
+	Command []string
+
+	Attempts    []JobAttempt
+	MaxAttempts int
+
+	Artifacts []FileChange
+
+	Interactive bool
+	Prompt      *JobPrompt
+
+	QueueTime time.Time
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// JobManager runs jobs and reports on them. JobQueue is the built-in
+// one; an embedding program can supply its own through Options.Jobs.
+type JobManager interface {
+	// StartJob queues a job and returns its ID
+	StartJob(spec JobSpec) (string, error)
+	GetJobStatus(jobID string) (JobInfo, bool)
+	// ListJobs returns the jobs not yet cleaned up, newest first
+	ListJobs() []JobInfo
+	// WaitJob blocks until the job finishes, its output matches pattern,
+	// it prompts for input, or timeout elapses
+	WaitJob(jobID string, pattern *regexp.Regexp, timeout time.Duration) (JobInfo, string, bool)
+	// Done is closed when the job finishes; nil for an unknown job
+	Done(jobID string) <-chan struct{}
+	SendInput(jobID string, in JobInput) error
+	// CancelJob stops a queued or running job, which then fails
+	CancelJob(jobID string) error
+	// AppendOutput adds a note to the job's output
+	AppendOutput(jobID, line string)
+	CloseInputs()
+
+	// AcquireLocks takes locks for work done outside a job, such as a
+	// sync tool; the returned func releases them
+	AcquireLocks(owner string, keys []string, timeout time.Duration) (func(), error)
+
+	OnJobFinished(fn func(spec JobSpec, info JobInfo))
+	OnJobPrompt(fn func(jobID string, spec JobSpec, prompt JobPrompt))
+
+	ArtifactJobs() []JobInfo
+	Stats() (queued int, running map[string]int)
+	SetLimits(workers int, toolLimits map[string]int)
+	// Drain stops queued jobs from starting and waits up to timeout for
+	// running ones, returning the IDs of those left unfinished
+	Drain(timeout time.Duration) []string
+}
+
+// JobQueue is the built-in JobManager: a priority queue feeding a worker
+// pool, with per-tool limits and named locks
+type JobQueue struct {
+	mu    sync.RWMutex
+	jobs  map[string]*Job
+	queue []*Job
+	locks *lockTable
+
+	// Worker pool: at most workers jobs run at once, and at most
+	// toolLimits[tool] of a single tool
+	workers     int
+	toolLimits  map[string]int
+	running     map[string]*Job
+	toolRunning map[string]int
+	avgDuration map[string]time.Duration
+
+	// locksChanged is closed and replaced whenever locks are released or
+	// the queue is dispatched
+	locksChanged chan struct{}
+	// syncWaiters are the sync tool calls waiting in AcquireLocks, by owner
+	syncWaiters map[string]syncWaiter
+
+	finishHooks []func(spec JobSpec, info JobInfo)
+	promptHooks []func(jobID string, spec JobSpec, prompt JobPrompt)
+
+	// draining stops queued jobs from starting during shutdown
+	draining bool
 }
 
-func NewJobManager() *JobManager {
-	jm := &JobManager{
-		jobs: make(map[string]*Job),
+// NewJobQueue creates a job manager running at most workers jobs at once.
+// toolLimits caps concurrent jobs per tool name.
+func NewJobQueue(workers int, toolLimits map[string]int) *JobQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	jm := &JobQueue{
+		jobs:         make(map[string]*Job),
+		locks:        newLockTable(),
+		workers:      workers,
+		toolLimits:   toolLimits,
+		running:      make(map[string]*Job),
+		toolRunning:  make(map[string]int),
+		avgDuration:  make(map[string]time.Duration),
+		locksChanged: make(chan struct{}),
+		syncWaiters:  make(map[string]syncWaiter),
 	}
 	// Start cleanup goroutine
 	go jm.cleanupLoop()
 	return jm
 }
 
-// StartJob spawns a command asynchronously and returns a job ID
-func (jm *JobManager) StartJob(name string, args ...string) (string, error) {
-	jobID := uuid.New().String()
+// StartJob queues a command and starts it as soon as a worker and its locks
+// are free. It returns the job ID; the job may still be queued when it returns.
+func (jm *JobQueue) StartJob(spec JobSpec) (string, error) {
+	spec.Locks = dedupeKeys(spec.Locks)
+
+	maxOutput := config().MaxOutputLines
+	job := &Job{
+		ID:             uuid.New().String(),
+		Spec:           spec,
+		Status:         JobStatusQueued,
+		QueueTime:      time.Now(),
+		done:           make(chan struct{}),
+		changed:        make(chan struct{}),
+		outputLines:    make([]string, 0, maxOutput),
+		maxOutputLines: maxOutput,
+	}
+	job.span = StartSpan("job "+spec.Tool, spec.Trace, job.QueueTime)
+	job.span.SetAttr("job.id", job.ID)
+	job.span.SetAttr("mcp.tool", spec.Tool)
+	job.span.SetAttr("job.priority", spec.Priority.String())
+	if spec.Source != "" {
+		job.span.SetAttr("job.source", spec.Source)
+	}
 
-	cmd := exec.Command(name, args...)
+	jm.mu.Lock()
+	jm.jobs[job.ID] = job
+	jm.queue = append(jm.queue, job)
+	jm.dispatchLocked()
+	jm.mu.Unlock()
 
-	// Create pipes for stdout and stderr
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", err
+	// A job that could start right away but failed to spawn is reported
+	// as an error, like before queueing existed
+	job.mu.Lock()
+	spawnErr := job.spawnErr
+	job.mu.Unlock()
+
+	if spawnErr != nil {
+		jm.mu.Lock()
+		delete(jm.jobs, job.ID)
+		jm.mu.Unlock()
+		return "", spawnErr
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return "", err
+
+	go jm.watch(job)
+	return job.ID, nil
+}
+
+// dispatchLocked starts every queued job that has a free worker, is under
+// its tool's concurrency limit and whose locks are free. Jobs are considered
+// by priority, then submission order, and a job never overtakes an earlier
+// queued job, or a sync tool call waiting since before it was queued, that
+// wants one of the same keys. Callers hold jm.mu.
+func (jm *JobQueue) dispatchLocked() {
+	close(jm.locksChanged)
+	jm.locksChanged = make(chan struct{})
+	if jm.draining {
+		return
 	}
+	sortQueue(jm.queue)
 
-	job := &Job{
-		ID:          jobID,
-		Cmd:         cmd,
-		Status:      JobStatusRunning,
-		StartTime:   time.Now(),
-		outputLines: make([]string, 0, MaxOutputLines),
+	claimed := make(map[string]string)
+	remaining := jm.queue[:0]
+
+	for _, job := range jm.queue {
+		var waitingFor, blockedBy string
+		if key, holder, ok := jm.locks.conflict(job.ID, job.Spec.Locks); ok {
+			waitingFor, blockedBy = "lock "+key, holder
+		} else if key, owner, ok := jm.syncWaiterBefore(job.QueueTime, job.Spec.Locks); ok {
+			waitingFor, blockedBy = "lock "+key, owner
+		} else {
+			for _, k := range job.Spec.Locks {
+				if owner, ok := claimed[k]; ok {
+					waitingFor, blockedBy = "lock "+k, owner
+					break
+				}
+			}
+		}
+		if waitingFor == "" {
+			if limit, ok := jm.toolLimits[job.Spec.Tool]; ok && jm.toolRunning[job.Spec.Tool] >= limit {
+				waitingFor = fmt.Sprintf("%s concurrency limit (%d)", job.Spec.Tool, limit)
+			} else if len(jm.running) >= jm.workers {
+				waitingFor = fmt.Sprintf("free worker (%d/%d busy)", len(jm.running), jm.workers)
+			}
+		}
+
+		if waitingFor != "" {
+			job.mu.Lock()
+			job.WaitingFor = waitingFor
+			job.BlockedBy = blockedBy
+			job.mu.Unlock()
+			for _, k := range job.Spec.Locks {
+				if _, ok := claimed[k]; !ok {
+					claimed[k] = job.ID
+				}
+			}
+			remaining = append(remaining, job)
+			continue
+		}
+
+		jm.locks.acquire(job.ID, job.Spec.Locks)
+		if err := jm.launch(job); err != nil {
+			jm.locks.release(job.ID, job.Spec.Locks)
+			continue
+		}
+		jm.running[job.ID] = job
+		jm.toolRunning[job.Spec.Tool]++
 	}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return "", err
+	jm.queue = remaining
+}
+
+// launch spawns the job's command. Callers hold jm.mu.
+func (jm *JobQueue) launch(job *Job) error {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	job.WaitingFor = ""
+	job.BlockedBy = ""
+
+	attemptSpan := StartSpan("exec "+job.Spec.Name, job.span.Context(), time.Now())
+	attemptSpan.SetAttr("job.attempt", len(job.Attempts)+1)
+	if job.Spec.Host != "" {
+		attemptSpan.SetAttr("host.name", job.Spec.Host)
 	}
+	job.attemptSpan = attemptSpan
 
-	// Store job
-	jm.mu.Lock()
-	jm.jobs[jobID] = job
-	jm.mu.Unlock()
+	// The job holds its locks from here until wait, so nothing else that
+	// respects them changes the watched paths in between. Retries compare
+	// against the state before the first attempt.
+	if len(job.Attempts) == 0 && len(job.Spec.Watch) > 0 {
+		job.snapshot = takeSnapshot(job.Spec.Watch)
+	}
+
+	ex, err := executorFor(job.Spec.Host)
+	if err != nil {
+		return job.failToStart(err)
+	}
+	proc, err := ex.Start(execRequest(job.Spec, attemptSpan.Context()))
+	if err != nil {
+		return job.failToStart(err)
+	}
+	attemptSpan.SetAttr("process.command_args", strings.Join(proc.Argv, " "))
+	if proc.PTYErr != nil {
+		job.appendOutputLocked(fmt.Sprintf("No pseudo-terminal, /dev/tty output will not be captured: %v", proc.PTYErr))
+	}
+
+	job.Proc = proc
+	job.Status = JobStatusRunning
+	job.StartTime = time.Now()
+	if limit := job.Spec.Timeout; limit > 0 {
+		job.timeout = time.AfterFunc(limit, func() {
+			job.appendOutput(fmt.Sprintf("Timed out after %s, stopping %s", limit, job.Spec.Name))
+			proc.Kill()
+		})
+	}
+	if n := len(job.Attempts) + 1; n > 1 {
+		job.appendOutputLocked(fmt.Sprintf("--- Attempt %d ---", n))
+	}
+	job.notifyLocked()
 
 	// Read stdout in background
-	go job.readOutput(stdout)
+	job.outputDone = make(chan struct{}, 2)
+	go func() {
+		job.readOutput(proc.Stdout)
+		job.outputDone <- struct{}{}
+	}()
+
+	job.stdin = proc.Stdin
+	job.prompt, job.promptArmed, job.partial = nil, false, nil
+	if proc.Stdin != nil {
+		job.attemptDone = make(chan struct{})
+		go jm.watchPrompts(job, job.attemptDone)
+	}
+
+	// Read terminal output in background
+	job.tty, job.ttyDone = proc.TTY, nil
+	if proc.TTY != nil {
+		job.ttyDone = make(chan struct{})
+		go job.readTTY(proc.TTY, job.ttyDone)
+	}
 
 	// Read stderr in background
 	go func() {
-		io.Copy(&job.stderrBuffer, stderr)
+		io.Copy(jobStderr{job}, proc.Stderr)
+		job.outputDone <- struct{}{}
 	}()
 
 	// Wait for completion in background
-	go func() {
-		err := cmd.Wait()
-		job.mu.Lock()
-		job.EndTime = time.Now()
-		if err != nil {
-			job.Status = JobStatusFailed
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				job.ExitCode = exitErr.ExitCode()
-			} else {
-				job.ExitCode = -1
+	go jm.wait(job)
+
+	return nil
+}
+
+// failToStart marks a job that could not be spawned. Callers hold j.mu.
+func (j *Job) failToStart(err error) error {
+	metrics.SpawnError(j.Spec.Tool)
+	j.spawnErr = err
+	j.Status = JobStatusFailed
+	j.ExitCode = -1
+	j.StartTime = time.Now()
+	j.EndTime = j.StartTime
+	j.stderrBuffer.WriteString(fmt.Sprintf("Failed to start %s: %v", j.Spec.Name, err))
+	j.attemptSpan.SetError(err.Error())
+	j.attemptSpan.EndAt(j.EndTime)
+	j.span.SetError(fmt.Sprintf("failed to start: %v", err))
+	j.span.EndAt(j.EndTime)
+	close(j.done)
+	j.notifyLocked()
+	return err
+}
+
+// StartParentJob registers a job that runs no command of its own, such as
+// a pipeline. It does not take a worker; its children queue normally.
+func (jm *JobQueue) StartParentJob(spec JobSpec) *Job {
+	now := time.Now()
+	maxOutput := config().MaxOutputLines
+	job := &Job{
+		ID:             uuid.New().String(),
+		Spec:           spec,
+		Status:         JobStatusRunning,
+		QueueTime:      now,
+		StartTime:      now,
+		done:           make(chan struct{}),
+		changed:        make(chan struct{}),
+		outputLines:    make([]string, 0, maxOutput),
+		maxOutputLines: maxOutput,
+	}
+	job.span = StartSpan("job "+spec.Tool, spec.Trace, now)
+	job.span.SetAttr("job.id", job.ID)
+	job.span.SetAttr("mcp.tool", spec.Tool)
+
+	jm.mu.Lock()
+	jm.jobs[job.ID] = job
+	jm.mu.Unlock()
+
+	go jm.watch(job)
+	return job
+}
+
+// FinishParentJob records the outcome of a job started with StartParentJob
+func (jm *JobQueue) FinishParentJob(job *Job, success bool) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	job.EndTime = time.Now()
+	if success {
+		job.Status = JobStatusCompleted
+		job.ExitCode = 0
+	} else {
+		job.Status = JobStatusFailed
+		job.ExitCode = 1
+		job.span.SetError("pipeline failed")
+	}
+	job.span.EndAt(job.EndTime)
+	close(job.done)
+	job.notifyLocked()
+}
+
+// OnJobFinished registers fn to be called, in its own goroutine, with the
+// final state of every job that finishes from now on
+func (jm *JobQueue) OnJobFinished(fn func(spec JobSpec, info JobInfo)) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	jm.finishHooks = append(jm.finishHooks, fn)
+}
+
+// watch runs the finish hooks once the job is done
+func (jm *JobQueue) watch(job *Job) {
+	<-job.Done()
+
+	jm.mu.RLock()
+	hooks := jm.finishHooks
+	jm.mu.RUnlock()
+	if len(hooks) == 0 {
+		return
+	}
+
+	info, ok := jm.GetJobStatus(job.ID)
+	if !ok {
+		return
+	}
+	for _, fn := range hooks {
+		go fn(job.Spec, info)
+	}
+}
+
+// Done returns a channel that is closed when the job finishes
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// notifyLocked wakes everyone waiting for the job to change. Callers hold j.mu.
+func (j *Job) notifyLocked() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// Changed returns a channel that is closed the next time the job's status
+// or output changes
+func (j *Job) Changed() <-chan struct{} {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.changed
+}
+
+// wait records the job's exit status and hands its worker and locks to
+// queued jobs
+func (jm *JobQueue) wait(job *Job) {
+	exitCode, err := job.Proc.Wait()
+	job.mu.Lock()
+	if job.timeout != nil {
+		job.timeout.Stop()
+		job.timeout = nil
+	}
+	job.mu.Unlock()
+
+	// The streams are closed now; let the readers log the last lines
+	<-job.outputDone
+	<-job.outputDone
+
+	// Let the terminal output drain before the job is reported finished.
+	// A background process that inherited the terminal can keep it open,
+	// so give up after a moment and close it.
+	job.mu.Lock()
+	tty, ttyDone := job.tty, job.ttyDone
+	job.mu.Unlock()
+	if ttyDone != nil {
+		select {
+		case <-ttyDone:
+		case <-time.After(2 * time.Second):
+			tty.Close()
+			<-ttyDone
+		}
+	}
+
+	// Compare the watched paths before the locks are released
+	job.mu.Lock()
+	before := job.snapshot
+	job.mu.Unlock()
+	var after *fileSnapshot
+	var changes []FileChange
+	if before != nil {
+		after = takeSnapshot(job.Spec.Watch)
+		changes = diffSnapshots(before, after)
+	}
+
+	job.mu.Lock()
+	job.EndTime = time.Now()
+	job.ExitCode = exitCode
+	if err != nil || exitCode != 0 {
+		job.Status = JobStatusFailed
+	} else {
+		job.Status = JobStatusCompleted
+	}
+	if err != nil {
+		job.appendOutputLocked(fmt.Sprintf("Lost track of the process: %v", err))
+	}
+	duration := job.EndTime.Sub(job.StartTime)
+	status := job.Status
+
+	attempt := JobAttempt{
+		Number:     len(job.Attempts) + 1,
+		StartTime:  job.StartTime,
+		EndTime:    job.EndTime,
+		ExitCode:   job.ExitCode,
+		StderrTail: tail(job.stderrBuffer.String(), 500),
+	}
+	var backoff time.Duration
+	if job.Status == JobStatusFailed && job.ExitCode > 0 && !job.cancelled {
+		attempt.Retry, attempt.Reason = job.Spec.Retry.shouldRetry(attempt.Number, job.ExitCode, job.stderrBuffer.String())
+	}
+	job.Attempts = append(job.Attempts, attempt)
+
+	job.attemptSpan.SetAttr("process.exit_code", job.ExitCode)
+	if job.ExitCode != 0 {
+		job.attemptSpan.SetError(fmt.Sprintf("exit code %d", job.ExitCode))
+	}
+	if attempt.Retry {
+		job.attemptSpan.SetAttr("job.retry_reason", attempt.Reason)
+	}
+	job.attemptSpan.EndAt(job.EndTime)
+
+	if job.attemptDone != nil {
+		close(job.attemptDone)
+		job.attemptDone = nil
+	}
+	job.stdin, job.prompt = nil, nil
+
+	if attempt.Retry {
+		// Back to the queue after the backoff; the next attempt starts
+		// with a clean stderr, the old one is kept in Attempts
+		backoff = job.Spec.Retry.backoff(attempt.Number + 1)
+		job.Status = JobStatusQueued
+		job.WaitingFor = fmt.Sprintf("retry backoff (attempt %d of %d at %s, %s)", attempt.Number+1, job.Spec.Retry.MaxAttempts, time.Now().Add(backoff).Format(time.RFC3339), attempt.Reason)
+		job.BlockedBy = ""
+		job.stderrBuffer.Reset()
+	} else {
+		if before != nil {
+			job.Artifacts = changes
+			for _, note := range snapshotNotes(before, after) {
+				job.appendOutputLocked("Artifacts: " + note)
 			}
-		} else {
-			job.Status = JobStatusCompleted
-			job.ExitCode = 0
+			job.snapshot = nil
+			job.span.SetAttr("job.files_changed", len(changes))
 		}
+		job.span.SetAttr("job.attempts", len(job.Attempts))
+		job.span.SetAttr("process.exit_code", job.ExitCode)
+		if job.Status == JobStatusFailed {
+			job.span.SetError(fmt.Sprintf("exit code %d", job.ExitCode))
+		}
+		job.span.EndAt(job.EndTime)
+		close(job.done)
+	}
+	job.notifyLocked()
+	job.mu.Unlock()
+
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	delete(jm.running, job.ID)
+	jm.toolRunning[job.Spec.Tool]--
+	jm.recordDuration(job.Spec.Tool, duration)
+	metrics.JobFinished(job.Spec.Tool, status, duration)
+	jm.releaseLocked(job.ID, job.Spec.Locks)
+
+	if attempt.Retry {
+		time.AfterFunc(backoff, func() { jm.requeue(job) })
+	}
+}
+
+// requeue puts a job waiting for its next attempt back into the queue.
+// It keeps its original queue time so it does not lose its place.
+func (jm *JobQueue) requeue(job *Job) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	// CancelJob has already finished a job cancelled during its backoff
+	job.mu.Lock()
+	cancelled := job.cancelled
+	job.mu.Unlock()
+	if cancelled {
+		return
+	}
+
+	jm.queue = append(jm.queue, job)
+	jm.dispatchLocked()
+}
+
+// CancelJob stops a job. A queued job, or one waiting to be retried, is
+// taken out of the queue and never starts. A running job's command is
+// killed and not retried. A pipeline skips the steps not started yet and
+// cancels the running ones. The job ends as failed.
+func (jm *JobQueue) CancelJob(jobID string) error {
+	job := jm.GetJob(jobID)
+	if job == nil {
+		return fmt.Errorf("job not found: %s", jobID)
+	}
+
+	// Hold jm.mu so the job cannot be dispatched while it is looked at
+	jm.mu.Lock()
+	job.mu.Lock()
+	select {
+	case <-job.done:
 		job.mu.Unlock()
-	}()
+		jm.mu.Unlock()
+		return fmt.Errorf("job %s has already finished", jobID)
+	default:
+	}
+	if job.cancelled {
+		job.mu.Unlock()
+		jm.mu.Unlock()
+		return fmt.Errorf("job %s is already being cancelled", jobID)
+	}
+	if job.Status == JobStatusRunning && job.pipeline == nil && job.Proc == nil {
+		job.mu.Unlock()
+		jm.mu.Unlock()
+		return fmt.Errorf("job %s runs no command that can be stopped", jobID)
+	}
+	job.cancelled = true
+
+	if job.pipeline == nil && job.Status == JobStatusQueued {
+		for i, queued := range jm.queue {
+			if queued == job {
+				jm.queue = append(jm.queue[:i], jm.queue[i+1:]...)
+				break
+			}
+		}
+		job.Status = JobStatusFailed
+		job.ExitCode = -1
+		job.EndTime = time.Now()
+		job.WaitingFor, job.BlockedBy = "", ""
+		job.appendOutputLocked("Cancelled before it started")
+		job.span.SetError("cancelled")
+		job.span.EndAt(job.EndTime)
+		close(job.done)
+		job.notifyLocked()
+		job.mu.Unlock()
+
+		// Jobs queued behind it for the same locks may start now
+		jm.dispatchLocked()
+		jm.mu.Unlock()
+		return nil
+	}
+
+	pipeline, proc := job.pipeline, job.Proc
+	job.mu.Unlock()
+	jm.mu.Unlock()
+
+	if pipeline != nil {
+		pipeline.cancel(jm)
+		return nil
+	}
+	job.appendOutput(fmt.Sprintf("Cancelled, stopping %s", job.Spec.Name))
+	if err := proc.Kill(); err != nil {
+		job.mu.Lock()
+		job.cancelled = false
+		job.mu.Unlock()
+		return fmt.Errorf("cannot stop job %s: %v", jobID, err)
+	}
+	return nil
+}
+
+// syncWaiter is a sync tool call waiting for its locks
+type syncWaiter struct {
+	keys  []string
+	since time.Time
+}
+
+// AcquireLocks blocks until owner holds all keys or timeout elapses.
+// It is used by sync tools so they respect the same locks as jobs. Like
+// a job, the call waits its turn: jobs queued before it for one of the
+// same keys go first, and jobs queued after it wait for it. The returned
+// func releases the locks.
+func (jm *JobQueue) AcquireLocks(owner string, keys []string, timeout time.Duration) (func(), error) {
+	keys = dedupeKeys(keys)
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	jm.mu.Lock()
+	jm.syncWaiters[owner] = syncWaiter{keys: keys, since: time.Now()}
+	jm.mu.Unlock()
 
-	return jobID, nil
+	for {
+		jm.mu.Lock()
+		key, holder, blocked := jm.locks.conflict(owner, keys)
+		if !blocked {
+			key, holder, blocked = jm.queuedBefore(jm.syncWaiters[owner].since, keys)
+		}
+		if !blocked {
+			delete(jm.syncWaiters, owner)
+			jm.locks.acquire(owner, keys)
+			jm.mu.Unlock()
+			return func() { jm.ReleaseLocks(owner, keys) }, nil
+		}
+		changed := jm.locksChanged
+		jm.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			jm.mu.Lock()
+			delete(jm.syncWaiters, owner)
+			// Jobs queued behind the call may start now
+			jm.dispatchLocked()
+			jm.mu.Unlock()
+			return nil, fmt.Errorf("timed out after %s waiting for lock %s (held by %s)", timeout, key, holder)
+		}
+	}
+}
+
+// queuedBefore returns the first key in keys that a job queued before
+// since also wants. Callers hold jm.mu.
+func (jm *JobQueue) queuedBefore(since time.Time, keys []string) (key, jobID string, found bool) {
+	for _, job := range jm.queue {
+		if !job.QueueTime.Before(since) {
+			continue
+		}
+		for _, k := range job.Spec.Locks {
+			if slices.Contains(keys, k) {
+				return k, job.ID, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// syncWaiterBefore returns the first key in keys that a sync tool call
+// waiting since before queued wants. Callers hold jm.mu.
+func (jm *JobQueue) syncWaiterBefore(queued time.Time, keys []string) (key, owner string, found bool) {
+	for owner, w := range jm.syncWaiters {
+		if !w.since.Before(queued) {
+			continue
+		}
+		for _, k := range w.keys {
+			if slices.Contains(keys, k) {
+				return k, owner, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// ReleaseLocks frees keys held by owner and starts any jobs waiting on them
+func (jm *JobQueue) ReleaseLocks(owner string, keys []string) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	jm.releaseLocked(owner, keys)
+}
+
+// releaseLocked is ReleaseLocks for callers that hold jm.mu
+func (jm *JobQueue) releaseLocked(owner string, keys []string) {
+	jm.locks.release(owner, keys)
+	jm.dispatchLocked()
 }
 
 // GetJob returns a job by ID
-func (jm *JobManager) GetJob(jobID string) *Job {
+func (jm *JobQueue) GetJob(jobID string) *Job {
 	jm.mu.RLock()
 	defer jm.mu.RUnlock()
 	return jm.jobs[jobID]
 }
 
-// GetJobStatus returns the current status of a job
-func (jm *JobManager) GetJobStatus(jobID string) (status JobStatus, exitCode int, output string, stderr string, found bool) {
+// Done returns a channel closed when the job finishes, or nil for an
+// unknown job
+func (jm *JobQueue) Done(jobID string) <-chan struct{} {
 	job := jm.GetJob(jobID)
 	if job == nil {
-		return "", 0, "", "", false
+		return nil
+	}
+	return job.Done()
+}
+
+// AppendOutput adds a line to a job's output
+func (jm *JobQueue) AppendOutput(jobID, line string) {
+	if job := jm.GetJob(jobID); job != nil {
+		job.appendOutput(line)
+	}
+}
+
+// GetJobStatus returns the current status of a job
+func (jm *JobQueue) GetJobStatus(jobID string) (JobInfo, bool) {
+	jm.mu.RLock()
+	job := jm.jobs[jobID]
+	var position int
+	var estimatedStart time.Time
+	if job != nil {
+		position, estimatedStart = jm.queueEstimate(job)
+	}
+	jm.mu.RUnlock()
+
+	if job == nil {
+		return JobInfo{}, false
+	}
+
+	job.mu.Lock()
+	pipeline := job.pipeline
+	job.mu.Unlock()
+
+	var steps []StepInfo
+	if pipeline != nil {
+		steps = pipeline.snapshot()
+	}
+
+	maxAttempts := 1
+	if job.Spec.Retry != nil {
+		maxAttempts = job.Spec.Retry.MaxAttempts
 	}
 
 	job.mu.Lock()
@@ -139,35 +944,182 @@ func (jm *JobManager) GetJobStatus(jobID string) (status JobStatus, exitCode int
 		outputBuf.WriteString("\n")
 	}
 
-	return job.Status, job.ExitCode, outputBuf.String(), job.stderrBuffer.String(), true
+	var prompt *JobPrompt
+	if job.prompt != nil {
+		p := *job.prompt
+		prompt = &p
+	}
+
+	var command []string
+	if job.Spec.Name != "" {
+		command = append([]string{job.Spec.Name}, job.Spec.Args...)
+	}
+
+	pid := 0
+	var outputFiles []string
+	if job.Status == JobStatusRunning && job.Proc != nil {
+		pid = job.Proc.Pid
+		outputFiles = job.Proc.OutputFiles
+	}
+
+	return JobInfo{
+		ID:          job.ID,
+		Tool:        job.Spec.Tool,
+		Host:        job.Spec.Host,
+		PID:         pid,
+		OutputFiles: outputFiles,
+		Status:      job.Status,
+		ExitCode:    job.ExitCode,
+		Cancelled:   job.cancelled,
+		Output:      outputBuf.String(),
+		Stderr:      job.stderrBuffer.String(),
+		Locks:       job.Spec.Locks,
+		WaitingFor:  job.WaitingFor,
+		BlockedBy:   job.BlockedBy,
+
+		Priority:       job.Spec.Priority,
+		QueuePosition:  position,
+		EstimatedStart: estimatedStart,
+
+		ParentID: job.Spec.ParentID,
+		Source:   job.Spec.Source,
+		Caller:   job.Spec.Caller,
+		Steps:    steps,
+
+		Command: command,
+
+		Attempts:    append([]JobAttempt(nil), job.Attempts...),
+		MaxAttempts: maxAttempts,
+
+		Artifacts: job.Artifacts,
+
+		Interactive: job.Spec.Interactive,
+		Prompt:      prompt,
+
+		QueueTime: job.QueueTime,
+		StartTime: job.StartTime,
+		EndTime:   job.EndTime,
+	}, true
 }
 
-// readOutput reads stdout line by line and keeps the last MaxOutputLines
-func (j *Job) readOutput(r io.Reader) {
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		line := scanner.Text()
-		j.mu.Lock()
-		j.outputLines = append(j.outputLines, line)
-		// Keep only last MaxOutputLines
-		if len(j.outputLines) > MaxOutputLines {
-			j.outputLines = j.outputLines[len(j.outputLines)-MaxOutputLines:]
+// ListJobs returns every job that has not been cleaned up yet, most
+// recently queued first
+func (jm *JobQueue) ListJobs() []JobInfo {
+	jm.mu.RLock()
+	ids := make([]string, 0, len(jm.jobs))
+	for id := range jm.jobs {
+		ids = append(ids, id)
+	}
+	jm.mu.RUnlock()
+
+	infos := make([]JobInfo, 0, len(ids))
+	for _, id := range ids {
+		if info, ok := jm.GetJobStatus(id); ok {
+			infos = append(infos, info)
 		}
-		j.mu.Unlock()
 	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].QueueTime.After(infos[j].QueueTime) })
+	return infos
+}
+
+// WaitJob blocks until the job finishes, its output or stderr matches
+// pattern (when pattern is non-nil), or timeout elapses. It returns the
+// job's state at that point and why the wait ended.
+func (jm *JobQueue) WaitJob(jobID string, pattern *regexp.Regexp, timeout time.Duration) (JobInfo, string, bool) {
+	job := jm.GetJob(jobID)
+	if job == nil {
+		return JobInfo{}, "", false
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		// Take the channel before reading state so no change is missed
+		changed := job.Changed()
+		info, ok := jm.GetJobStatus(jobID)
+		if !ok {
+			return JobInfo{}, "", false
+		}
+
+		if info.Status == JobStatusCompleted || info.Status == JobStatusFailed {
+			return info, "job finished", true
+		}
+		if pattern != nil && (pattern.MatchString(info.Output) || pattern.MatchString(info.Stderr)) {
+			return info, "output matched pattern", true
+		}
+		if info.Prompt != nil {
+			return info, "job is waiting for input", true
+		}
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return info, "max wait elapsed", true
+		}
+	}
+}
+
+// appendOutput adds a line to the job's output, keeping the last
+// configured number of lines
+func (j *Job) appendOutput(line string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.appendOutputLocked(line)
+}
+
+// appendOutputLocked is appendOutput for callers that hold j.mu
+func (j *Job) appendOutputLocked(line string) {
+	j.ttyLast = false
+	j.outputLines = append(j.outputLines, line)
+	if keep := j.maxOutputLines; len(j.outputLines) > keep {
+		j.outputLines = j.outputLines[len(j.outputLines)-keep:]
+	}
+	j.notifyLocked()
 }
 
-// cleanupLoop removes completed jobs after JobCleanupTimeout
-func (jm *JobManager) cleanupLoop() {
+// jobStderr appends a job's stderr to its buffer under the job's lock
+type jobStderr struct {
+	job *Job
+}
+
+func (w jobStderr) Write(p []byte) (int, error) {
+	w.job.mu.Lock()
+	defer w.job.mu.Unlock()
+
+	n, err := w.job.stderrBuffer.Write(p)
+	w.job.outputSeenLocked("stderr", w.job.stderrPartial())
+	w.job.notifyLocked()
+	return n, err
+}
+
+// readOutput reads stdout line by line and keeps the last lines
+func (j *Job) readOutput(r io.Reader) {
+	readLines(r, func(line string) {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.appendOutputLocked(line)
+	}, func(partial string) {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.outputSeenLocked("stdout", partial)
+	})
+}
+
+// cleanupLoop removes completed jobs once the job retention has passed
+func (jm *JobQueue) cleanupLoop() {
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
 	for range ticker.C {
+		retention := config().Retention.Jobs
 		jm.mu.Lock()
 		now := time.Now()
 		for id, job := range jm.jobs {
 			job.mu.Lock()
-			if job.Status != JobStatusRunning && now.Sub(job.EndTime) > JobCleanupTimeout {
+			finished := job.Status == JobStatusCompleted || job.Status == JobStatusFailed
+			if finished && now.Sub(job.EndTime) > retention {
 				delete(jm.jobs, id)
 			}
 			job.mu.Unlock()
@@ -175,3 +1127,75 @@ func (jm *JobManager) cleanupLoop() {
 		jm.mu.Unlock()
 	}
 }
+
+// SetLimits changes the worker pool size and per-tool limits. Running
+// jobs are not affected; queued jobs start as the new limits allow.
+func (jm *JobQueue) SetLimits(workers int, toolLimits map[string]int) {
+	if workers < 1 {
+		workers = 1
+	}
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	jm.workers = workers
+	jm.toolLimits = toolLimits
+	jm.dispatchLocked()
+}
+
+// Stats returns the queue length and the number of running jobs per tool
+func (jm *JobQueue) Stats() (queued int, running map[string]int) {
+	jm.mu.RLock()
+	defer jm.mu.RUnlock()
+
+	running = make(map[string]int, len(jm.toolRunning))
+	for tool, n := range jm.toolRunning {
+		running[tool] = n
+	}
+	return len(jm.queue), running
+}
+
+// Drain stops starting queued jobs and waits up to timeout for running
+// ones to finish. It returns the IDs of jobs that are still unfinished,
+// including queued ones and pipelines.
+func (jm *JobQueue) Drain(timeout time.Duration) []string {
+	jm.mu.Lock()
+	jm.draining = true
+	running := make([]*Job, 0, len(jm.running))
+	for _, job := range jm.running {
+		running = append(running, job)
+	}
+	jm.mu.Unlock()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+wait:
+	for _, job := range running {
+		// A job waiting to retry is no longer running and will not start
+		// again, so wait for the status to change rather than for Done
+		for {
+			job.mu.Lock()
+			status, changed := job.Status, job.changed
+			job.mu.Unlock()
+			if status != JobStatusRunning {
+				break
+			}
+			select {
+			case <-changed:
+			case <-deadline.C:
+				break wait
+			}
+		}
+	}
+
+	jm.mu.RLock()
+	defer jm.mu.RUnlock()
+
+	var left []string
+	for id, job := range jm.jobs {
+		job.mu.Lock()
+		if job.Status == JobStatusQueued || job.Status == JobStatusRunning {
+			left = append(left, id)
+		}
+		job.mu.Unlock()
+	}
+	return left
+}
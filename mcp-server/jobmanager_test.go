@@ -0,0 +1,221 @@
+package mcpserver
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// queuedJob is a job in a dispatch test, queued offset after the start
+type queuedJob struct {
+	id       string
+	tool     string
+	priority JobPriority
+	locks    []string
+	offset   time.Duration
+}
+
+func TestDispatchLocked(t *testing.T) {
+	tests := []struct {
+		name        string
+		workers     int
+		toolLimits  map[string]int
+		held        map[string][]string
+		syncWaiters map[string]time.Duration
+		jobs        []queuedJob
+		wantStarted []string
+		// wantBlocked maps a job that stays queued to what it waits for
+		// and who holds that, as "waitingFor|blockedBy"
+		wantBlocked map[string]string
+	}{
+		{
+			name:        "free locks",
+			workers:     4,
+			jobs:        []queuedJob{{id: "a", locks: []string{"fqdn:a.com"}}, {id: "b", locks: []string{"fqdn:b.com"}}},
+			wantStarted: []string{"a", "b"},
+		},
+		{
+			name:        "held lock",
+			workers:     4,
+			held:        map[string][]string{"sync-1": {"apache-config"}},
+			jobs:        []queuedJob{{id: "a", locks: []string{"apache-config"}}, {id: "b", locks: []string{"fqdn:b.com"}}},
+			wantStarted: []string{"b"},
+			wantBlocked: map[string]string{"a": "lock apache-config|sync-1"},
+		},
+		{
+			name:    "same lock in one pass",
+			workers: 4,
+			jobs: []queuedJob{
+				{id: "a", locks: []string{"fqdn:a.com", "apache-config"}},
+				{id: "b", locks: []string{"apache-config"}, offset: time.Second},
+			},
+			wantStarted: []string{"a"},
+			wantBlocked: map[string]string{"b": "lock apache-config|a"},
+		},
+		{
+			name:    "no overtaking a blocked job",
+			workers: 4,
+			held:    map[string][]string{"sync-1": {"fqdn:a.com"}},
+			jobs: []queuedJob{
+				{id: "a", locks: []string{"fqdn:a.com", "registrar:namecheap.com"}},
+				{id: "b", locks: []string{"registrar:namecheap.com"}, offset: time.Second},
+			},
+			wantBlocked: map[string]string{
+				"a": "lock fqdn:a.com|sync-1",
+				"b": "lock registrar:namecheap.com|a",
+			},
+		},
+		{
+			name:    "priority first",
+			workers: 4,
+			jobs: []queuedJob{
+				{id: "low", priority: PriorityLow, locks: []string{"apache-config"}},
+				{id: "high", priority: PriorityHigh, locks: []string{"apache-config"}, offset: time.Second},
+			},
+			wantStarted: []string{"high"},
+			wantBlocked: map[string]string{"low": "lock apache-config|high"},
+		},
+		{
+			name:        "sync call waiting first",
+			workers:     4,
+			syncWaiters: map[string]time.Duration{"sync-1": 0},
+			jobs:        []queuedJob{{id: "a", locks: []string{"apache-config"}, offset: time.Second}},
+			wantBlocked: map[string]string{"a": "lock apache-config|sync-1"},
+		},
+		{
+			name:        "sync call waiting later",
+			workers:     4,
+			syncWaiters: map[string]time.Duration{"sync-1": 2 * time.Second},
+			jobs:        []queuedJob{{id: "a", locks: []string{"apache-config"}, offset: time.Second}},
+			wantStarted: []string{"a"},
+		},
+		{
+			name:        "workers",
+			workers:     1,
+			jobs:        []queuedJob{{id: "a"}, {id: "b", offset: time.Second}},
+			wantStarted: []string{"a"},
+			wantBlocked: map[string]string{"b": "free worker (1/1 busy)|"},
+		},
+		{
+			name:       "tool limit",
+			workers:    4,
+			toolLimits: map[string]int{"a2certrenew": 1},
+			jobs: []queuedJob{
+				{id: "a", tool: "a2certrenew"},
+				{id: "b", tool: "a2certrenew", offset: time.Second},
+				{id: "c", offset: 2 * time.Second},
+			},
+			wantStarted: []string{"a", "c"},
+			wantBlocked: map[string]string{"b": "a2certrenew concurrency limit (1)|"},
+		},
+	}
+
+	useReplayer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jm := NewJobQueue(tt.workers, tt.toolLimits)
+			start := time.Now()
+
+			// Hold the queue's lock throughout, so jobs that start cannot
+			// finish and dispatch again before they are checked
+			jm.mu.Lock()
+			for owner, keys := range tt.held {
+				jm.locks.acquire(owner, keys)
+			}
+			for owner, offset := range tt.syncWaiters {
+				jm.syncWaiters[owner] = syncWaiter{keys: []string{"apache-config"}, since: start.Add(offset)}
+			}
+			jobs := make(map[string]*Job)
+			for _, q := range tt.jobs {
+				tool := q.tool
+				if tool == "" {
+					tool = "fqdncredmgr_list"
+				}
+				job := &Job{
+					ID:        q.id,
+					Spec:      JobSpec{Tool: tool, Name: "fqdncredmgr", Args: []string{"list"}, Locks: q.locks, Priority: q.priority},
+					Status:    JobStatusQueued,
+					QueueTime: start.Add(q.offset),
+					done:      make(chan struct{}),
+					changed:   make(chan struct{}),
+				}
+				jobs[q.id] = job
+				jm.jobs[q.id] = job
+				jm.queue = append(jm.queue, job)
+			}
+
+			jm.dispatchLocked()
+
+			var started []string
+			for id := range jm.running {
+				started = append(started, id)
+			}
+			blocked := make(map[string]string)
+			for _, job := range jm.queue {
+				blocked[job.ID] = job.WaitingFor + "|" + job.BlockedBy
+			}
+			jm.mu.Unlock()
+
+			slices.Sort(started)
+			if !slices.Equal(started, tt.wantStarted) {
+				t.Errorf("started %v, want %v", started, tt.wantStarted)
+			}
+			for id, want := range tt.wantBlocked {
+				if got, ok := blocked[id]; !ok || got != want {
+					t.Errorf("job %s: queued = %v, waiting for %q, want %q", id, ok, got, want)
+				}
+			}
+			if len(blocked) != len(tt.wantBlocked) {
+				t.Errorf("queued jobs %v, want %v", blocked, tt.wantBlocked)
+			}
+
+			// Let every job run, so none is left using the replayer
+			jm.mu.Lock()
+			for owner, keys := range tt.held {
+				jm.locks.release(owner, keys)
+			}
+			clear(jm.syncWaiters)
+			jm.dispatchLocked()
+			jm.mu.Unlock()
+			for _, job := range jobs {
+				<-job.done
+			}
+		})
+	}
+}
+
+func TestAcquireLocksWaitsForEarlierJob(t *testing.T) {
+	useReplayer(t)
+	jm := NewJobQueue(1, nil)
+
+	jm.mu.Lock()
+	jm.locks.acquire("sync-0", []string{"other"})
+	jm.queue = append(jm.queue, &Job{
+		ID:        "queued",
+		Spec:      JobSpec{Tool: "fqdncredmgr_list", Name: "fqdncredmgr", Args: []string{"list"}, Locks: []string{"other", "apache-config"}},
+		Status:    JobStatusQueued,
+		QueueTime: time.Now(),
+		done:      make(chan struct{}),
+		changed:   make(chan struct{}),
+	})
+	jm.mu.Unlock()
+
+	_, err := jm.AcquireLocks("sync-1", []string{"apache-config"}, 50*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "held by queued") {
+		t.Fatalf("AcquireLocks = %v, want a timeout behind the queued job", err)
+	}
+
+	jm.mu.Lock()
+	_, waiting := jm.syncWaiters["sync-1"]
+	jm.mu.Unlock()
+	if waiting {
+		t.Error("a timed out call is still registered as waiting")
+	}
+
+	release, err := jm.AcquireLocks("sync-2", []string{"fqdn:a.com"}, time.Second)
+	if err != nil {
+		t.Fatalf("AcquireLocks on a free key: %v", err)
+	}
+	release()
+}
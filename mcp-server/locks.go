@@ -0,0 +1,76 @@
+package mcpserver
+
+import (
+	"strings"
+)
+
+// Lock keys shared by tools that touch the same resources
+const (
+	// LockApacheConfig serializes everything that writes Apache configs or reloads Apache
+	LockApacheConfig = "apache-config"
+)
+
+// fqdnLock returns the lock key for a single domain
+func fqdnLock(fqdn string) string {
+	return "fqdn:" + strings.ToLower(strings.TrimSpace(fqdn))
+}
+
+// registrarLock returns the lock key for a registrar API
+func registrarLock(registrar string) string {
+	return "registrar:" + strings.ToLower(strings.TrimSpace(registrar))
+}
+
+// hostLock scopes a per-machine lock key to a remote host
+func hostLock(key, host string) string {
+	return key + "@" + host
+}
+
+// lockTable tracks which owner holds each lock key.
+// It is not safe for concurrent use; callers hold JobQueue.mu.
+type lockTable struct {
+	holders map[string]string
+}
+
+func newLockTable() *lockTable {
+	return &lockTable{holders: make(map[string]string)}
+}
+
+// conflict returns the first key in keys that is held by another owner
+func (lt *lockTable) conflict(owner string, keys []string) (key string, holder string, found bool) {
+	for _, k := range keys {
+		if h, ok := lt.holders[k]; ok && h != owner {
+			return k, h, true
+		}
+	}
+	return "", "", false
+}
+
+// acquire takes all keys for owner. Callers must check conflict first.
+func (lt *lockTable) acquire(owner string, keys []string) {
+	for _, k := range keys {
+		lt.holders[k] = owner
+	}
+}
+
+// release drops every key held by owner
+func (lt *lockTable) release(owner string, keys []string) {
+	for _, k := range keys {
+		if lt.holders[k] == owner {
+			delete(lt.holders, k)
+		}
+	}
+}
+
+// dedupeKeys removes empty and duplicate lock keys while keeping order
+func dedupeKeys(keys []string) []string {
+	seen := make(map[string]bool, len(keys))
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if k == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		out = append(out, k)
+	}
+	return out
+}
@@ -1,195 +0,0 @@
-package main
-
-import (
-	"bufio"
-	"encoding/json"
-	"fmt"
-	"os"
-)
-
-// JSON-RPC 2.0 structures
-type JSONRPCRequest struct {
-	JSONRPC string          `json:"jsonrpc"`
-	ID      interface{}     `json:"id,omitempty"`
-	Method  string          `json:"method"`
-	Params  json.RawMessage `json:"params,omitempty"`
-}
-
-type JSONRPCResponse struct {
-	JSONRPC string        `json:"jsonrpc"`
-	ID      interface{}   `json:"id,omitempty"`
-	Result  interface{}   `json:"result,omitempty"`
-	Error   *JSONRPCError `json:"error,omitempty"`
-}
-
-type JSONRPCError struct {
-	Code    int         `json:"code"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
-}
-
-// MCP Protocol structures
-type InitializeParams struct {
-	ProtocolVersion string         `json:"protocolVersion"`
-	Capabilities    map[string]any `json:"capabilities"`
-	ClientInfo      ClientInfo     `json:"clientInfo"`
-}
-
-type ClientInfo struct {
-	Name    string `json:"name"`
-	Version string `json:"version"`
-}
-
-type InitializeResult struct {
-	ProtocolVersion string           `json:"protocolVersion"`
-	Capabilities    ServerCapability `json:"capabilities"`
-	ServerInfo      ServerInfo       `json:"serverInfo"`
-}
-
-type ServerCapability struct {
-	Tools *ToolsCapability `json:"tools,omitempty"`
-}
-
-type ToolsCapability struct {
-	ListChanged bool `json:"listChanged,omitempty"`
-}
-
-type ServerInfo struct {
-	Name    string `json:"name"`
-	Version string `json:"version"`
-}
-
-type ToolsListResult struct {
-	Tools []Tool `json:"tools"`
-}
-
-type ToolCallParams struct {
-	Name      string         `json:"name"`
-	Arguments map[string]any `json:"arguments,omitempty"`
-}
-
-type ToolCallResult struct {
-	Content []ContentBlock `json:"content"`
-	IsError bool           `json:"isError,omitempty"`
-}
-
-type ContentBlock struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
-}
-
-// Global job manager
-var jobMgr *JobManager
-
-func main() {
-	// Initialize job manager
-	jobMgr = NewJobManager()
-
-	// Read from stdin, write to stdout
-	scanner := bufio.NewScanner(os.Stdin)
-	// Increase buffer size for large messages
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
-
-		var request JSONRPCRequest
-		if err := json.Unmarshal([]byte(line), &request); err != nil {
-			sendError(nil, -32700, "Parse error", err.Error())
-			continue
-		}
-
-		handleRequest(&request)
-	}
-
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
-		os.Exit(1)
-	}
-}
-
-func handleRequest(req *JSONRPCRequest) {
-	switch req.Method {
-	case "initialize":
-		handleInitialize(req)
-	case "initialized":
-		// Notification, no response needed
-	case "tools/list":
-		handleToolsList(req)
-	case "tools/call":
-		handleToolsCall(req)
-	case "ping":
-		sendResult(req.ID, map[string]any{})
-	default:
-		sendError(req.ID, -32601, "Method not found", req.Method)
-	}
-}
-
-func handleInitialize(req *JSONRPCRequest) {
-	result := InitializeResult{
-		ProtocolVersion: "2024-11-05",
-		Capabilities: ServerCapability{
-			Tools: &ToolsCapability{
-				ListChanged: false,
-			},
-		},
-		ServerInfo: ServerInfo{
-			Name:    "a2cmds-mcp",
-			Version: "1.0.0",
-		},
-	}
-	sendResult(req.ID, result)
-}
-
-func handleToolsList(req *JSONRPCRequest) {
-	result := ToolsListResult{
-		Tools: GetAllTools(),
-	}
-	sendResult(req.ID, result)
-}
-
-func handleToolsCall(req *JSONRPCRequest) {
-	var params ToolCallParams
-	if err := json.Unmarshal(req.Params, &params); err != nil {
-		sendError(req.ID, -32602, "Invalid params", err.Error())
-		return
-	}
-
-	result := ExecuteTool(params.Name, params.Arguments)
-	sendResult(req.ID, result)
-}
-
-func sendResult(id interface{}, result interface{}) {
-	response := JSONRPCResponse{
-		JSONRPC: "2.0",
-		ID:      id,
-		Result:  result,
-	}
-	writeResponse(response)
-}
-
-func sendError(id interface{}, code int, message string, data interface{}) {
-	response := JSONRPCResponse{
-		JSONRPC: "2.0",
-		ID:      id,
-		Error: &JSONRPCError{
-			Code:    code,
-			Message: message,
-			Data:    data,
-		},
-	}
-	writeResponse(response)
-}
-
-func writeResponse(response JSONRPCResponse) {
-	data, err := json.Marshal(response)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error marshaling response: %v\n", err)
-		return
-	}
-	fmt.Println(string(data))
-}
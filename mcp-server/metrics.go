@@ -0,0 +1,229 @@
+package mcpserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A2ToolsCacheFile is where fqdnmgr keeps its cache, including the average
+// DNS propagation time per nameserver ("ap <ns> <seconds> <timestamp>")
+const A2ToolsCacheFile = "/tmp/a2tools.cache"
+
+// jobDurationBuckets are histogram bounds in seconds, from quick checks to
+// long DNS propagation waits
+var jobDurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+// Metrics counts tool calls and job outcomes for /metrics
+type Metrics struct {
+	mu           sync.Mutex
+	toolCalls    map[[2]string]uint64
+	spawnErrors  map[string]uint64
+	jobDurations map[[2]string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+var metrics = NewMetrics()
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		toolCalls:    make(map[[2]string]uint64),
+		spawnErrors:  make(map[string]uint64),
+		jobDurations: make(map[[2]string]*histogram),
+	}
+}
+
+// ToolCall counts a tools/call request by tool and outcome
+func (m *Metrics) ToolCall(tool string, isError bool) {
+	if !isKnownTool(tool) {
+		tool = "unknown"
+	}
+	outcome := "success"
+	if isError {
+		outcome = "error"
+	}
+	m.mu.Lock()
+	m.toolCalls[[2]string{tool, outcome}]++
+	m.mu.Unlock()
+}
+
+// SpawnError counts a command that could not be started
+func (m *Metrics) SpawnError(tool string) {
+	m.mu.Lock()
+	m.spawnErrors[tool]++
+	m.mu.Unlock()
+}
+
+// JobFinished records the run time of one job attempt
+func (m *Metrics) JobFinished(tool string, status JobStatus, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := [2]string{tool, string(status)}
+	h, ok := m.jobDurations[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(jobDurationBuckets))}
+		m.jobDurations[key] = h
+	}
+	secs := d.Seconds()
+	for i, le := range jobDurationBuckets {
+		if secs <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += secs
+	h.count++
+}
+
+// isKnownTool keeps unknown names out of metric labels
+func isKnownTool(name string) bool {
+	for _, t := range GetAllTools() {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WritePrometheus writes all metrics in the Prometheus text format
+func (m *Metrics) WritePrometheus(w io.Writer, jm JobManager) {
+	m.mu.Lock()
+
+	fmt.Fprintln(w, "# HELP a2cmds_tool_calls_total Tool calls by tool and outcome.")
+	fmt.Fprintln(w, "# TYPE a2cmds_tool_calls_total counter")
+	for _, k := range sortedKeys(m.toolCalls) {
+		fmt.Fprintf(w, "a2cmds_tool_calls_total{tool=%q,outcome=%q} %d\n", k[0], k[1], m.toolCalls[k])
+	}
+
+	fmt.Fprintln(w, "# HELP a2cmds_spawn_errors_total Commands that could not be started, by tool.")
+	fmt.Fprintln(w, "# TYPE a2cmds_spawn_errors_total counter")
+	tools := make([]string, 0, len(m.spawnErrors))
+	for t := range m.spawnErrors {
+		tools = append(tools, t)
+	}
+	sort.Strings(tools)
+	for _, t := range tools {
+		fmt.Fprintf(w, "a2cmds_spawn_errors_total{tool=%q} %d\n", t, m.spawnErrors[t])
+	}
+
+	fmt.Fprintln(w, "# HELP a2cmds_job_duration_seconds Run time of job attempts by tool and status.")
+	fmt.Fprintln(w, "# TYPE a2cmds_job_duration_seconds histogram")
+	for _, k := range sortedKeys(m.jobDurations) {
+		h := m.jobDurations[k]
+		labels := fmt.Sprintf("tool=%q,status=%q", k[0], k[1])
+		for i, le := range jobDurationBuckets {
+			fmt.Fprintf(w, "a2cmds_job_duration_seconds_bucket{%s,le=%q} %d\n", labels, formatFloat(le), h.counts[i])
+		}
+		fmt.Fprintf(w, "a2cmds_job_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "a2cmds_job_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(w, "a2cmds_job_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	m.mu.Unlock()
+
+	queued, running := jm.Stats()
+	fmt.Fprintln(w, "# HELP a2cmds_jobs_queued Jobs waiting in the queue.")
+	fmt.Fprintln(w, "# TYPE a2cmds_jobs_queued gauge")
+	fmt.Fprintf(w, "a2cmds_jobs_queued %d\n", queued)
+	fmt.Fprintln(w, "# HELP a2cmds_jobs_running Jobs running, by tool.")
+	fmt.Fprintln(w, "# TYPE a2cmds_jobs_running gauge")
+	tools = tools[:0]
+	for t := range running {
+		tools = append(tools, t)
+	}
+	sort.Strings(tools)
+	for _, t := range tools {
+		fmt.Fprintf(w, "a2cmds_jobs_running{tool=%q} %d\n", t, running[t])
+	}
+
+	writePropagationMetrics(w, A2ToolsCacheFile)
+}
+
+// writePropagationMetrics exports the average DNS propagation times that
+// fqdnmgr records per nameserver. A missing cache file exports nothing.
+func writePropagationMetrics(w io.Writer, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	type entry struct{ avg, updated string }
+	entries := make(map[string]entry)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 || fields[0] != "ap" {
+			continue
+		}
+		if _, err := strconv.ParseFloat(fields[2], 64); err != nil {
+			continue
+		}
+		if _, err := strconv.ParseInt(fields[3], 10, 64); err != nil {
+			continue
+		}
+		entries[fields[1]] = entry{fields[2], fields[3]}
+	}
+
+	servers := make([]string, 0, len(entries))
+	for ns := range entries {
+		servers = append(servers, ns)
+	}
+	sort.Strings(servers)
+
+	fmt.Fprintln(w, "# HELP a2cmds_dns_propagation_avg_seconds Average DNS propagation time per nameserver, from fqdnmgr's cache.")
+	fmt.Fprintln(w, "# TYPE a2cmds_dns_propagation_avg_seconds gauge")
+	for _, ns := range servers {
+		fmt.Fprintf(w, "a2cmds_dns_propagation_avg_seconds{nameserver=%q} %s\n", ns, entries[ns].avg)
+	}
+	fmt.Fprintln(w, "# HELP a2cmds_dns_propagation_updated_timestamp_seconds When the nameserver's average was last updated.")
+	fmt.Fprintln(w, "# TYPE a2cmds_dns_propagation_updated_timestamp_seconds gauge")
+	for _, ns := range servers {
+		fmt.Fprintf(w, "a2cmds_dns_propagation_updated_timestamp_seconds{nameserver=%q} %s\n", ns, entries[ns].updated)
+	}
+}
+
+func sortedKeys[V any](m map[[2]string]V) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// ServeMetrics serves /metrics on addr until the process exits
+func ServeMetrics(addr string, jm JobManager) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.WritePrometheus(w, jm)
+	})
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Fprintf(os.Stderr, "Metrics server: %v\n", err)
+		}
+	}()
+}
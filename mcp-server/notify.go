@@ -0,0 +1,435 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultNotifyConfig = "/etc/a2cmds-mcp/notify.json"
+
+	notifyOutputLines = 20
+	notifyTimeout     = 30 * time.Second
+	notifyAttempts    = 3
+)
+
+// When a notification fires
+const (
+	NotifyOnAlways  = "always"
+	NotifyOnSuccess = "success"
+	NotifyOnFailure = "failure"
+)
+
+// NotifyTarget says where to report a finished job. Exactly one of
+// Webhook, Email and Command is set.
+type NotifyTarget struct {
+	Webhook string   `json:"webhook,omitempty"`
+	Email   string   `json:"email,omitempty"`
+	Command []string `json:"command,omitempty"`
+	On      string   `json:"on,omitempty"`
+}
+
+// NotifyRule is a server-wide target for the jobs of some or all tools
+type NotifyRule struct {
+	NotifyTarget
+	Name  string   `json:"name,omitempty"`
+	Tools []string `json:"tools,omitempty"`
+}
+
+// NotifyConfig is the global notification configuration file
+type NotifyConfig struct {
+	// WebhookSecret signs webhook payloads with HMAC-SHA256
+	WebhookSecret string       `json:"webhookSecret,omitempty"`
+	Rules         []NotifyRule `json:"rules,omitempty"`
+}
+
+// Notifier delivers job notifications
+type Notifier struct {
+	config atomic.Pointer[NotifyConfig]
+	jobMgr JobManager
+}
+
+// notifier is nil until NewServer sets it up
+var notifier *Notifier
+
+// LoadNotifyConfig reads the global rules. A missing file means no rules.
+func LoadNotifyConfig(path string) (NotifyConfig, error) {
+	var cfg NotifyConfig
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	for i, r := range cfg.Rules {
+		if err := r.validate(true); err != nil {
+			return cfg, fmt.Errorf("rule %d (%s): %v", i+1, r.Name, err)
+		}
+	}
+	return cfg, nil
+}
+
+// NewNotifier sends notifications for jobs that jm finishes
+func NewNotifier(cfg NotifyConfig, jm JobManager) *Notifier {
+	n := &Notifier{jobMgr: jm}
+	n.config.Store(&cfg)
+	jm.OnJobFinished(n.jobFinished)
+	return n
+}
+
+// reloadNotifyConfig reads the global rules again and swaps them in. An
+// invalid file is ignored.
+func reloadNotifyConfig(path string) {
+	if notifier == nil {
+		return
+	}
+	cfg, err := LoadNotifyConfig(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Notification rules reload failed, keeping the current rules: %v\n", err)
+		return
+	}
+	notifier.config.Store(&cfg)
+	fmt.Fprintf(os.Stderr, "Notification rules reloaded from %s\n", path)
+}
+
+// validate checks a target. Command hooks run on the server, so only the
+// administrator's rules may use them, never a tool call.
+func (t NotifyTarget) validate(allowCommand bool) error {
+	set := 0
+	if t.Webhook != "" {
+		set++
+		u, err := url.Parse(t.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook must be an http or https URL")
+		}
+	}
+	if t.Email != "" {
+		set++
+		if strings.ContainsAny(t.Email, "\r\n, ") || !strings.Contains(t.Email, "@") {
+			return fmt.Errorf("invalid email address %q", t.Email)
+		}
+	}
+	if len(t.Command) > 0 {
+		set++
+		if !allowCommand {
+			return fmt.Errorf("command hooks can only be configured by the server administrator")
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of webhook, email or command is required")
+	}
+	switch t.On {
+	case "", NotifyOnAlways, NotifyOnSuccess, NotifyOnFailure:
+	default:
+		return fmt.Errorf("invalid on %q (expected always, success or failure)", t.On)
+	}
+	return nil
+}
+
+// parseNotify reads the notify argument of an async tool: one target
+// object or a list of them
+func parseNotify(raw any) ([]NotifyTarget, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	list, ok := raw.([]any)
+	if !ok {
+		list = []any{raw}
+	}
+
+	var targets []NotifyTarget
+	for i, item := range list {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("notify: target %d must be an object", i+1)
+		}
+		t := NotifyTarget{
+			Webhook: getString(m, "webhook", ""),
+			Email:   getString(m, "email", ""),
+			On:      getString(m, "on", NotifyOnAlways),
+		}
+		if _, ok := m["command"]; ok {
+			t.Command = []string{"-"}
+		}
+		if err := t.validate(false); err != nil {
+			return nil, fmt.Errorf("notify: %v", err)
+		}
+		if t.Webhook != "" && !webhookAllowed(t.Webhook) {
+			return nil, fmt.Errorf("notify: webhook host is not in the server's notify.webhookHosts")
+		}
+		if t.Email != "" && !emailAllowed(t.Email) {
+			return nil, fmt.Errorf("notify: email domain is not in the server's notify.emailDomains")
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// webhookAllowed reports whether a webhook URL a tool call names points at
+// one of the configured webhook hosts. Without the list, a call could make
+// the server POST to any address it can reach.
+func webhookAllowed(webhook string) bool {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return false
+	}
+	return hostAllowed(u.Hostname(), config().Notify.WebhookHosts)
+}
+
+// emailAllowed reports whether an address a tool call names is in one of
+// the configured email domains. Without the list, a call could have job
+// output mailed to anyone.
+func emailAllowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	return at >= 0 && hostAllowed(email[at+1:], config().Notify.EmailDomains)
+}
+
+// hostAllowed reports whether host is one of allowed, or a subdomain of
+// an entry written as "*.example.com"
+func hostAllowed(host string, allowed []string) bool {
+	host = strings.ToLower(host)
+	for _, a := range allowed {
+		if suffix, ok := strings.CutPrefix(a, "*"); ok {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == a {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the target fires for a job that ended with status
+func (t NotifyTarget) matches(status JobStatus) bool {
+	switch t.On {
+	case NotifyOnSuccess:
+		return status == JobStatusCompleted
+	case NotifyOnFailure:
+		return status == JobStatusFailed
+	}
+	return true
+}
+
+// describe names the target in logs
+func (t NotifyTarget) describe() string {
+	switch {
+	case t.Webhook != "":
+		return "webhook " + t.Webhook
+	case t.Email != "":
+		return "email to " + t.Email
+	}
+	return "command " + strings.Join(t.Command, " ")
+}
+
+// notifyPayload is the job summary sent to every target
+type notifyPayload struct {
+	Event           string    `json:"event"`
+	Host            string    `json:"host"`
+	JobID           string    `json:"jobId"`
+	Tool            string    `json:"tool"`
+	Status          JobStatus `json:"status"`
+	ExitCode        int       `json:"exitCode"`
+	Source          string    `json:"source,omitempty"`
+	QueuedAt        time.Time `json:"queuedAt"`
+	StartedAt       time.Time `json:"startedAt"`
+	EndedAt         time.Time `json:"endedAt"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	Attempts        int       `json:"attempts"`
+	OutputTail      []string  `json:"outputTail"`
+	StderrTail      string    `json:"stderrTail,omitempty"`
+}
+
+// jobFinished sends the job's own targets and the matching global rules.
+// Global rules skip pipeline steps; the pipeline job itself is reported.
+func (n *Notifier) jobFinished(spec JobSpec, info JobInfo) {
+	targets := append([]NotifyTarget(nil), spec.Notify...)
+	if spec.ParentID == "" {
+		for _, r := range n.config.Load().Rules {
+			if len(r.Tools) == 0 || slices.Contains(r.Tools, spec.Tool) {
+				targets = append(targets, r.NotifyTarget)
+			}
+		}
+	}
+
+	var payload *notifyPayload
+	for _, t := range targets {
+		if !t.matches(info.Status) {
+			continue
+		}
+		if payload == nil {
+			payload = newNotifyPayload(spec, info)
+		}
+		// Targets are independent; a slow webhook must not delay the rest
+		go n.deliver(t, payload)
+	}
+}
+
+// deliver sends one notification and records a failure in the job output
+func (n *Notifier) deliver(t NotifyTarget, p *notifyPayload) {
+	err := n.send(t, p)
+	if err == nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Job %s: notification via %s failed: %v\n", p.JobID, t.describe(), err)
+	n.jobMgr.AppendOutput(p.JobID, fmt.Sprintf("Notification via %s failed: %v", t.describe(), err))
+}
+
+func newNotifyPayload(spec JobSpec, info JobInfo) *notifyPayload {
+	// The host that ran the job: an inventory name, or this machine
+	host := info.Host
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	lines := strings.Split(strings.TrimRight(info.Output, "\n"), "\n")
+	if len(lines) > notifyOutputLines {
+		lines = lines[len(lines)-notifyOutputLines:]
+	}
+	attempts := len(info.Attempts)
+	if attempts == 0 && !info.StartTime.IsZero() {
+		attempts = 1
+	}
+	return &notifyPayload{
+		Event:           "job." + string(info.Status),
+		Host:            host,
+		JobID:           info.ID,
+		Tool:            spec.Tool,
+		Status:          info.Status,
+		ExitCode:        info.ExitCode,
+		Source:          spec.Source,
+		QueuedAt:        info.QueueTime,
+		StartedAt:       info.StartTime,
+		EndedAt:         info.EndTime,
+		DurationSeconds: info.EndTime.Sub(info.StartTime).Seconds(),
+		Attempts:        attempts,
+		OutputTail:      lines,
+		StderrTail:      tail(info.Stderr, 2000),
+	}
+}
+
+// send delivers one notification, retrying a few times
+func (n *Notifier) send(t NotifyTarget, p *notifyPayload) error {
+	var err error
+	for attempt := 1; attempt <= notifyAttempts; attempt++ {
+		switch {
+		case t.Webhook != "":
+			err = n.sendWebhook(t.Webhook, p)
+		case t.Email != "":
+			err = sendMail(t.Email, p)
+		default:
+			err = runHook(t.Command, p)
+		}
+		if err == nil {
+			return nil
+		}
+		if attempt < notifyAttempts {
+			time.Sleep(time.Duration(attempt) * 5 * time.Second)
+		}
+	}
+	return err
+}
+
+// sendWebhook POSTs the payload as JSON. With a secret configured the body
+// is signed in X-A2cmds-Signature as sha256=<hex HMAC>.
+func (n *Notifier) sendWebhook(target string, p *notifyPayload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-A2cmds-Event", p.Event)
+	if secret := n.config.Load().WebhookSecret; secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-A2cmds-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	// Redirects are not followed, so a webhook cannot send the payload on
+	// to a host outside notify.webhookHosts
+	client := http.Client{
+		Timeout: notifyTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	return nil
+}
+
+// sendMail hands a plain-text summary to the local sendmail
+func sendMail(to string, p *notifyPayload) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "To: %s\n", to)
+	fmt.Fprintf(&msg, "Subject: [a2cmds-mcp] %s %s on %s\n", p.Tool, p.Status, p.Host)
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\n\n")
+	fmt.Fprintf(&msg, "Job:       %s\nTool:      %s\nStatus:    %s (exit code %d)\n", p.JobID, p.Tool, p.Status, p.ExitCode)
+	if p.Source != "" {
+		fmt.Fprintf(&msg, "Started by: %s\n", p.Source)
+	}
+	fmt.Fprintf(&msg, "Started:   %s\nEnded:     %s\nAttempts:  %d\n", p.StartedAt.Format(time.RFC3339), p.EndedAt.Format(time.RFC3339), p.Attempts)
+	fmt.Fprintf(&msg, "\n--- Output (last %d lines) ---\n%s\n", notifyOutputLines, strings.Join(p.OutputTail, "\n"))
+	if p.StderrTail != "" {
+		fmt.Fprintf(&msg, "\n--- Stderr ---\n%s\n", p.StderrTail)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sendmail", "-t", "-i")
+	cmd.Stdin = strings.NewReader(msg.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("sendmail: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// runHook runs an administrator's command with the payload as JSON on
+// stdin and the key fields in its environment
+func runHook(argv []string, p *notifyPayload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"A2CMDS_JOB_ID="+p.JobID,
+		"A2CMDS_TOOL="+p.Tool,
+		"A2CMDS_STATUS="+string(p.Status),
+		fmt.Sprintf("A2CMDS_EXIT_CODE=%d", p.ExitCode),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, tail(strings.TrimSpace(string(out)), 500))
+	}
+	return nil
+}
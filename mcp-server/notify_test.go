@@ -0,0 +1,119 @@
+package mcpserver
+
+import (
+	"strings"
+	"testing"
+)
+
+// useConfig runs the rest of the test with the default configuration as
+// changed by change
+func useConfig(t *testing.T, change func(c *Config)) {
+	t.Helper()
+	c := defaultConfig()
+	change(c)
+	old := activeConfig.Load()
+	activeConfig.Store(c)
+	t.Cleanup(func() {
+		if old == nil {
+			activeConfig.Store(defaultConfig())
+		} else {
+			activeConfig.Store(old)
+		}
+	})
+}
+
+func TestParseNotify(t *testing.T) {
+	useConfig(t, func(c *Config) {
+		c.Notify.WebhookHosts = []string{"hooks.example.com", "*.chat.example.com", "127.0.0.1"}
+		c.Notify.EmailDomains = []string{"example.com", "*.example.org"}
+	})
+
+	tests := []struct {
+		name    string
+		raw     any
+		want    int
+		wantErr string
+	}{
+		{"none", nil, 0, ""},
+		{"listed webhook", map[string]any{"webhook": "https://hooks.example.com/a2cmds"}, 1, ""},
+		{"listed webhook in other case", map[string]any{"webhook": "https://HOOKS.example.com/a2cmds"}, 1, ""},
+		{"subdomain webhook", map[string]any{"webhook": "https://ops.chat.example.com/x"}, 1, ""},
+		{"loopback webhook listed", map[string]any{"webhook": "http://127.0.0.1:9000/"}, 1, ""},
+		{"list", []any{map[string]any{"webhook": "https://hooks.example.com/"}, map[string]any{"email": "ops@example.com", "on": "failure"}}, 2, ""},
+
+		{"unlisted webhook", map[string]any{"webhook": "https://evil.org/"}, 0, "notify.webhookHosts"},
+		{"metadata address", map[string]any{"webhook": "http://169.254.169.254/latest/meta-data"}, 0, "notify.webhookHosts"},
+		{"suffix trick", map[string]any{"webhook": "https://hooks.example.com.evil.org/"}, 0, "notify.webhookHosts"},
+		{"wildcard is not the domain", map[string]any{"webhook": "https://chat.example.com/"}, 0, "notify.webhookHosts"},
+		{"userinfo trick", map[string]any{"webhook": "https://hooks.example.com@evil.org/"}, 0, "notify.webhookHosts"},
+		{"not http", map[string]any{"webhook": "file:///etc/passwd"}, 0, "http or https"},
+
+		{"listed email", map[string]any{"email": "ops@example.com"}, 1, ""},
+		{"subdomain email", map[string]any{"email": "ops@mail.example.org"}, 1, ""},
+		{"unlisted email", map[string]any{"email": "me@evil.org"}, 0, "notify.emailDomains"},
+		{"email suffix trick", map[string]any{"email": "me@example.com.evil.org"}, 0, "notify.emailDomains"},
+		{"two addresses", map[string]any{"email": "ops@example.com,me@evil.org"}, 0, "invalid email"},
+
+		{"command", map[string]any{"command": []any{"sh"}}, 0, "server administrator"},
+		{"two targets in one", map[string]any{"webhook": "https://hooks.example.com/", "email": "ops@example.com"}, 0, "exactly one"},
+		{"bad on", map[string]any{"email": "ops@example.com", "on": "sometimes"}, 0, "invalid on"},
+		{"not an object", "https://hooks.example.com/", 0, "must be an object"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := parseNotify(tt.raw)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseNotify = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(targets) != tt.want {
+				t.Errorf("parseNotify returned %d targets, want %d", len(targets), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNotifyWithoutAllowlists(t *testing.T) {
+	useConfig(t, func(c *Config) {})
+
+	for _, raw := range []map[string]any{
+		{"webhook": "https://hooks.example.com/"},
+		{"email": "ops@example.com"},
+	} {
+		if _, err := parseNotify(raw); err == nil {
+			t.Errorf("parseNotify(%v) succeeded with no allowed hosts", raw)
+		}
+	}
+}
+
+func TestCheckNotifyHosts(t *testing.T) {
+	tests := []struct {
+		hosts   []string
+		want    []string
+		wantErr bool
+	}{
+		{[]string{"Hooks.Example.com", "*.example.org"}, []string{"hooks.example.com", "*.example.org"}, false},
+		{[]string{"*."}, nil, true},
+		{[]string{""}, nil, true},
+		{[]string{"example.com/path"}, nil, true},
+		{[]string{"example.com:8080"}, nil, true},
+		{[]string{"ops@example.com"}, nil, true},
+		{[]string{"*example.com"}, nil, true},
+	}
+	for _, tt := range tests {
+		err := checkNotifyHosts(tt.hosts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkNotifyHosts(%q) = %v, want error %v", tt.hosts, err, tt.wantErr)
+			continue
+		}
+		if err == nil && strings.Join(tt.hosts, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("checkNotifyHosts lowercased to %q, want %q", tt.hosts, tt.want)
+		}
+	}
+}
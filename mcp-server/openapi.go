@@ -0,0 +1,195 @@
+package mcpserver
+
+import (
+	"reflect"
+	"strings"
+)
+
+// openAPIDocument describes the REST API as OpenAPI 3.1, with one
+// operation for each enabled tool whose request body is the tool's input
+// schema. It follows reloads, like tools/list.
+func openAPIDocument() map[string]any {
+	job := mustSchema(reflect.TypeOf(jobContent{}))
+	command := mustSchema(reflect.TypeOf(commandContent{}))
+
+	paths := map[string]any{
+		"/v1/tools": map[string]any{
+			"get": map[string]any{
+				"operationId": "listTools",
+				"summary":     "List the tools this token may call",
+				"responses": map[string]any{
+					"200": jsonResponse("The tools, as MCP tools/list gives them", map[string]any{
+						"type":       "object",
+						"properties": map[string]any{"tools": map[string]any{"type": "array", "items": map[string]any{"type": "object"}}},
+					}),
+					"401": errorResponse("Missing or unknown token"),
+				},
+			},
+		},
+		"/v1/jobs/{id}": map[string]any{
+			"get": map[string]any{
+				"operationId": "getJob",
+				"summary":     "Get a job's status, or wait for it to change",
+				"description": "Without wait or pattern this is check_job_status. With either it is wait_job: it returns when the job finishes, asks for input, prints a match for pattern, or wait seconds (at most 120) pass.",
+				"parameters": []any{
+					map[string]any{"name": "id", "in": "path", "required": true, "schema": map[string]any{"type": "string"}},
+					map[string]any{"name": "wait", "in": "query", "schema": map[string]any{"type": "integer", "minimum": 1, "maximum": 120}},
+					map[string]any{"name": "pattern", "in": "query", "schema": map[string]any{"type": "string"}, "description": "Regular expression matched against the job's output"},
+				},
+				"responses": map[string]any{
+					"200": jsonResponse("The job", resultSchema(job)),
+					"400": errorResponse("Invalid wait or pattern"),
+					"401": errorResponse("Missing or unknown token"),
+					"403": errorResponse("The token may not call check_job_status or wait_job"),
+					"404": jsonResponse("No such job, or it has expired", resultSchema(nil)),
+				},
+			},
+		},
+		"/v1/jobs/{id}/input": map[string]any{
+			"post": map[string]any{
+				"operationId": "sendJobInput",
+				"summary":     "Answer an interactive job's prompt",
+				"parameters": []any{
+					map[string]any{"name": "id", "in": "path", "required": true, "schema": map[string]any{"type": "string"}},
+				},
+				"requestBody": toolRequestBody(withoutProperty(toolSchema("send_job_input"), "jobId")),
+				"responses": map[string]any{
+					"200": jsonResponse("The job a moment after the input was sent", resultSchema(job)),
+					"400": errorResponse("The body is not a JSON object"),
+					"401": errorResponse("Missing or unknown token"),
+					"403": errorResponse("The token may not call send_job_input"),
+					"409": jsonResponse("The job is not running or not reading input", resultSchema(nil)),
+				},
+			},
+		},
+	}
+
+	for _, tool := range GetAllTools() {
+		if !toolEnabled(tool.Name) {
+			continue
+		}
+		summary, _, _ := strings.Cut(tool.Description, ". ")
+		responses := map[string]any{
+			"400": errorResponse("The body is not a JSON object"),
+			"401": errorResponse("Missing or unknown token"),
+			"403": errorResponse("The token may not call this tool"),
+			"422": jsonResponse("The tool refused the call, for example for an invalid argument", resultSchema(nil)),
+		}
+		switch {
+		case startsJob(tool):
+			responses["202"] = map[string]any{
+				"description": "The job was started, or an earlier call with the same idempotency key started it",
+				"headers": map[string]any{
+					"Location": map[string]any{"description": "The job's URL", "schema": map[string]any{"type": "string"}},
+				},
+				"content": map[string]any{"application/json": map[string]any{"schema": resultSchema(job)}},
+			}
+		case runsCommand(tool.Name):
+			responses["200"] = jsonResponse("How the script ran; a non-zero exit code is not an error", resultSchema(command))
+		default:
+			responses["200"] = jsonResponse("The result", resultSchema(map[string]any{}))
+		}
+		paths["/v1/tools/"+tool.Name] = map[string]any{
+			"post": map[string]any{
+				"operationId": tool.Name,
+				"summary":     summary,
+				"description": tool.Description,
+				"requestBody": toolRequestBody(tool.InputSchema),
+				"responses":   responses,
+			},
+		}
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       serverInfo.Name + " REST API",
+			"version":     serverInfo.Version,
+			"description": "The MCP server's tools as REST calls. Each call is authorized and audited like the same call over MCP.",
+		},
+		"servers":  []any{map[string]any{"url": "/"}},
+		"security": []any{map[string]any{"bearer": []string{}}},
+		"paths":    paths,
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+			"schemas": map[string]any{
+				"Error": map[string]any{
+					"type":       "object",
+					"properties": map[string]any{"error": map[string]any{"type": "string"}},
+					"required":   []string{"error"},
+				},
+			},
+		},
+	}
+}
+
+// resultSchema is a tool call's response: its text and, when result is
+// not nil, its structured result
+func resultSchema(result any) map[string]any {
+	props := map[string]any{
+		"text": map[string]any{"type": "string", "description": "The result as MCP clients see it"},
+	}
+	if result != nil {
+		props["result"] = result
+	}
+	return map[string]any{"type": "object", "properties": props, "required": []string{"text"}}
+}
+
+func toolRequestBody(schema InputSchema) map[string]any {
+	return map[string]any{
+		"required": len(schema.Required) > 0,
+		"content":  map[string]any{"application/json": map[string]any{"schema": schema}},
+	}
+}
+
+func jsonResponse(description string, schema any) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content":     map[string]any{"application/json": map[string]any{"schema": schema}},
+	}
+}
+
+func errorResponse(description string) map[string]any {
+	return jsonResponse(description, map[string]any{"$ref": "#/components/schemas/Error"})
+}
+
+// toolSchema returns a tool's input schema
+func toolSchema(name string) InputSchema {
+	tool, _ := enabledTool(name)
+	return tool.InputSchema
+}
+
+// withoutProperty returns schema without one of its properties
+func withoutProperty(schema InputSchema, name string) InputSchema {
+	props := make(map[string]Property, len(schema.Properties))
+	for k, v := range schema.Properties {
+		if k != name {
+			props[k] = v
+		}
+	}
+	var required []string
+	for _, r := range schema.Required {
+		if r != name {
+			required = append(required, r)
+		}
+	}
+	return InputSchema{Type: schema.Type, Properties: props, Required: required}
+}
+
+// mustSchema describes a structured result type
+func mustSchema(t reflect.Type) InputSchema {
+	schema, err := schemaFor(t)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// runsCommand reports whether a sync tool runs a script, so its result
+// is the script's exit code and output
+func runsCommand(name string) bool {
+	_, ok := argvRules[name]
+	return ok || definedTool(name) != nil
+}
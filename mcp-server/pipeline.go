@@ -0,0 +1,439 @@
+package mcpserver
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What a pipeline does when a step fails
+const (
+	// OnFailureAbort starts no further steps and fails the pipeline
+	OnFailureAbort = "abort"
+	// OnFailureSkip skips the step's dependents, runs the rest and fails the pipeline
+	OnFailureSkip = "skip"
+	// OnFailureContinue runs the step's dependents as if it had succeeded
+	OnFailureContinue = "continue"
+)
+
+const (
+	DefaultPollInterval = 30 * time.Second
+	DefaultPollTimeout  = 30 * time.Minute
+	MinPollInterval     = 5 * time.Second
+)
+
+type StepStatus string
+
+const (
+	StepPending   StepStatus = "pending"
+	StepRunning   StepStatus = "running"
+	StepCompleted StepStatus = "completed"
+	StepFailed    StepStatus = "failed"
+	StepSkipped   StepStatus = "skipped"
+)
+
+// StepInfo is a point-in-time copy of a pipeline step's state
+type StepInfo struct {
+	ID        string
+	Tool      string
+	Status    StepStatus
+	JobID     string
+	Attempts  int
+	ExitCode  int
+	DependsOn []string
+}
+
+// pipelineStep is one step of a pipeline and its run state
+type pipelineStep struct {
+	ID           string
+	Tool         string
+	DependsOn    []string
+	OnFailure    string
+	PollInterval time.Duration
+	PollTimeout  time.Duration
+
+	spec     JobSpec
+	args     map[string]any
+	status   StepStatus
+	jobID    string
+	attempts int
+	exitCode int
+	// borrowed is set when jobID was started earlier by another call with
+	// the same idempotency key; cancelling the pipeline leaves it running
+	borrowed bool
+}
+
+// pipelineRun drives the steps of one pipeline as children of a parent job
+type pipelineRun struct {
+	job *Job
+
+	mu      sync.Mutex
+	steps   []*pipelineStep
+	byID    map[string]*pipelineStep
+	aborted bool
+	failed  bool
+	// cancelled stops steps from starting and polling steps from being
+	// rerun; stop is closed with it to wake steps waiting to poll again
+	cancelled bool
+	stop      chan struct{}
+}
+
+// parsePipelineSteps validates the steps argument of run_pipeline and
+// builds each step's command up front, so bad arguments are rejected
+// before anything runs
+func parsePipelineSteps(raw any) ([]*pipelineStep, error) {
+	list, ok := raw.([]any)
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("steps must be a non-empty array")
+	}
+
+	steps := make([]*pipelineStep, 0, len(list))
+	byID := make(map[string]*pipelineStep, len(list))
+
+	for i, item := range list {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("step %d must be an object", i+1)
+		}
+
+		st := &pipelineStep{
+			ID:        getString(m, "id", ""),
+			Tool:      getString(m, "tool", ""),
+			OnFailure: getString(m, "onFailure", OnFailureAbort),
+			status:    StepPending,
+		}
+		if st.ID == "" {
+			st.ID = fmt.Sprintf("step%d", i+1)
+		}
+		if _, dup := byID[st.ID]; dup {
+			return nil, fmt.Errorf("duplicate step id: %s", st.ID)
+		}
+
+		switch st.OnFailure {
+		case OnFailureAbort, OnFailureSkip, OnFailureContinue:
+		default:
+			return nil, fmt.Errorf("step %s: invalid onFailure %q (expected abort, skip or continue)", st.ID, st.OnFailure)
+		}
+
+		if deps, ok := m["dependsOn"].([]any); ok {
+			for _, d := range deps {
+				dep, ok := d.(string)
+				if !ok || dep == "" {
+					return nil, fmt.Errorf("step %s: dependsOn must be a list of step ids", st.ID)
+				}
+				st.DependsOn = append(st.DependsOn, dep)
+			}
+		}
+
+		if poll, ok := m["poll"].(map[string]any); ok {
+			st.PollInterval = time.Duration(getInt(poll, "intervalSeconds", int(DefaultPollInterval/time.Second))) * time.Second
+			st.PollTimeout = time.Duration(getInt(poll, "timeoutSeconds", int(DefaultPollTimeout/time.Second))) * time.Second
+			if st.PollInterval < MinPollInterval {
+				return nil, fmt.Errorf("step %s: poll interval must be at least %s", st.ID, MinPollInterval)
+			}
+		}
+
+		args, _ := m["arguments"].(map[string]any)
+		if args == nil {
+			args = map[string]any{}
+		}
+		spec, err := buildToolCommand(st.Tool, args)
+		if err != nil {
+			return nil, fmt.Errorf("step %s: %v", st.ID, err)
+		}
+		st.spec = spec
+		st.args = args
+
+		steps = append(steps, st)
+		byID[st.ID] = st
+	}
+
+	for _, st := range steps {
+		for _, dep := range st.DependsOn {
+			if _, ok := byID[dep]; !ok {
+				return nil, fmt.Errorf("step %s depends on unknown step %s", st.ID, dep)
+			}
+		}
+	}
+
+	if cycle := findCycle(steps, byID); cycle != "" {
+		return nil, fmt.Errorf("dependency cycle: %s", cycle)
+	}
+
+	return steps, nil
+}
+
+// findCycle returns a description of a dependsOn cycle, or "" if there is none
+func findCycle(steps []*pipelineStep, byID map[string]*pipelineStep) string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(steps))
+	var path []string
+
+	var visit func(id string) string
+	visit = func(id string) string {
+		switch state[id] {
+		case visiting:
+			return strings.Join(append(path, id), " -> ")
+		case visited:
+			return ""
+		}
+		state[id] = visiting
+		path = append(path, id)
+		for _, dep := range byID[id].DependsOn {
+			if c := visit(dep); c != "" {
+				return c
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = visited
+		return ""
+	}
+
+	for _, st := range steps {
+		if c := visit(st.ID); c != "" {
+			return c
+		}
+	}
+	return ""
+}
+
+// StartPipeline runs steps as child jobs of a new parent job described by
+// spec and returns the parent job's ID
+func (jm *JobQueue) StartPipeline(steps []*pipelineStep, spec JobSpec) string {
+	p := &pipelineRun{
+		steps: steps,
+		byID:  make(map[string]*pipelineStep, len(steps)),
+		stop:  make(chan struct{}),
+	}
+	for _, st := range steps {
+		p.byID[st.ID] = st
+	}
+
+	spec.Tool = "run_pipeline"
+	p.job = jm.StartParentJob(spec)
+	p.job.mu.Lock()
+	p.job.pipeline = p
+	p.job.mu.Unlock()
+
+	go p.run(jm)
+	return p.job.ID
+}
+
+// run starts steps as their dependencies finish until none are left
+func (p *pipelineRun) run(jm *JobQueue) {
+	finished := make(chan *pipelineStep)
+	running := 0
+
+	for {
+		p.mu.Lock()
+		ready := p.advanceLocked()
+		p.mu.Unlock()
+
+		for _, st := range ready {
+			running++
+			go p.runStep(jm, st, finished)
+		}
+		if running == 0 {
+			break
+		}
+
+		st := <-finished
+		running--
+
+		p.mu.Lock()
+		if st.status == StepFailed {
+			switch st.OnFailure {
+			case OnFailureAbort:
+				p.aborted = true
+				p.failed = true
+				p.job.appendOutput(fmt.Sprintf("Step %s failed; aborting pipeline", st.ID))
+			case OnFailureSkip:
+				p.failed = true
+				p.job.appendOutput(fmt.Sprintf("Step %s failed; skipping its dependents", st.ID))
+			default:
+				p.job.appendOutput(fmt.Sprintf("Step %s failed; continuing", st.ID))
+			}
+		}
+		p.mu.Unlock()
+	}
+
+	p.mu.Lock()
+	success := !p.failed
+	p.mu.Unlock()
+
+	if success {
+		p.job.appendOutput("Pipeline completed")
+	} else {
+		p.job.appendOutput("Pipeline failed")
+	}
+	jm.FinishParentJob(p.job, success)
+}
+
+// advanceLocked skips steps that can no longer run and marks the steps
+// whose dependencies are satisfied as running. Callers hold p.mu.
+func (p *pipelineRun) advanceLocked() []*pipelineStep {
+	var ready []*pipelineStep
+
+	// Skipping a step can make its dependents skippable, so repeat until
+	// nothing changes
+	for changed := true; changed; {
+		changed = false
+		for _, st := range p.steps {
+			if st.status != StepPending {
+				continue
+			}
+
+			if p.aborted {
+				st.status = StepSkipped
+				changed = true
+				continue
+			}
+
+			satisfied := true
+			for _, dep := range st.DependsOn {
+				d := p.byID[dep]
+				switch {
+				case d.status == StepCompleted:
+				case d.status == StepFailed && d.OnFailure == OnFailureContinue:
+				case d.status == StepSkipped, d.status == StepFailed:
+					st.status = StepSkipped
+					p.job.appendOutput(fmt.Sprintf("Step %s skipped: dependency %s %s", st.ID, dep, d.status))
+					changed = true
+					satisfied = false
+				default:
+					satisfied = false
+				}
+				if st.status == StepSkipped {
+					break
+				}
+			}
+
+			if satisfied && st.status == StepPending {
+				st.status = StepRunning
+				ready = append(ready, st)
+			}
+		}
+	}
+
+	return ready
+}
+
+// runStep runs one step as a child job, rerunning it while it fails if the
+// step polls, and reports it on finished
+func (p *pipelineRun) runStep(jm *JobQueue, st *pipelineStep, finished chan<- *pipelineStep) {
+	deadline := time.Now().Add(st.PollTimeout)
+
+	for {
+		spec := st.spec
+		spec.Priority = p.job.Spec.Priority
+		spec.ParentID = p.job.ID
+		spec.Caller = p.job.Spec.Caller
+		spec.Trace = p.job.span.Context()
+
+		// Hold p.mu while the job starts: a cancelled pipeline must not
+		// start another step, and cancel must see the job to stop it
+		p.mu.Lock()
+		if p.cancelled {
+			st.status = StepSkipped
+			p.job.appendOutput(fmt.Sprintf("Step %s not started: the pipeline was cancelled", st.ID))
+			p.mu.Unlock()
+			break
+		}
+		rec, existing, err := submitIdempotent(st.Tool, st.args, func() (string, error) {
+			return jm.StartJob(spec)
+		})
+		if err != nil {
+			st.status = StepFailed
+			st.exitCode = -1
+			p.job.appendOutput(fmt.Sprintf("Step %s could not start: %v", st.ID, err))
+			p.mu.Unlock()
+			break
+		}
+		jobID := rec.JobID
+		st.jobID = jobID
+		st.borrowed = existing
+		st.attempts++
+		if existing {
+			p.job.appendOutput(fmt.Sprintf("Step %s (%s): job %s was already started for this call at %s; using its result", st.ID, st.Tool, jobID, rec.CreatedAt.Format(time.RFC3339)))
+		} else {
+			p.job.appendOutput(fmt.Sprintf("Step %s (%s) started as job %s", st.ID, st.Tool, jobID))
+		}
+		p.mu.Unlock()
+
+		info := rec.info()
+		if job := jm.GetJob(jobID); job != nil {
+			<-job.Done()
+			info, _ = jm.GetJobStatus(jobID)
+		}
+
+		p.mu.Lock()
+		st.exitCode = info.ExitCode
+		if info.Status == JobStatusCompleted {
+			st.status = StepCompleted
+			p.job.appendOutput(fmt.Sprintf("Step %s completed", st.ID))
+			p.mu.Unlock()
+			break
+		}
+		if st.PollInterval > 0 && !p.cancelled && !existing && time.Now().Add(st.PollInterval).Before(deadline) {
+			p.job.appendOutput(fmt.Sprintf("Step %s not successful yet (exit %d); retrying in %s", st.ID, info.ExitCode, st.PollInterval))
+			p.mu.Unlock()
+			select {
+			case <-time.After(st.PollInterval):
+			case <-p.stop:
+			}
+			continue
+		}
+		st.status = StepFailed
+		p.job.appendOutput(fmt.Sprintf("Step %s failed with exit code %d", st.ID, info.ExitCode))
+		p.mu.Unlock()
+		break
+	}
+
+	finished <- st
+}
+
+// cancel skips the steps that have not started and cancels the jobs of
+// the running ones
+func (p *pipelineRun) cancel(jm *JobQueue) {
+	p.mu.Lock()
+	p.aborted, p.failed, p.cancelled = true, true, true
+	close(p.stop)
+	var running []string
+	for _, st := range p.steps {
+		if st.status == StepRunning && st.jobID != "" && !st.borrowed {
+			running = append(running, st.jobID)
+		}
+	}
+	p.mu.Unlock()
+
+	p.job.appendOutput("Cancelled, stopping the running steps")
+	for _, id := range running {
+		// A step whose job just finished is fine to miss
+		jm.CancelJob(id)
+	}
+}
+
+// snapshot returns the current state of every step
+func (p *pipelineRun) snapshot() []StepInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	out := make([]StepInfo, 0, len(p.steps))
+	for _, st := range p.steps {
+		out = append(out, StepInfo{
+			ID:        st.ID,
+			Tool:      st.Tool,
+			Status:    st.status,
+			JobID:     st.jobID,
+			Attempts:  st.attempts,
+			ExitCode:  st.exitCode,
+			DependsOn: st.DependsOn,
+		})
+	}
+	return out
+}
@@ -0,0 +1,134 @@
+package mcpserver
+
+import (
+	"strings"
+	"testing"
+)
+
+// step builds one entry of run_pipeline's steps argument
+func step(id, tool string, args map[string]any, extra map[string]any) map[string]any {
+	m := map[string]any{"id": id, "tool": tool, "arguments": args}
+	for k, v := range extra {
+		m[k] = v
+	}
+	return m
+}
+
+func TestParsePipelineSteps(t *testing.T) {
+	list := map[string]any{}
+	tests := []struct {
+		name    string
+		steps   any
+		wantErr string
+	}{
+		{
+			name:  "dependent steps",
+			steps: []any{step("a", "fqdncredmgr_list", list, nil), step("b", "a2wcrecalc", list, map[string]any{"dependsOn": []any{"a"}})},
+		},
+		{
+			name:    "no steps",
+			steps:   []any{},
+			wantErr: "non-empty array",
+		},
+		{
+			name:    "duplicate id",
+			steps:   []any{step("a", "fqdncredmgr_list", list, nil), step("a", "a2wcrecalc", list, nil)},
+			wantErr: "duplicate step id: a",
+		},
+		{
+			name:    "bad onFailure",
+			steps:   []any{step("a", "fqdncredmgr_list", list, map[string]any{"onFailure": "retry"})},
+			wantErr: "invalid onFailure",
+		},
+		{
+			name:    "unknown dependency",
+			steps:   []any{step("a", "fqdncredmgr_list", list, map[string]any{"dependsOn": []any{"b"}})},
+			wantErr: "depends on unknown step b",
+		},
+		{
+			name: "cycle",
+			steps: []any{
+				step("a", "fqdncredmgr_list", list, map[string]any{"dependsOn": []any{"b"}}),
+				step("b", "a2wcrecalc", list, map[string]any{"dependsOn": []any{"a"}}),
+			},
+			wantErr: "dependency cycle: a -> b -> a",
+		},
+		{
+			name:    "poll too often",
+			steps:   []any{step("a", "fqdnmgr_checkInitDns", map[string]any{"fqdn": "example.com"}, map[string]any{"poll": map[string]any{"intervalSeconds": float64(1)}})},
+			wantErr: "poll interval must be at least",
+		},
+		{
+			name:    "bad arguments",
+			steps:   []any{step("a", "fqdnmgr_check", list, nil)},
+			wantErr: "step a:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePipelineSteps(tt.steps)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPipelineOnFailure(t *testing.T) {
+	useReplayer(t)
+
+	// a2certrenew fails in its recording; fqdncredmgr_list succeeds
+	tests := []struct {
+		onFailure  string
+		wantFailed bool
+		want       map[string]StepStatus
+	}{
+		{
+			onFailure:  OnFailureAbort,
+			wantFailed: true,
+			want:       map[string]StepStatus{"renew": StepFailed, "after": StepSkipped, "last": StepSkipped},
+		},
+		{
+			onFailure:  OnFailureSkip,
+			wantFailed: true,
+			want:       map[string]StepStatus{"renew": StepFailed, "after": StepSkipped, "last": StepSkipped},
+		},
+		{
+			onFailure: OnFailureContinue,
+			want:      map[string]StepStatus{"renew": StepFailed, "after": StepCompleted, "last": StepCompleted},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.onFailure, func(t *testing.T) {
+			steps := []any{
+				step("renew", "a2certrenew", map[string]any{}, map[string]any{"onFailure": tt.onFailure}),
+				step("after", "fqdncredmgr_list", map[string]any{}, map[string]any{"dependsOn": []any{"renew"}}),
+				step("last", "fqdncredmgr_list", map[string]any{}, map[string]any{"dependsOn": []any{"after"}}),
+			}
+			result := ExecuteTool("run_pipeline", map[string]any{"steps": steps})
+			job, ok := result.StructuredContent.(*jobContent)
+			if !ok {
+				t.Fatalf("run_pipeline did not start a job: %+v", result)
+			}
+			<-jobMgr.Done(job.JobID)
+			info, _ := jobMgr.GetJobStatus(job.JobID)
+
+			if failed := info.Status == JobStatusFailed; failed != tt.wantFailed {
+				t.Errorf("pipeline failed = %v, want %v\n%s", failed, tt.wantFailed, info.Output)
+			}
+			if len(info.Steps) != len(tt.want) {
+				t.Fatalf("got %d steps, want %d", len(info.Steps), len(tt.want))
+			}
+			for _, st := range info.Steps {
+				if st.Status != tt.want[st.ID] {
+					t.Errorf("step %s is %s, want %s", st.ID, st.Status, tt.want[st.ID])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package mcpserver
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// ExecProfile controls the environment and resources of a tool's process
+type ExecProfile struct {
+	// Resource limits applied with prlimit; 0 means inherit
+	CPUSeconds  uint64
+	MemoryBytes uint64
+	OpenFiles   uint64
+
+	// Scheduling priority applied with nice and ionice. IOClass is 1
+	// (realtime), 2 (best-effort) or 3 (idle); 0 leaves I/O priority alone.
+	Nice       int
+	IOClass    int
+	IOPriority int
+
+	// Env names the variables passed through from the server's
+	// environment; SetEnv adds or overrides variables
+	Env    []string
+	SetEnv map[string]string
+
+	// Dir is the working directory
+	Dir string
+
+	// Cgroup is a cgroup v2 directory, relative to /sys/fs/cgroup unless
+	// absolute. Empty leaves the process in the server's cgroup.
+	Cgroup string
+}
+
+// cgroupRoot, when set, places every tool in <cgroupRoot>/<tool>
+var cgroupRoot string
+
+// DefaultDMSDir is injected as DMS_DIR for a2wcrecalc_dms
+const DefaultDMSDir = "/opt/compose/docker-mailserver"
+
+// defaultEnv is what every script gets from the server's environment
+var defaultEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "LANG", "LC_ALL", "TZ", "TERM"}
+
+// DefaultExecProfile applies to tools without a profile of their own. The
+// limits are generous for shell scripts but stop a runaway loop or leak
+// from taking the host down with it.
+var DefaultExecProfile = ExecProfile{
+	CPUSeconds:  15 * 60,
+	MemoryBytes: 2 << 30,
+	OpenFiles:   4096,
+	Nice:        5,
+	IOClass:     2,
+	IOPriority:  6,
+	Env:         defaultEnv,
+	Dir:         "/",
+}
+
+// execProfiles holds the tools whose built-in profile differs from
+// DefaultExecProfile. The profile settings of the config file's tools
+// section are applied on top.
+var execProfiles = map[string]func(p *ExecProfile){
+	// certbot and the wildcard scans are batch work; Apache comes first
+	"a2certrenew": func(p *ExecProfile) {
+		p.Nice = 10
+		p.IOPriority = 7
+	},
+	"a2wcrecalc": func(p *ExecProfile) {
+		p.Nice = 10
+		p.IOPriority = 7
+	},
+	"a2wcrecalc_dms": func(p *ExecProfile) {
+		p.Nice = 10
+		p.IOPriority = 7
+		p.SetEnv = map[string]string{"DMS_DIR": config().DMSDir}
+	},
+}
+
+// profileFor returns the execution profile of a tool
+func profileFor(tool string) ExecProfile {
+	p := DefaultExecProfile
+	if customize, ok := execProfiles[tool]; ok {
+		customize(&p)
+	}
+	if tc := config().Tools[tool]; tc != nil && tc.Profile != nil {
+		tc.Profile.apply(&p)
+	}
+	if p.Cgroup == "" && cgroupRoot != "" && tool != "" {
+		p.Cgroup = filepath.Join(cgroupRoot, tool)
+	}
+	return p
+}
+
+// apply overrides the settings of p that the config file sets
+func (c *ConfigProfile) apply(p *ExecProfile) {
+	if c.CPUSeconds != nil {
+		p.CPUSeconds = *c.CPUSeconds
+	}
+	if c.MemoryBytes != nil {
+		p.MemoryBytes = *c.MemoryBytes
+	}
+	if c.OpenFiles != nil {
+		p.OpenFiles = *c.OpenFiles
+	}
+	if c.Nice != nil {
+		p.Nice = *c.Nice
+	}
+	if c.IOClass != nil {
+		p.IOClass = *c.IOClass
+	}
+	if c.IOPriority != nil {
+		p.IOPriority = *c.IOPriority
+	}
+	if c.Env != nil {
+		p.Env = c.Env
+	}
+	if len(c.SetEnv) > 0 {
+		setEnv := maps.Clone(p.SetEnv)
+		if setEnv == nil {
+			setEnv = make(map[string]string, len(c.SetEnv))
+		}
+		maps.Copy(setEnv, c.SetEnv)
+		p.SetEnv = setEnv
+	}
+	if c.Dir != "" {
+		p.Dir = c.Dir
+	}
+	if c.Cgroup != "" {
+		p.Cgroup = c.Cgroup
+	}
+}
+
+// environ builds the child's environment from the allowlist and SetEnv
+func (p ExecProfile) environ() []string {
+	var env []string
+	for _, name := range p.Env {
+		if _, set := p.SetEnv[name]; set {
+			continue
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+v)
+		}
+	}
+	for name, v := range p.SetEnv {
+		env = append(env, name+"="+v)
+	}
+	return env
+}
+
+// wrap prefixes argv with nice, ionice and prlimit as the profile needs.
+// Each of them execs the next, so the limits are in place before the
+// script runs its first command and the process ID stays the same.
+func (p ExecProfile) wrap(argv []string) []string {
+	var prefix []string
+	if p.Nice != 0 && haveHelper("nice") {
+		prefix = append(prefix, "nice", "-n", fmt.Sprint(p.Nice))
+	}
+	if p.IOClass != 0 && haveHelper("ionice") {
+		prefix = append(prefix, "ionice", "-c", fmt.Sprint(p.IOClass))
+		if p.IOClass != 3 {
+			prefix = append(prefix, "-n", fmt.Sprint(p.IOPriority))
+		}
+	}
+	var limits []string
+	if p.CPUSeconds > 0 {
+		limits = append(limits, fmt.Sprintf("--cpu=%d", p.CPUSeconds))
+	}
+	if p.MemoryBytes > 0 {
+		limits = append(limits, fmt.Sprintf("--as=%d", p.MemoryBytes))
+	}
+	if p.OpenFiles > 0 {
+		limits = append(limits, fmt.Sprintf("--nofile=%d", p.OpenFiles))
+	}
+	if len(limits) > 0 && haveHelper("prlimit") {
+		prefix = append(prefix, "prlimit")
+		prefix = append(prefix, limits...)
+		prefix = append(prefix, "--")
+	}
+	return append(prefix, argv...)
+}
+
+var missingHelpers sync.Map
+
+// haveHelper reports whether a helper command is installed, warning once
+// if it is not
+func haveHelper(name string) bool {
+	if _, err := exec.LookPath(name); err == nil {
+		return true
+	}
+	if _, warned := missingHelpers.LoadOrStore(name, true); !warned {
+		fmt.Fprintf(os.Stderr, "Warning: %s not found, execution profiles are applied without it\n", name)
+	}
+	return false
+}
+
+// newCommand creates the command for a job spec with its tool's execution
+// profile applied. The returned cleanup must be called once the command
+// has started (or failed to).
+func newCommand(spec JobSpec) (*exec.Cmd, func(), error) {
+	p := profileFor(spec.Tool)
+
+	argv := p.wrap(append([]string{binaryPath(spec.Name)}, spec.Args...))
+	// The terminal hangs up when the server exits and closes its side;
+	// under nohup a script left running ignores the SIGHUP
+	if spec.PTY && haveHelper("nohup") {
+		argv = append([]string{"nohup"}, argv...)
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = p.environ()
+	cmd.Dir = p.Dir
+
+	cleanup := func() {}
+	if p.Cgroup != "" {
+		path := p.Cgroup
+		if !filepath.IsAbs(path) {
+			path = filepath.Join("/sys/fs/cgroup", path)
+		}
+		fd, err := placeInCgroup(cmd, path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cgroup %s: %v", path, err)
+		}
+		cleanup = func() { fd.Close() }
+	}
+
+	return cmd, cleanup, nil
+}
@@ -0,0 +1,44 @@
+//go:build linux
+
+package mcpserver
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// placeInCgroup makes cmd start inside the cgroup v2 directory at path,
+// creating it if needed. The returned directory must stay open until the
+// command has started.
+func placeInCgroup(cmd *exec.Cmd, path string) (*os.File, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(path, "cgroup.procs")); err != nil {
+		return nil, fmt.Errorf("not a cgroup v2 directory")
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+	return dir, nil
+}
+
+// setProcessGroup starts cmd in a process group of its own. A command
+// that gets a terminal already leads its own session and group.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if !cmd.SysProcAttr.Setsid {
+		cmd.SysProcAttr.Setpgid = true
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package mcpserver
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+func placeInCgroup(cmd *exec.Cmd, path string) (*os.File, error) {
+	return nil, errors.New("cgroups are only supported on Linux")
+}
+
+func setProcessGroup(cmd *exec.Cmd) {}
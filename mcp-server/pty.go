@@ -0,0 +1,89 @@
+package mcpserver
+
+import (
+	"io"
+	"regexp"
+	"strings"
+)
+
+// ptyEnabled turns PTY execution on for the tools in DefaultPTYTools
+var ptyEnabled = true
+
+// DefaultPTYTools lists the tools whose scripts write verbose output and
+// progress to /dev/tty (vecho, print_dns_wait_status, the ACME banners in
+// certify). They run with a pseudo-terminal as their controlling terminal
+// so that output ends up in the job log.
+var DefaultPTYTools = map[string]bool{
+	"a2sitemgr":                 true,
+	"a2certrenew":               true,
+	"fqdnmgr_check":             true,
+	"fqdnmgr_purchase":          true,
+	"fqdnmgr_list":              true,
+	"fqdnmgr_setInitDNSRecords": true,
+	"fqdnmgr_checkInitDns":      true,
+}
+
+// PTY window size; wide enough that status lines are not wrapped
+const (
+	ptyRows = 50
+	ptyCols = 200
+)
+
+// ansiEscape matches CSI, OSC and charset escape sequences
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[()][0-9A-Za-z]|\x1b[@-Z\\-_]`)
+
+// cursorUp matches the "move up" sequence scripts use to redraw the
+// previous status line
+var cursorUp = regexp.MustCompile(`\x1b\[[0-9]*A`)
+
+// normalizeTTYLine turns one line of terminal output into plain text. A
+// carriage return rewinds the line, so only the text after the last one
+// that is followed by something is kept. overwrite reports whether the
+// line redraws the line above it.
+func normalizeTTYLine(line string) (text string, overwrite bool) {
+	overwrite = cursorUp.MatchString(line)
+	line = strings.TrimRight(line, "\r")
+	if i := strings.LastIndex(line, "\r"); i >= 0 {
+		line = line[i+1:]
+	}
+	line = ansiEscape.ReplaceAllString(line, "")
+	return strings.TrimRight(line, " "), overwrite
+}
+
+// readTTY copies a job's terminal output into its output log until the
+// terminal is closed, then closes done
+func (j *Job) readTTY(master io.ReadCloser, done chan<- struct{}) {
+	defer close(done)
+	defer master.Close()
+
+	readLines(master, func(line string) {
+		text, overwrite := normalizeTTYLine(line)
+		if text == "" && !overwrite {
+			return
+		}
+		j.appendTTYLine(text, overwrite)
+	}, func(partial string) {
+		text, _ := normalizeTTYLine(partial)
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.outputSeenLocked(InputTerminal, text)
+	})
+	// Reading the master fails with EIO once the child side is closed;
+	// that is the normal end of output
+}
+
+// appendTTYLine adds a line of terminal output. A line that redraws the
+// previous one replaces it if that line also came from the terminal, so
+// progress displays leave one line in the log instead of hundreds.
+func (j *Job) appendTTYLine(line string, overwrite bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if overwrite && j.ttyLast && len(j.outputLines) > 0 {
+		j.outputLines[len(j.outputLines)-1] = line
+		j.notifyLocked()
+		return
+	}
+	j.appendOutputLocked(line)
+	j.ttyLast = true
+}
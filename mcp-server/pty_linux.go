@@ -0,0 +1,65 @@
+//go:build linux
+
+package mcpserver
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+// openPTY opens a new pseudo-terminal pair
+func openPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fd := master.Fd()
+	var unlock int32
+	if err := ioctl(fd, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unlocking pty: %v", err)
+	}
+	var n uint32
+	if err := ioctl(fd, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("getting pty number: %v", err)
+	}
+
+	ws := struct{ rows, cols, x, y uint16 }{ptyRows, ptyCols, 0, 0}
+	if err := ioctl(fd, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws))); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("setting pty size: %v", err)
+	}
+
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// attachTTY makes slave the controlling terminal of cmd in a new session.
+// stdin, stdout and stderr are left alone, so the terminal only receives
+// what the script writes to /dev/tty.
+func attachTTY(cmd *exec.Cmd, slave *os.File) {
+	cmd.ExtraFiles = append(cmd.ExtraFiles, slave)
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	// Ctty is a descriptor number in the child; ExtraFiles start at 3
+	cmd.SysProcAttr.Ctty = 2 + len(cmd.ExtraFiles)
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
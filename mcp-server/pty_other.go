@@ -0,0 +1,15 @@
+//go:build !linux
+
+package mcpserver
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errors.New("pseudo-terminals are only supported on Linux")
+}
+
+func attachTTY(cmd *exec.Cmd, slave *os.File) {}
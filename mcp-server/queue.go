@@ -0,0 +1,127 @@
+package mcpserver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultWorkers     = 4
+	DefaultJobDuration = 60 * time.Second
+)
+
+// DefaultToolConcurrency caps how many jobs of one tool may run at once.
+// Tools not listed are only limited by the worker pool.
+var DefaultToolConcurrency = map[string]int{
+	"fqdnmgr_purchase": 1,
+}
+
+// JobPriority orders queued jobs; higher values start first
+type JobPriority int
+
+const (
+	PriorityLow    JobPriority = -1
+	PriorityNormal JobPriority = 0
+	PriorityHigh   JobPriority = 1
+)
+
+// PriorityNames lists the accepted values of the priority argument
+var PriorityNames = []string{"low", "normal", "high"}
+
+func (p JobPriority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityHigh:
+		return "high"
+	default:
+		return "normal"
+	}
+}
+
+// parsePriority converts a priority argument into a JobPriority
+func parsePriority(s string) (JobPriority, error) {
+	switch strings.ToLower(s) {
+	case "", "normal":
+		return PriorityNormal, nil
+	case "low":
+		return PriorityLow, nil
+	case "high":
+		return PriorityHigh, nil
+	}
+	return PriorityNormal, fmt.Errorf("invalid priority %q (expected one of: %s)", s, strings.Join(PriorityNames, ", "))
+}
+
+// sortQueue orders jobs by priority, then by submission time
+func sortQueue(queue []*Job) {
+	sort.SliceStable(queue, func(i, k int) bool {
+		if queue[i].Spec.Priority != queue[k].Spec.Priority {
+			return queue[i].Spec.Priority > queue[k].Spec.Priority
+		}
+		return queue[i].QueueTime.Before(queue[k].QueueTime)
+	})
+}
+
+// expectedDuration returns the average runtime seen for a tool. Callers hold jm.mu.
+func (jm *JobQueue) expectedDuration(tool string) time.Duration {
+	if d, ok := jm.avgDuration[tool]; ok {
+		return d
+	}
+	return DefaultJobDuration
+}
+
+// recordDuration folds a finished job's runtime into the tool's average.
+// Callers hold jm.mu.
+func (jm *JobQueue) recordDuration(tool string, d time.Duration) {
+	if prev, ok := jm.avgDuration[tool]; ok {
+		// Exponential moving average, weighting recent runs
+		jm.avgDuration[tool] = (prev*7 + d*3) / 10
+		return
+	}
+	jm.avgDuration[tool] = d
+}
+
+// queueEstimate returns the 1-based queue position of a queued job and a
+// rough start time. The estimate simulates the worker pool: each worker is
+// busy for the expected remaining time of its running job, and every job
+// ahead in the queue takes the next free worker for its tool's average
+// runtime. Lock and per-tool limits are not modelled. Callers hold jm.mu.
+func (jm *JobQueue) queueEstimate(job *Job) (position int, start time.Time) {
+	now := time.Now()
+
+	slots := make([]time.Duration, 0, jm.workers)
+	for _, j := range jm.running {
+		if len(slots) == jm.workers {
+			break
+		}
+		remaining := jm.expectedDuration(j.Spec.Tool) - now.Sub(j.StartTime)
+		if remaining < 0 {
+			remaining = 0
+		}
+		slots = append(slots, remaining)
+	}
+	for len(slots) < jm.workers {
+		slots = append(slots, 0)
+	}
+
+	nextFree := func() int {
+		idx := 0
+		for i := range slots {
+			if slots[i] < slots[idx] {
+				idx = i
+			}
+		}
+		return idx
+	}
+
+	for i, j := range jm.queue {
+		idx := nextFree()
+		if j == job {
+			return i + 1, now.Add(slots[idx])
+		}
+		slots[idx] += jm.expectedDuration(j.Spec.Tool)
+	}
+	return 0, time.Time{}
+}
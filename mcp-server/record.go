@@ -0,0 +1,492 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Recording is one script run saved as a fixture file. Output, and the
+// points where input was sent, are kept as events in the order they
+// happened with the time since the start, so a replay waits for answers
+// where the original did.
+type Recording struct {
+	Tool string `json:"tool"`
+	Host string `json:"host,omitempty"`
+	// Argv is the command as requested, which replays are matched on;
+	// Command is what actually ran, with profile wrappers
+	Argv    []string `json:"argv"`
+	Command []string `json:"command,omitempty"`
+	Env     []string `json:"env,omitempty"`
+
+	StartedAt time.Time        `json:"startedAt"`
+	Events    []RecordingEvent `json:"events"`
+	// DurationMs is how long the script ran
+	DurationMs int64  `json:"durationMs"`
+	ExitCode   int    `json:"exitCode"`
+	Error      string `json:"error,omitempty"`
+}
+
+// RecordingEvent is a piece of output, or the point where input was sent
+type RecordingEvent struct {
+	AtMs int64 `json:"at"`
+	// Stream is stdout, stderr or tty for output, and stdin, stdin-eof
+	// or tty-in for input
+	Stream string `json:"stream"`
+	Data   string `json:"data,omitempty"`
+	// Base64 holds data that is not valid UTF-8
+	Base64 []byte `json:"base64,omitempty"`
+}
+
+func (e RecordingEvent) bytes() []byte {
+	if e.Base64 != nil {
+		return e.Base64
+	}
+	return []byte(e.Data)
+}
+
+func (e RecordingEvent) isInput() bool {
+	return e.Stream == "stdin" || e.Stream == "stdin-eof" || e.Stream == "tty-in"
+}
+
+// recordingArgv is what a request is recorded and matched as
+func recordingArgv(req ExecRequest) []string {
+	return append([]string{req.Name}, req.Args...)
+}
+
+// ==================== RECORDING ====================
+
+// Recorder saves every script run to a fixture directory
+type Recorder struct {
+	dir string
+	mu  sync.Mutex
+	seq int
+}
+
+// recorder is set by -record
+var recorder *Recorder
+
+// NewRecorder records into dir. Files are numbered after the ones
+// already there, so several sessions can go into one directory.
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	files, err := recordingFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{dir: dir}
+	for _, name := range files {
+		var n int
+		if _, err := fmt.Sscanf(filepath.Base(name), "%d-", &n); err == nil && n > r.seq {
+			r.seq = n
+		}
+	}
+	return r, nil
+}
+
+// wrap returns an executor that records what ex runs on host
+func (r *Recorder) wrap(host string, ex Executor) Executor {
+	return recordingExecutor{rec: r, host: host, inner: ex}
+}
+
+func (r *Recorder) save(rec *Recording) {
+	r.mu.Lock()
+	r.seq++
+	name := fmt.Sprintf("%04d-%s.json", r.seq, rec.Tool)
+	r.mu.Unlock()
+
+	if err := saveJSON(filepath.Join(r.dir, name), rec); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving recording %s: %v\n", name, err)
+	}
+}
+
+type recordingExecutor struct {
+	rec   *Recorder
+	host  string
+	inner Executor
+}
+
+// sessionLog collects a run's events
+type sessionLog struct {
+	mu     sync.Mutex
+	start  time.Time
+	events []RecordingEvent
+}
+
+func (l *sessionLog) add(stream string, p []byte) {
+	e := RecordingEvent{Stream: stream}
+	if utf8.Valid(p) {
+		e.Data = string(p)
+	} else {
+		e.Base64 = append([]byte(nil), p...)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.AtMs = time.Since(l.start).Milliseconds()
+	l.events = append(l.events, e)
+}
+
+func (x recordingExecutor) Start(req ExecRequest) (*Process, error) {
+	rec := &Recording{
+		Tool:      req.Tool,
+		Host:      x.host,
+		Argv:      recordingArgv(req),
+		StartedAt: time.Now().UTC(),
+	}
+	if x.host == "" {
+		rec.Env = profileFor(req.Tool).environ()
+		sort.Strings(rec.Env)
+	}
+	if req.TraceParent != "" {
+		rec.Env = append(rec.Env, "TRACEPARENT="+req.TraceParent)
+	}
+
+	log := &sessionLog{start: time.Now()}
+	proc, err := x.inner.Start(req)
+	if err != nil {
+		// A script that could not start is not worth replaying
+		return nil, err
+	}
+	rec.Command = proc.Argv
+
+	outDone := make(chan struct{})
+	errDone := make(chan struct{})
+	proc.Stdout = &recordReader{r: proc.Stdout, log: log, stream: "stdout", done: outDone}
+	proc.Stderr = &recordReader{r: proc.Stderr, log: log, stream: "stderr", done: errDone}
+	if proc.Stdin != nil {
+		proc.Stdin = recordWriter{w: proc.Stdin, log: log, stream: "stdin"}
+	}
+	if proc.TTY != nil {
+		proc.TTY = recordTTY{tty: proc.TTY, log: log}
+	}
+
+	wait := proc.wait
+	proc.wait = func() (int, error) {
+		exitCode, err := wait()
+		duration := time.Since(log.start)
+
+		// Let the caller take the last output before it is saved
+		grace := time.NewTimer(outputGrace)
+		defer grace.Stop()
+		for _, done := range []chan struct{}{outDone, errDone} {
+			select {
+			case <-done:
+			case <-grace.C:
+			}
+		}
+
+		log.mu.Lock()
+		rec.Events = append([]RecordingEvent(nil), log.events...)
+		log.mu.Unlock()
+		rec.DurationMs = duration.Milliseconds()
+		rec.ExitCode = exitCode
+		if err != nil {
+			rec.Error = err.Error()
+		}
+		x.rec.save(rec)
+		return exitCode, err
+	}
+	return proc, nil
+}
+
+// recordReader logs output as the caller reads it
+type recordReader struct {
+	r      io.Reader
+	log    *sessionLog
+	stream string
+	once   sync.Once
+	done   chan struct{}
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.log.add(r.stream, p[:n])
+	}
+	if err != nil {
+		r.once.Do(func() { close(r.done) })
+	}
+	return n, err
+}
+
+// recordWriter logs when input is sent. What was sent is left out, as
+// answers are often passwords, and a replay does not need them.
+type recordWriter struct {
+	w      io.WriteCloser
+	log    *sessionLog
+	stream string
+}
+
+func (w recordWriter) Write(p []byte) (int, error) {
+	w.log.add(w.stream, nil)
+	return w.w.Write(p)
+}
+
+func (w recordWriter) Close() error {
+	w.log.add("stdin-eof", nil)
+	return w.w.Close()
+}
+
+// recordTTY logs terminal output and when input was sent to it
+type recordTTY struct {
+	tty io.ReadWriteCloser
+	log *sessionLog
+}
+
+func (t recordTTY) Read(p []byte) (int, error) {
+	n, err := t.tty.Read(p)
+	if n > 0 {
+		t.log.add("tty", p[:n])
+	}
+	return n, err
+}
+
+func (t recordTTY) Write(p []byte) (int, error) {
+	t.log.add("tty-in", nil)
+	return t.tty.Write(p)
+}
+
+func (t recordTTY) Close() error {
+	return t.tty.Close()
+}
+
+// ==================== REPLAY ====================
+
+// Replayer serves recorded runs instead of running scripts. A request
+// gets the first unused recording with the same host and argv; once
+// they are used up, the last one is served again, so retries and
+// schedules keep working.
+type Replayer struct {
+	dir   string
+	speed float64
+
+	mu         sync.Mutex
+	recordings map[string][]*Recording
+	hosts      map[string]bool
+}
+
+// replayer is set by -replay
+var replayer *Replayer
+
+// NewReplayer loads the recordings in dir. speed divides the recorded
+// delays; 0 replays without any.
+func NewReplayer(dir string, speed float64) (*Replayer, error) {
+	files, err := recordingFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recordings in %s", dir)
+	}
+	r := &Replayer{dir: dir, speed: speed, recordings: make(map[string][]*Recording), hosts: make(map[string]bool)}
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		rec := &Recording{}
+		if err := json.Unmarshal(data, rec); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if len(rec.Argv) == 0 {
+			return nil, fmt.Errorf("%s: no argv", path)
+		}
+		key := replayKey(rec.Host, rec.Argv)
+		r.recordings[key] = append(r.recordings[key], rec)
+		if rec.Host != "" {
+			r.hosts[rec.Host] = true
+		}
+	}
+	return r, nil
+}
+
+// recordingFiles lists the fixture files in dir in recording order
+func recordingFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	sort.Strings(files)
+	return files, err
+}
+
+func replayKey(host string, argv []string) string {
+	return host + "\x00" + strings.Join(argv, "\x00")
+}
+
+// HasHost reports whether any recording ran on host
+func (r *Replayer) HasHost(host string) bool {
+	return r.hosts[host]
+}
+
+// forHost returns an executor that replays the recordings made on host
+func (r *Replayer) forHost(host string) Executor {
+	return replayExecutor{r: r, host: host}
+}
+
+func (r *Replayer) next(host string, argv []string) *Recording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := replayKey(host, argv)
+	queue := r.recordings[key]
+	if len(queue) == 0 {
+		return nil
+	}
+	if len(queue) > 1 {
+		r.recordings[key] = queue[1:]
+	}
+	return queue[0]
+}
+
+// delay scales a recorded delay by the replay speed
+func (r *Replayer) delay(ms int64) time.Duration {
+	if r.speed <= 0 {
+		return 0
+	}
+	return time.Duration(float64(ms) * float64(time.Millisecond) / r.speed)
+}
+
+type replayExecutor struct {
+	r    *Replayer
+	host string
+}
+
+func (x replayExecutor) Start(req ExecRequest) (*Process, error) {
+	argv := recordingArgv(req)
+	rec := x.r.next(x.host, argv)
+	if rec == nil {
+		where := ""
+		if x.host != "" {
+			where = " on " + x.host
+		}
+		return nil, fmt.Errorf("no recording of %q%s in %s", strings.Join(argv, " "), where, x.r.dir)
+	}
+
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	command := rec.Command
+	if len(command) == 0 {
+		command = rec.Argv
+	}
+	proc := &Process{Argv: command, Stdout: stdoutR, Stderr: stderrR}
+
+	input := newReplayInput()
+	if req.Stdin {
+		proc.Stdin = replayStdin{input}
+	}
+	var ttyW *io.PipeWriter
+	if req.PTY {
+		var ttyR *io.PipeReader
+		ttyR, ttyW = io.Pipe()
+		proc.TTY = replayTTY{PipeReader: ttyR, input: input}
+	}
+
+	done := make(chan struct{})
+	killed := make(chan struct{})
+	var killOnce sync.Once
+	proc.kill = func() error {
+		killOnce.Do(func() { close(killed) })
+		return nil
+	}
+	go func() {
+		defer close(done)
+		writers := map[string]*io.PipeWriter{"stdout": stdoutW, "stderr": stderrW, "tty": ttyW}
+		// Waiting for an answer shifts the rest of the recording
+		start := time.Now()
+	replay:
+		for i, e := range rec.Events {
+			if e.isInput() {
+				if i > 0 && rec.Events[i-1].isInput() {
+					continue
+				}
+				if proc.Stdin != nil || proc.TTY != nil {
+					select {
+					case <-input.sent:
+					case <-input.closed:
+					case <-killed:
+						break replay
+					}
+					start = time.Now().Add(-x.r.delay(e.AtMs))
+				}
+				continue
+			}
+			select {
+			case <-time.After(time.Until(start.Add(x.r.delay(e.AtMs)))):
+			case <-killed:
+				break replay
+			}
+			if w := writers[e.Stream]; w != nil {
+				w.Write(e.bytes())
+			}
+		}
+		select {
+		case <-time.After(time.Until(start.Add(x.r.delay(rec.DurationMs)))):
+		case <-killed:
+		}
+		stdoutW.Close()
+		stderrW.Close()
+		if ttyW != nil {
+			ttyW.Close()
+		}
+	}()
+
+	proc.wait = func() (int, error) {
+		<-done
+		select {
+		case <-killed:
+			return -1, nil
+		default:
+		}
+		if rec.Error != "" {
+			return rec.ExitCode, errors.New(rec.Error)
+		}
+		return rec.ExitCode, nil
+	}
+	return proc, nil
+}
+
+// replayInput notes input sent to a replayed script, which only matters
+// as the signal to carry on past a recorded prompt
+type replayInput struct {
+	sent   chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newReplayInput() *replayInput {
+	return &replayInput{sent: make(chan struct{}, 1), closed: make(chan struct{})}
+}
+
+func (in *replayInput) write(p []byte) (int, error) {
+	select {
+	case in.sent <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+func (in *replayInput) close() error {
+	in.once.Do(func() { close(in.closed) })
+	return nil
+}
+
+type replayStdin struct{ in *replayInput }
+
+func (s replayStdin) Write(p []byte) (int, error) { return s.in.write(p) }
+func (s replayStdin) Close() error                { return s.in.close() }
+
+type replayTTY struct {
+	*io.PipeReader
+	input *replayInput
+}
+
+func (t replayTTY) Write(p []byte) (int, error) {
+	return t.input.write(p)
+}
@@ -0,0 +1,148 @@
+package mcpserver
+
+import (
+	"strings"
+	"testing"
+)
+
+// useReplayer serves the fixtures in testdata/replay instead of running
+// scripts, with a fresh job queue, for the rest of the test
+func useReplayer(t *testing.T) {
+	t.Helper()
+	r, err := NewReplayer("testdata/replay", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldReplayer, oldJobMgr := replayer, jobMgr
+	replayer, jobMgr = r, NewJobQueue(2, nil)
+	t.Cleanup(func() { replayer, jobMgr = oldReplayer, oldJobMgr })
+}
+
+// callReplayed calls a tool and, for an async tool, waits for its job. It
+// returns the text of the result or the job status and whether it failed.
+func callReplayed(t *testing.T, tool string, args map[string]any) (string, bool) {
+	t.Helper()
+	result := ExecuteTool(tool, args)
+	job, ok := result.StructuredContent.(*jobContent)
+	if !ok {
+		var text strings.Builder
+		for _, c := range result.Content {
+			text.WriteString(c.Text)
+		}
+		return text.String(), result.IsError
+	}
+
+	<-jobMgr.Done(job.JobID)
+	info, found := jobMgr.GetJobStatus(job.JobID)
+	if !found {
+		t.Fatalf("job %s not found", job.JobID)
+	}
+	return formatJobStatus(info), info.Status == JobStatusFailed
+}
+
+func TestReplayedTools(t *testing.T) {
+	useReplayer(t)
+
+	tests := []struct {
+		script  string
+		tool    string
+		args    map[string]any
+		wantErr bool
+		want    []string
+	}{
+		{
+			script: "fqdnmgr",
+			tool:   "fqdnmgr_check",
+			args:   map[string]any{"fqdn": "example.com"},
+			want:   []string{"status=owned registrar=namecheap.com"},
+		},
+		{
+			script: "fqdnmgr",
+			tool:   "list_domains",
+			args:   map[string]any{},
+			want: []string{
+				"example.com  owned at namecheap.com, DNS initialized, certificate expires 2026-11-30",
+				"example.org  available, no certificate",
+			},
+		},
+		{
+			script: "fqdnmgr",
+			tool:   "fqdnmgr_checkInitDns",
+			args:   map[string]any{"fqdn": "example.com"},
+			want:   []string{"not yet propagated", "DNS not fully propagated. Check again in 60 seconds."},
+		},
+		{
+			script: "a2sitemgr",
+			tool:   "a2sitemgr",
+			args:   map[string]any{"fqdn": "example.com"},
+			want: []string{
+				"Status: completed",
+				"Configuring example.com (domain mode)",
+				"Enabled site example.com and reloaded Apache",
+			},
+		},
+		{
+			script:  "a2certrenew",
+			tool:    "a2certrenew",
+			args:    map[string]any{},
+			wantErr: true,
+			want:    []string{"Status: failed", "Exit Code: 1", "Error: certbot failed for example.com"},
+		},
+		{
+			script: "a2wcrecalc",
+			tool:   "a2wcrecalc",
+			args:   map[string]any{},
+			want:   []string{"Wildcard subdomains for example.com: app, mail"},
+		},
+		{
+			script: "a2wcrecalc-dms",
+			tool:   "a2wcrecalc_dms",
+			args:   map[string]any{},
+			want:   []string{"Total domains mapped: 2"},
+		},
+		{
+			script: "fqdncredmgr",
+			tool:   "fqdncredmgr_list",
+			args:   map[string]any{},
+			want:   []string{"namecheap.com\tjo***oe"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.script+"/"+tt.tool, func(t *testing.T) {
+			text, failed := callReplayed(t, tt.tool, tt.args)
+			if failed != tt.wantErr {
+				t.Errorf("failed = %v, want %v\n%s", failed, tt.wantErr, text)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(text, want) {
+					t.Errorf("result does not contain %q:\n%s", want, text)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayerWithoutRecording(t *testing.T) {
+	useReplayer(t)
+
+	text, failed := callReplayed(t, "fqdnmgr_check", map[string]any{"fqdn": "unrecorded.example"})
+	if !failed || !strings.Contains(text, "no recording of") {
+		t.Errorf("got failed = %v, %q; want an error naming the missing recording", failed, text)
+	}
+}
+
+func TestReplayerServesLastRecordingAgain(t *testing.T) {
+	r, err := NewReplayer("testdata/replay", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	argv := []string{"fqdncredmgr", "list"}
+	first, second := r.next("", argv), r.next("", argv)
+	if first == nil || first != second {
+		t.Errorf("next returned %p then %p, want the same recording twice", first, second)
+	}
+	if r.next("web1", argv) != nil {
+		t.Error("a recording made locally was served for host web1")
+	}
+}
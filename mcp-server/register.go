@@ -0,0 +1,221 @@
+package mcpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ToolHandler runs a tool registered with Server.RegisterTool. It is
+// called synchronously from the tool call, so long-running work should
+// be started as a job instead.
+type ToolHandler interface {
+	CallTool(args map[string]any) ToolCallResult
+}
+
+// ToolFunc adapts a function taking the raw arguments to ToolHandler
+type ToolFunc func(args map[string]any) ToolCallResult
+
+func (f ToolFunc) CallTool(args map[string]any) ToolCallResult {
+	return f(args)
+}
+
+// TypedTool returns a handler that decodes the arguments into A, a struct
+// whose json tags name the arguments, and reports fn's error as a failed
+// call. Registered without an input schema, the tool gets one built from
+// A: fields without omitempty are required, and the description and enum
+// (comma-separated) tags fill in the rest.
+func TypedTool[A any](fn func(args A) (string, error)) ToolHandler {
+	return typedTool[A](fn)
+}
+
+type typedTool[A any] func(args A) (string, error)
+
+func (fn typedTool[A]) CallTool(args map[string]any) ToolCallResult {
+	var a A
+	if err := decodeArgs(args, &a); err != nil {
+		return errorResult(fmt.Sprintf("Invalid arguments: %v", err))
+	}
+	text, err := fn(a)
+	if err != nil {
+		return errorResult(err.Error())
+	}
+	return textResult(text)
+}
+
+func (typedTool[A]) inputSchema() (InputSchema, error) {
+	return schemaFor(reflect.TypeOf((*A)(nil)).Elem())
+}
+
+// decodeArgs decodes a call's arguments into v, refusing unknown ones.
+// Internal arguments, such as the trace context, are left out.
+func decodeArgs(args map[string]any, v any) error {
+	public := make(map[string]any, len(args))
+	for k, val := range args {
+		if !strings.HasPrefix(k, "_") {
+			public[k] = val
+		}
+	}
+	data, err := json.Marshal(public)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// schemaFor builds an input schema from an argument struct
+func schemaFor(t reflect.Type) (InputSchema, error) {
+	if t.Kind() != reflect.Struct {
+		return InputSchema{}, fmt.Errorf("arguments must be a struct, not %s", t)
+	}
+	schema := InputSchema{Type: "object", Properties: map[string]Property{}}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		prop, err := propertyFor(f.Type)
+		if err != nil {
+			return InputSchema{}, fmt.Errorf("%s: %v", name, err)
+		}
+		prop.Description = f.Tag.Get("description")
+		if enum := f.Tag.Get("enum"); enum != "" {
+			prop.Enum = strings.Split(enum, ",")
+		}
+		schema.Properties[name] = prop
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema, nil
+}
+
+// propertyFor maps a Go type to its JSON schema type
+func propertyFor(t reflect.Type) (Property, error) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		// Times encode as RFC 3339 strings
+		return Property{Type: "string"}, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return Property{Type: "string"}, nil
+	case reflect.Bool:
+		return Property{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Property{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return Property{Type: "number"}, nil
+	case reflect.Slice:
+		items, err := propertyFor(t.Elem())
+		if err != nil {
+			return Property{}, err
+		}
+		return Property{Type: "array", Items: &items}, nil
+	case reflect.Map:
+		return Property{Type: "object"}, nil
+	case reflect.Struct:
+		nested, err := schemaFor(t)
+		if err != nil {
+			return Property{}, err
+		}
+		return Property{Type: "object", Properties: nested.Properties, Required: nested.Required}, nil
+	}
+	return Property{}, fmt.Errorf("unsupported type %s", t)
+}
+
+type registeredTool struct {
+	tool    Tool
+	handler ToolHandler
+}
+
+var (
+	registryMu sync.RWMutex
+	// registry holds the tools registered by an embedding program, in
+	// registration order
+	registry []registeredTool
+)
+
+// registeredTools returns the registered tools' definitions
+func registeredTools() []Tool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	tools := make([]Tool, len(registry))
+	for i, r := range registry {
+		tools[i] = r.tool
+	}
+	return tools
+}
+
+// registeredHandler returns the handler of a registered tool, or nil
+func registeredHandler(name string) (Tool, ToolHandler) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	for _, r := range registry {
+		if r.tool.Name == name {
+			return r.tool, r.handler
+		}
+	}
+	return Tool{}, nil
+}
+
+// callRegistered runs a registered tool after checking its required
+// arguments
+func callRegistered(tool Tool, handler ToolHandler, args map[string]any) ToolCallResult {
+	for _, name := range tool.InputSchema.Required {
+		if _, ok := args[name]; !ok {
+			return errorResult(fmt.Sprintf("%s is required", name))
+		}
+	}
+	return handler.CallTool(args)
+}
+
+// RegisterTool adds a tool to the server. def names and describes it; if
+// def has no input schema, a handler from TypedTool supplies one. Tools
+// can be registered while serving: clients are told the list changed.
+func (s *Server) RegisterTool(def Tool, handler ToolHandler) error {
+	if def.Name == "" || handler == nil {
+		return fmt.Errorf("a tool needs a name and a handler")
+	}
+	if def.InputSchema.Type == "" {
+		typed, ok := handler.(interface{ inputSchema() (InputSchema, error) })
+		if !ok {
+			return fmt.Errorf("%s: no input schema", def.Name)
+		}
+		schema, err := typed.inputSchema()
+		if err != nil {
+			return fmt.Errorf("%s: %v", def.Name, err)
+		}
+		def.InputSchema = schema
+	}
+
+	for _, t := range GetAllTools() {
+		if t.Name == def.Name {
+			return fmt.Errorf("%s is already a tool", def.Name)
+		}
+	}
+	registryMu.Lock()
+	registry = append(registry, registeredTool{tool: def, handler: handler})
+	registryMu.Unlock()
+
+	notifyToolsChanged()
+	return nil
+}
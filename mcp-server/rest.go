@@ -0,0 +1,259 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// restResult is a tool call's result in the REST API
+type restResult struct {
+	// Text is the result as MCP clients see it
+	Text string `json:"text"`
+	// Result is the structured result, when the tool gives one
+	Result any `json:"result,omitempty"`
+}
+
+// restError is the body of every REST API error
+type restError struct {
+	Error string `json:"error"`
+}
+
+// HTTPHandler serves the REST API: each tool as POST /v1/tools/{name},
+// jobs under /v1/jobs/{id}, and the OpenAPI document describing them at
+// /v1/openapi.json. Calls need a bearer token from the auth section of
+// the configuration, and are authorized and audited like MCP calls.
+func (s *Server) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, openAPIDocument())
+	})
+	mux.HandleFunc("/v1/tools", withCaller(handleRESTTools))
+	mux.HandleFunc("/v1/tools/", withCaller(handleRESTToolCall))
+	mux.HandleFunc("/v1/jobs/", withCaller(handleRESTJob))
+	return mux
+}
+
+// ServeREST serves the REST API on addr until the process exits
+func (s *Server) ServeREST(addr string) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.HTTPHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil {
+			fmt.Fprintf(os.Stderr, "REST API server: %v\n", err)
+		}
+	}()
+}
+
+// withCaller authenticates the request's bearer token before handling it
+func withCaller(h func(http.ResponseWriter, *http.Request, Caller)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		caller, known := tokenCaller(strings.TrimSpace(token))
+		if !ok || !known {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="a2cmds-mcp"`)
+			writeJSON(w, http.StatusUnauthorized, restError{"missing or unknown bearer token"})
+			return
+		}
+		h(w, r, caller)
+	}
+}
+
+// handleRESTTools lists the tools the caller may call
+func handleRESTTools(w http.ResponseWriter, r *http.Request, caller Caller) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	tools := []Tool{}
+	for _, tool := range GetAllTools() {
+		if toolEnabled(tool.Name) && authorize(caller, tool.Name, nil) == nil {
+			tools = append(tools, tool)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tools": tools})
+}
+
+// handleRESTToolCall calls the tool named in the path with the request
+// body as its arguments
+func handleRESTToolCall(w http.ResponseWriter, r *http.Request, caller Caller) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/v1/tools/")
+	tool, ok := enabledTool(name)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, restError{fmt.Sprintf("unknown tool %q", name)})
+		return
+	}
+	args, err := readArguments(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, restError{err.Error()})
+		return
+	}
+
+	result, status := restCall(w, r, caller, name, args)
+	if result == nil {
+		return
+	}
+	if status == http.StatusOK && startsJob(tool) {
+		if job, ok := result.Result.(*jobContent); ok {
+			w.Header().Set("Location", "/v1/jobs/"+job.JobID)
+			status = http.StatusAccepted
+		}
+	}
+	writeJSON(w, status, result)
+}
+
+// handleRESTJob serves GET /v1/jobs/{id}, which waits like wait_job when
+// given wait or pattern, and POST /v1/jobs/{id}/input
+func handleRESTJob(w http.ResponseWriter, r *http.Request, caller Caller) {
+	jobID, input := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/v1/jobs/"), "/input")
+	if jobID == "" || strings.Contains(jobID, "/") {
+		writeJSON(w, http.StatusNotFound, restError{"not found"})
+		return
+	}
+
+	tool := "check_job_status"
+	var args map[string]any
+	if input {
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		var err error
+		if args, err = readArguments(r); err != nil {
+			writeJSON(w, http.StatusBadRequest, restError{err.Error()})
+			return
+		}
+		tool = "send_job_input"
+	} else {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		args = map[string]any{}
+		q := r.URL.Query()
+		if p := q.Get("pattern"); p != "" {
+			if _, err := regexp.Compile(p); err != nil {
+				writeJSON(w, http.StatusBadRequest, restError{fmt.Sprintf("invalid pattern: %v", err)})
+				return
+			}
+			args["pattern"] = p
+			tool = "wait_job"
+		}
+		if v := q.Get("wait"); v != "" {
+			secs, err := strconv.Atoi(v)
+			if err != nil || secs < 1 {
+				writeJSON(w, http.StatusBadRequest, restError{"wait must be a positive number of seconds"})
+				return
+			}
+			args["maxWaitSeconds"] = float64(secs)
+			tool = "wait_job"
+		}
+	}
+	args["jobId"] = jobID
+
+	result, status := restCall(w, r, caller, tool, args)
+	if result == nil {
+		return
+	}
+	if status != http.StatusOK {
+		// The job tools fail only for jobs they cannot find or answer
+		status = http.StatusNotFound
+		if input {
+			status = http.StatusConflict
+		}
+	}
+	writeJSON(w, status, result)
+}
+
+// restCall makes a tool call for a REST request. It writes the response
+// itself and returns nil when the call was not made; otherwise it returns
+// the result with 200, or 422 if the tool reported an error.
+func restCall(w http.ResponseWriter, r *http.Request, caller Caller, tool string, args map[string]any) (*restResult, int) {
+	if !beginToolCall() {
+		writeJSON(w, http.StatusServiceUnavailable, restError{"server is shutting down"})
+		return nil, 0
+	}
+	defer inflight.Done()
+
+	parent, _ := parseTraceparent(r.Header.Get("traceparent"))
+	result, err := callTool(caller, tool, args, parent)
+	if err != nil {
+		writeJSON(w, http.StatusForbidden, restError{err.Error()})
+		return nil, 0
+	}
+
+	var text strings.Builder
+	for _, block := range result.Content {
+		text.WriteString(block.Text)
+	}
+	res := &restResult{Text: text.String(), Result: result.StructuredContent}
+	if result.IsError {
+		return res, http.StatusUnprocessableEntity
+	}
+	return res, http.StatusOK
+}
+
+// readArguments decodes a request body holding a JSON object. An empty
+// body is no arguments.
+func readArguments(r *http.Request) (map[string]any, error) {
+	args := map[string]any{}
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1024*1024))
+	if err := dec.Decode(&args); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("the body must be a JSON object of arguments: %v", err)
+	}
+	if args == nil {
+		args = map[string]any{}
+	}
+	return args, nil
+}
+
+// enabledTool returns the enabled tool called name
+func enabledTool(name string) (Tool, bool) {
+	if !toolEnabled(name) {
+		return Tool{}, false
+	}
+	for _, tool := range GetAllTools() {
+		if tool.Name == name {
+			return tool, true
+		}
+	}
+	return Tool{}, false
+}
+
+// startsJob reports whether a tool starts a job; every one takes a
+// priority
+func startsJob(tool Tool) bool {
+	_, ok := tool.InputSchema.Properties["priority"]
+	return ok
+}
+
+// allowMethod answers 405 unless the request uses method
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeJSON(w, http.StatusMethodNotAllowed, restError{fmt.Sprintf("use %s", method)})
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
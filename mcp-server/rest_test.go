@@ -0,0 +1,94 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// restRequest makes a request to the REST API with token as the bearer
+// token, if given, and returns the status and the decoded body
+func restRequest(t *testing.T, h http.Handler, method, path, token, body string) (int, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var out map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("%s %s: body is not JSON: %q", method, path, rec.Body.String())
+	}
+	if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("%s %s: 401 without WWW-Authenticate", method, path)
+	}
+	return rec.Code, out
+}
+
+// restJobID starts a job through the REST API and waits for it
+func restJobID(t *testing.T, h http.Handler, token, tool string) string {
+	t.Helper()
+	status, body := restRequest(t, h, http.MethodPost, "/v1/tools/"+tool, token, "{}")
+	result, _ := body["result"].(map[string]any)
+	jobID, _ := result["jobId"].(string)
+	if status != http.StatusAccepted || jobID == "" {
+		t.Fatalf("POST %s = %d %v, want 202 with a job", tool, status, body)
+	}
+	<-jobMgr.Done(jobID)
+	return jobID
+}
+
+func TestRESTTokens(t *testing.T) {
+	useAuth(t)
+	useReplayer(t)
+	h := (&Server{}).HTTPHandler()
+
+	ciJob := restJobID(t, h, "ci-token", "a2certrenew")
+	opsJob := restJobID(t, h, "ops-token", "a2certrenew")
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"no token", http.MethodGet, "/v1/tools", "", "", http.StatusUnauthorized},
+		{"unknown token", http.MethodGet, "/v1/tools", "other-token", "", http.StatusUnauthorized},
+		{"hash of a token", http.MethodGet, "/v1/tools", sha256Hex("ci-token"), "", http.StatusUnauthorized},
+		{"allowed tool", http.MethodPost, "/v1/tools/fqdnmgr_check", "ci-token", `{"fqdn": "example.com"}`, http.StatusOK},
+		{"tool the token may not call", http.MethodPost, "/v1/tools/fqdnmgr_purchase", "ci-token", `{"fqdn": "example.com"}`, http.StatusForbidden},
+		{"admin token", http.MethodPost, "/v1/tools/fqdnmgr_check", "ops-token", `{"fqdn": "example.com"}`, http.StatusOK},
+		{"own job", http.MethodGet, "/v1/jobs/" + ciJob, "ci-token", "", http.StatusOK},
+		{"another token's job", http.MethodGet, "/v1/jobs/" + opsJob, "ci-token", "", http.StatusNotFound},
+		{"admin reads any job", http.MethodGet, "/v1/jobs/" + ciJob, "ops-token", "", http.StatusOK},
+		{"wrong method", http.MethodGet, "/v1/tools/fqdnmgr_check", "ci-token", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := restRequest(t, h, tt.method, tt.path, tt.token, tt.body)
+			if status != tt.wantStatus {
+				t.Errorf("%s %s = %d, want %d: %v", tt.method, tt.path, status, tt.wantStatus, body)
+			}
+		})
+	}
+
+	// The tool list holds only what the token may call
+	_, body := restRequest(t, h, http.MethodGet, "/v1/tools", "ci-token", "")
+	tools, _ := body["tools"].([]any)
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.(map[string]any)["name"].(string))
+	}
+	sort.Strings(names)
+	want := "a2certrenew,cancel_job,check_job_status,fqdnmgr_check,list_jobs"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("ci lists tools %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,173 @@
+package mcpserver
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// RetryPolicy says when a failed job is rerun and how long to wait first
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// A failure is retryable if its exit code is listed or its stderr
+	// matches one of the patterns. Stdout is not matched: normal output
+	// can contain anything, such as a record or a count of 503.
+	RetryExitCodes []int
+	RetryPatterns  []*regexp.Regexp
+}
+
+// transientFailurePatterns match registrar throttling and network blips
+// as the provider scripts and curl report them on stderr. Status codes
+// only count after "HTTP" or "status", as in "HTTP/1.1 503" or
+// "status: 429".
+var transientFailurePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)rate limit|too many requests`),
+	regexp.MustCompile(`(?i)\b(HTTP(/[0-9.]+)?|status( code)?)[ :=]+(429|50[234])\b`),
+	regexp.MustCompile(`(?i)service unavailable|bad gateway|gateway time-?out`),
+	regexp.MustCompile(`(?i)could not resolve host|connection (refused|reset|timed out)`),
+	regexp.MustCompile(`(?i)operation timed out|temporary failure in name resolution`),
+}
+
+// curl exit codes for DNS, connect, timeout and empty/failed receive
+var transientExitCodes = []int{6, 7, 28, 52, 56}
+
+// DefaultRetryPolicies lists the tools that are safe to rerun. Only
+// idempotent tools belong here; fqdnmgr_purchase must never be retried
+// because a second run can buy the domain twice. The tools section of
+// the configuration file can change them; see ConfigRetry.
+var DefaultRetryPolicies = map[string]RetryPolicy{
+	"fqdnmgr_setInitDNSRecords": {
+		MaxAttempts:    3,
+		InitialBackoff: 30 * time.Second,
+		MaxBackoff:     5 * time.Minute,
+		RetryExitCodes: transientExitCodes,
+		RetryPatterns:  transientFailurePatterns,
+	},
+	"a2certrenew": {
+		MaxAttempts:    3,
+		InitialBackoff: time.Minute,
+		MaxBackoff:     10 * time.Minute,
+		RetryExitCodes: transientExitCodes,
+		RetryPatterns:  transientFailurePatterns,
+	},
+}
+
+// JobAttempt records one run of a job's command
+type JobAttempt struct {
+	Number     int
+	StartTime  time.Time
+	EndTime    time.Time
+	ExitCode   int
+	StderrTail string
+
+	// Retry says whether another attempt was scheduled, and Reason why or why not
+	Retry  bool
+	Reason string
+}
+
+// neverRetried are the tools no configuration may retry
+var neverRetried = map[string]bool{
+	"fqdnmgr_purchase": true,
+}
+
+// retryPolicyFor returns a copy of the tool's retry policy with the
+// configured changes, or nil if the tool is not retried
+func retryPolicyFor(tool string) *RetryPolicy {
+	if neverRetried[tool] {
+		return nil
+	}
+	p, ok := DefaultRetryPolicies[tool]
+	var rc *ConfigRetry
+	if tc := config().Tools[tool]; tc != nil {
+		rc = tc.Retry
+	}
+	if rc == nil {
+		if !ok {
+			return nil
+		}
+		return &p
+	}
+
+	if !ok {
+		p = RetryPolicy{
+			MaxAttempts:    1,
+			InitialBackoff: 30 * time.Second,
+			MaxBackoff:     5 * time.Minute,
+			RetryExitCodes: transientExitCodes,
+			RetryPatterns:  transientFailurePatterns,
+		}
+	}
+	if rc.MaxAttempts > 0 {
+		p.MaxAttempts = rc.MaxAttempts
+	}
+	if rc.InitialBackoff > 0 {
+		p.InitialBackoff = rc.InitialBackoff
+	}
+	if rc.MaxBackoff > 0 {
+		p.MaxBackoff = rc.MaxBackoff
+	}
+	if rc.ExitCodes != nil {
+		p.RetryExitCodes = rc.ExitCodes
+	}
+	if rc.patterns != nil {
+		p.RetryPatterns = rc.patterns
+	}
+	if p.MaxAttempts <= 1 {
+		return nil
+	}
+	return &p
+}
+
+// shouldRetry decides whether a failed attempt is rerun
+func (p *RetryPolicy) shouldRetry(attempt, exitCode int, stderr string) (bool, string) {
+	if p == nil {
+		return false, ""
+	}
+
+	reason := ""
+	for _, code := range p.RetryExitCodes {
+		if exitCode == code {
+			reason = fmt.Sprintf("exit code %d is retryable", exitCode)
+			break
+		}
+	}
+	if reason == "" {
+		for _, re := range p.RetryPatterns {
+			if m := re.FindString(stderr); m != "" {
+				reason = fmt.Sprintf("stderr matched %q", m)
+				break
+			}
+		}
+	}
+
+	if reason == "" {
+		return false, "failure is not retryable"
+	}
+	if attempt >= p.MaxAttempts {
+		return false, reason + ", but no attempts left"
+	}
+	return true, reason
+}
+
+// backoff returns the delay before the given attempt number (2, 3, ...)
+func (p *RetryPolicy) backoff(nextAttempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 2; i < nextAttempt; i++ {
+		d *= 2
+		if d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return d
+}
+
+// tail returns at most the last n bytes of s
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return "..." + s[len(s)-n:]
+}
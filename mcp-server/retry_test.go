@@ -0,0 +1,85 @@
+package mcpserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShouldRetry(t *testing.T) {
+	p := DefaultRetryPolicies["a2certrenew"]
+
+	tests := []struct {
+		attempt  int
+		exitCode int
+		stderr   string
+		want     bool
+	}{
+		{1, 6, "", true},
+		{1, 28, "", true},
+		{1, 1, "Error: HTTP/1.1 503 Service Unavailable", true},
+		{1, 1, "status: 429", true},
+		{1, 1, "rate limit exceeded, try later", true},
+		{1, 1, "curl: (7) Connection refused", true},
+		{2, 1, "Temporary failure in name resolution", true},
+		{1, 1, "Error: certbot failed for example.com", false},
+		{1, 1, "found 503 records", false},
+		{1, 2, "", false},
+		{3, 6, "", false},
+		{3, 1, "too many requests", false},
+	}
+
+	for _, tt := range tests {
+		got, reason := p.shouldRetry(tt.attempt, tt.exitCode, tt.stderr)
+		if got != tt.want {
+			t.Errorf("shouldRetry(%d, %d, %q) = %v (%s), want %v", tt.attempt, tt.exitCode, tt.stderr, got, reason, tt.want)
+		}
+	}
+
+	var none *RetryPolicy
+	if got, _ := none.shouldRetry(1, 6, ""); got {
+		t.Error("a nil policy retried")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: 30 * time.Second, MaxBackoff: 5 * time.Minute}
+
+	tests := []struct {
+		nextAttempt int
+		want        time.Duration
+	}{
+		{2, 30 * time.Second},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 5 * time.Minute},
+		{20, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := p.backoff(tt.nextAttempt); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.nextAttempt, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyFor(t *testing.T) {
+	tests := []struct {
+		tool         string
+		wantAttempts int
+	}{
+		{"a2certrenew", 3},
+		{"fqdnmgr_setInitDNSRecords", 3},
+		{"fqdnmgr_check", 0},
+		{"fqdnmgr_purchase", 0},
+	}
+	for _, tt := range tests {
+		p := retryPolicyFor(tt.tool)
+		got := 0
+		if p != nil {
+			got = p.MaxAttempts
+		}
+		if got != tt.wantAttempts {
+			t.Errorf("retryPolicyFor(%s) allows %d attempts, want %d", tt.tool, got, tt.wantAttempts)
+		}
+	}
+}
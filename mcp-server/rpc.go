@@ -0,0 +1,361 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// JSON-RPC 2.0 structures
+type JSONRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      interface{}     `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type JSONRPCResponse struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      interface{}   `json:"id,omitempty"`
+	Result  interface{}   `json:"result,omitempty"`
+	Error   *JSONRPCError `json:"error,omitempty"`
+}
+
+type JSONRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// MCP Protocol structures
+type InitializeParams struct {
+	ProtocolVersion string         `json:"protocolVersion"`
+	Capabilities    map[string]any `json:"capabilities"`
+	ClientInfo      ClientInfo     `json:"clientInfo"`
+}
+
+type ClientInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type InitializeResult struct {
+	ProtocolVersion string           `json:"protocolVersion"`
+	Capabilities    ServerCapability `json:"capabilities"`
+	ServerInfo      ServerInfo       `json:"serverInfo"`
+}
+
+type ServerCapability struct {
+	Tools     *ToolsCapability     `json:"tools,omitempty"`
+	Resources *ResourcesCapability `json:"resources,omitempty"`
+}
+
+type ToolsCapability struct {
+	ListChanged bool `json:"listChanged,omitempty"`
+}
+
+type ResourcesCapability struct {
+	Subscribe   bool `json:"subscribe,omitempty"`
+	ListChanged bool `json:"listChanged,omitempty"`
+}
+
+type ServerInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type ToolsListResult struct {
+	Tools []Tool `json:"tools"`
+}
+
+type ToolCallParams struct {
+	Name      string         `json:"name"`
+	Arguments map[string]any `json:"arguments,omitempty"`
+}
+
+type ToolCallResult struct {
+	Content []ContentBlock `json:"content"`
+	IsError bool           `json:"isError,omitempty"`
+	// StructuredContent is the result as JSON, for programs
+	StructuredContent any `json:"structuredContent,omitempty"`
+}
+
+type ContentBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+type ResourcesListResult struct {
+	Resources []Resource `json:"resources"`
+}
+
+type ResourceReadParams struct {
+	URI string `json:"uri"`
+}
+
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text"`
+}
+
+type ResourceReadResult struct {
+	Contents []ResourceContents `json:"contents"`
+}
+
+// Global job manager and scheduler, set up by NewServer
+var (
+	jobMgr    JobManager
+	scheduler *Scheduler
+)
+
+func handleRequest(sess *session, req *JSONRPCRequest) {
+	// tools/call starts its own span once it knows the tool's name
+	if req.Method != "tools/call" {
+		span := StartSpan("rpc "+req.Method, requestTraceParent(req.Params), time.Now())
+		span.SetAttr("rpc.method", req.Method)
+		defer span.End()
+	}
+
+	switch req.Method {
+	case "initialize":
+		handleInitialize(sess, req)
+	case "initialized", "notifications/initialized":
+		// Notification, no response needed
+	case "tools/list":
+		handleToolsList(sess, req)
+	case "tools/call":
+		// Tool calls may block (sync scripts, wait_job), so they run
+		// concurrently and respond out of order
+		if !beginToolCall() {
+			sess.sendError(req.ID, -32000, "Server is shutting down", nil)
+			return
+		}
+		go func() {
+			defer inflight.Done()
+			handleToolsCall(sess, req)
+		}()
+	case "resources/list":
+		handleResourcesList(sess, req)
+	case "resources/read":
+		handleResourcesRead(sess, req)
+	case "ping":
+		sess.sendResult(req.ID, map[string]any{})
+	default:
+		sess.sendError(req.ID, -32601, "Method not found", req.Method)
+	}
+}
+
+func handleInitialize(sess *session, req *JSONRPCRequest) {
+	var params InitializeParams
+	if json.Unmarshal(req.Params, &params) == nil {
+		_, ok := params.Capabilities["elicitation"]
+		sess.elicitation.Store(ok)
+	}
+
+	result := InitializeResult{
+		ProtocolVersion: "2024-11-05",
+		Capabilities: ServerCapability{
+			Tools: &ToolsCapability{
+				ListChanged: true,
+			},
+			Resources: &ResourcesCapability{},
+		},
+		ServerInfo: serverInfo,
+	}
+	sess.sendResult(req.ID, result)
+}
+
+func handleToolsList(sess *session, req *JSONRPCRequest) {
+	result := ToolsListResult{
+		Tools: []Tool{},
+	}
+	for _, tool := range GetAllTools() {
+		if toolEnabled(tool.Name) && authorize(sess.caller, tool.Name, nil) == nil {
+			result.Tools = append(result.Tools, tool)
+		}
+	}
+	sess.sendResult(req.ID, result)
+}
+
+func handleToolsCall(sess *session, req *JSONRPCRequest) {
+	var params ToolCallParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		sess.sendError(req.ID, -32602, "Invalid params", err.Error())
+		return
+	}
+
+	result, err := callTool(sess.caller, params.Name, params.Arguments, requestTraceParent(req.Params))
+	if err != nil {
+		result = errorResult(fmt.Sprintf("Not allowed: %v", err))
+	}
+	sess.sendResult(req.ID, result)
+}
+
+// requestTraceParent returns the traceparent a client sent in the
+// request's _meta, if any
+func requestTraceParent(params json.RawMessage) SpanContext {
+	var p struct {
+		Meta struct {
+			Traceparent string `json:"traceparent"`
+		} `json:"_meta"`
+	}
+	if len(params) == 0 || json.Unmarshal(params, &p) != nil {
+		return SpanContext{}
+	}
+	sc, _ := parseTraceparent(p.Meta.Traceparent)
+	return sc
+}
+
+func handleResourcesList(sess *session, req *JSONRPCRequest) {
+	var resources []Resource
+	for _, info := range jobMgr.ArtifactJobs() {
+		if sess.caller.mayUseJob(info.Tool, info.Caller) != nil {
+			continue
+		}
+		resources = append(resources, Resource{
+			URI:         artifactURI(info.ID),
+			Name:        fmt.Sprintf("%s job %s changed files", info.Tool, info.ID[:8]),
+			Description: fmt.Sprintf("%d file(s) changed by %s job %s, finished %s", len(info.Artifacts), info.Tool, info.ID, info.EndTime.Format(time.RFC3339)),
+			MimeType:    "text/x-diff",
+		})
+	}
+	if resources == nil {
+		resources = []Resource{}
+	}
+	sess.sendResult(req.ID, ResourcesListResult{Resources: resources})
+}
+
+func handleResourcesRead(sess *session, req *JSONRPCRequest) {
+	var params ResourceReadParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		sess.sendError(req.ID, -32602, "Invalid params", err.Error())
+		return
+	}
+
+	jobID, ok := strings.CutPrefix(params.URI, "job://")
+	jobID, ok2 := strings.CutSuffix(jobID, "/artifacts")
+	if !ok || !ok2 {
+		sess.sendError(req.ID, -32002, "Resource not found", params.URI)
+		return
+	}
+	// Jobs the caller may not use are not found either, so their IDs
+	// cannot be probed
+	info, found := jobMgr.GetJobStatus(jobID)
+	if !found || info.Status == JobStatusRunning || info.Status == JobStatusQueued || sess.caller.mayUseJob(info.Tool, info.Caller) != nil {
+		sess.sendError(req.ID, -32002, "Resource not found", params.URI)
+		return
+	}
+
+	text := formatChanges(info.Artifacts, true)
+	if text == "" {
+		text = "No watched files changed.\n"
+	}
+	sess.sendResult(req.ID, ResourceReadResult{Contents: []ResourceContents{{
+		URI:      params.URI,
+		MimeType: "text/x-diff",
+		Text:     text,
+	}}})
+}
+
+func (s *session) sendResult(id interface{}, result interface{}) {
+	response := JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result:  result,
+	}
+	s.write(response)
+}
+
+func (s *session) sendError(id interface{}, code int, message string, data interface{}) {
+	response := JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error: &JSONRPCError{
+			Code:    code,
+			Message: message,
+			Data:    data,
+		},
+	}
+	s.write(response)
+}
+
+// inflight counts tool calls that have not responded yet
+var inflight sync.WaitGroup
+
+// session is one connected client
+type session struct {
+	transport Transport
+	caller    Caller
+	// writeMu keeps concurrent messages from interleaving
+	writeMu sync.Mutex
+	// elicitation is set when the client declared the elicitation
+	// capability in initialize
+	elicitation atomic.Bool
+}
+
+var (
+	sessionsMu sync.Mutex
+	// sessions are the connected clients, oldest first
+	sessions []*session
+)
+
+// openSession registers a client connected over t
+func openSession(t Transport, caller Caller) *session {
+	s := &session{transport: t, caller: caller}
+	sessionsMu.Lock()
+	sessions = append(sessions, s)
+	sessionsMu.Unlock()
+	return s
+}
+
+// close forgets the session; responses still owed to it are dropped
+func (s *session) close() {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	for i, other := range sessions {
+		if other == s {
+			sessions = append(sessions[:i], sessions[i+1:]...)
+			break
+		}
+	}
+}
+
+// openSessions returns the connected clients, oldest first
+func openSessions() []*session {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return append([]*session(nil), sessions...)
+}
+
+// notifyToolsChanged tells every client to fetch tools/list again
+func notifyToolsChanged() {
+	for _, s := range openSessions() {
+		s.write(JSONRPCRequest{JSONRPC: "2.0", Method: "notifications/tools/list_changed"})
+	}
+}
+
+// write sends one JSON-RPC message to the client
+func (s *session) write(msg any) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error marshaling response: %v\n", err)
+		return
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.transport.WriteMessage(data); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing response: %v\n", err)
+	}
+}
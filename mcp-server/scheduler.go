@@ -0,0 +1,347 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const DefaultStateDir = "/var/lib/a2cmds-mcp"
+
+// Schedule runs a tool on a cron schedule
+type Schedule struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name,omitempty"`
+	Cron      string         `json:"cron"`
+	Tool      string         `json:"tool"`
+	Arguments map[string]any `json:"arguments,omitempty"`
+	CreatedAt time.Time      `json:"createdAt"`
+
+	NextRun    time.Time `json:"nextRun"`
+	LastRun    time.Time `json:"lastRun"`
+	LastJobID  string    `json:"lastJobId,omitempty"`
+	LastStatus JobStatus `json:"lastStatus,omitempty"`
+	LastError  string    `json:"lastError,omitempty"`
+
+	expr *CronExpr
+}
+
+// Scheduler starts jobs for schedules when they are due and keeps their
+// state in a JSON file so it survives restarts
+type Scheduler struct {
+	path   string
+	jobMgr JobManager
+
+	mu        sync.Mutex
+	schedules map[string]*Schedule
+	wake      chan struct{}
+
+	// stop is closed by Stop, which ends the loop
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewScheduler loads schedules from path. A missing file means no schedules.
+func NewScheduler(path string, jm JobManager) (*Scheduler, error) {
+	s := &Scheduler{
+		path:      path,
+		jobMgr:    jm,
+		schedules: make(map[string]*Schedule),
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+
+	var list []*Schedule
+	if err := json.Unmarshal(data, &list); err != nil {
+		return s, fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	now := time.Now()
+	for _, sch := range list {
+		expr, err := ParseCron(sch.Cron)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping schedule %s: %v\n", sch.ID, err)
+			continue
+		}
+		sch.expr = expr
+		// Runs missed while the server was down are not caught up
+		if sch.NextRun.Before(now) {
+			sch.NextRun = expr.Next(now)
+		}
+		s.schedules[sch.ID] = sch
+	}
+
+	return s, nil
+}
+
+// Start runs the scheduling loop in the background
+func (s *Scheduler) Start() {
+	go s.loop()
+}
+
+// Stop ends the scheduling loop, so schedules start no more jobs; used
+// during shutdown
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// Create validates and adds a schedule
+func (s *Scheduler) Create(name, cron, tool string, args map[string]any) (*Schedule, error) {
+	expr, err := ParseCron(cron)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkScheduledCall(tool, args); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sch := &Schedule{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Cron:      cron,
+		Tool:      tool,
+		Arguments: args,
+		CreatedAt: now,
+		NextRun:   expr.Next(now),
+		expr:      expr,
+	}
+	if sch.NextRun.IsZero() {
+		return nil, fmt.Errorf("cron expression %q never matches", cron)
+	}
+
+	s.mu.Lock()
+	s.schedules[sch.ID] = sch
+	err = s.saveLocked()
+	s.mu.Unlock()
+
+	s.notify()
+	return sch, err
+}
+
+// Delete removes a schedule. Jobs it already started keep running.
+func (s *Scheduler) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.schedules[id]; !ok {
+		return fmt.Errorf("schedule not found: %s", id)
+	}
+	delete(s.schedules, id)
+	s.notify()
+	return s.saveLocked()
+}
+
+// List returns copies of all schedules ordered by next run
+func (s *Scheduler) List() []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]Schedule, 0, len(s.schedules))
+	for _, sch := range s.schedules {
+		out = append(out, *sch)
+	}
+	sort.Slice(out, func(i, k int) bool { return out[i].NextRun.Before(out[k].NextRun) })
+	return out
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// loop sleeps until the earliest schedule is due and runs every due
+// schedule, until Stop is called
+func (s *Scheduler) loop() {
+	for {
+		s.mu.Lock()
+		var next time.Time
+		for _, sch := range s.schedules {
+			if next.IsZero() || sch.NextRun.Before(next) {
+				next = sch.NextRun
+			}
+		}
+		s.mu.Unlock()
+
+		wait := time.Hour
+		if !next.IsZero() {
+			wait = time.Until(next)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			s.runDue(time.Now())
+		case <-s.wake:
+			timer.Stop()
+		case <-s.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// runDue makes the tool call of every schedule whose next run has passed
+func (s *Scheduler) runDue(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The timer and Stop can fire together
+	select {
+	case <-s.stop:
+		return
+	default:
+	}
+
+	for _, sch := range s.schedules {
+		if sch.NextRun.After(now) {
+			continue
+		}
+
+		sch.LastRun = now
+		sch.LastJobID = ""
+		sch.LastStatus = ""
+		sch.LastError = ""
+		sch.NextRun = sch.expr.Next(now)
+
+		go s.run(sch.ID, sch.Tool, maps.Clone(sch.Arguments), now)
+	}
+
+	if err := s.saveLocked(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving schedules: %v\n", err)
+	}
+}
+
+// run makes a schedule's tool call as the schedule's own caller, through
+// the same path as a client's call, and records the job it started or the
+// result of a sync tool
+func (s *Scheduler) run(id, tool string, args map[string]any, at time.Time) {
+	caller := Caller{Name: "schedule " + id, Via: "schedule"}
+	result, err := callTool(caller, tool, args, SpanContext{})
+
+	var jobID, lastError string
+	var status JobStatus
+	job, isJob := result.StructuredContent.(*jobContent)
+	switch {
+	case err != nil:
+		status, lastError = JobStatusFailed, err.Error()
+	case isJob:
+		jobID, status = job.JobID, job.Status
+		if job.Duplicate {
+			lastError = fmt.Sprintf("not run: job %s was already started for the same call", job.JobID)
+		}
+	case result.IsError:
+		status, lastError = JobStatusFailed, "call failed"
+		if len(result.Content) > 0 {
+			lastError, _, _ = strings.Cut(strings.TrimSpace(result.Content[0].Text), "\n")
+		}
+	default:
+		status = JobStatusCompleted
+	}
+	if status == JobStatusFailed {
+		fmt.Fprintf(os.Stderr, "Schedule %s: %s failed: %s\n", id, tool, lastError)
+	}
+
+	s.mu.Lock()
+	sch, ok := s.schedules[id]
+	if ok && sch.LastRun.Equal(at) {
+		sch.LastJobID, sch.LastStatus, sch.LastError = jobID, status, lastError
+		if err := s.saveLocked(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving schedules: %v\n", err)
+		}
+	}
+	s.mu.Unlock()
+
+	if ok && jobID != "" && status != JobStatusCompleted && status != JobStatusFailed {
+		s.recordOutcome(id, jobID)
+	}
+}
+
+// checkScheduledCall rejects a schedule whose every run would fail: an
+// unknown or disabled tool, a missing required argument, or arguments a
+// script-backed tool or pipeline cannot build its command from
+func checkScheduledCall(tool string, args map[string]any) error {
+	if strings.HasPrefix(tool, "schedule_") {
+		return fmt.Errorf("schedules cannot run %s", tool)
+	}
+	var def Tool
+	found := false
+	for _, t := range GetAllTools() {
+		if t.Name == tool {
+			def, found = t, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("unknown tool: %s", tool)
+	}
+	if !toolEnabled(tool) {
+		return fmt.Errorf("tool %s is disabled in the server configuration", tool)
+	}
+
+	args = withToolDefaults(tool, args)
+	for _, name := range def.InputSchema.Required {
+		if _, ok := args[name]; !ok {
+			return fmt.Errorf("%s is required", name)
+		}
+	}
+	switch {
+	case tool == "run_pipeline":
+		_, err := parsePipelineSteps(args["steps"])
+		return err
+	case toolCommands[tool] != nil || definedTool(tool) != nil:
+		_, err := buildToolCommand(tool, args)
+		return err
+	}
+	return nil
+}
+
+// recordOutcome stores the final status of a scheduled job once it finishes
+func (s *Scheduler) recordOutcome(scheduleID, jobID string) {
+	done := s.jobMgr.Done(jobID)
+	if done == nil {
+		return
+	}
+	<-done
+	info, _ := s.jobMgr.GetJobStatus(jobID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sch, ok := s.schedules[scheduleID]
+	if !ok || sch.LastJobID != jobID {
+		return
+	}
+	sch.LastStatus = info.Status
+	if err := s.saveLocked(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving schedules: %v\n", err)
+	}
+}
+
+// saveLocked writes all schedules to disk atomically. Callers hold s.mu.
+func (s *Scheduler) saveLocked() error {
+	list := make([]*Schedule, 0, len(s.schedules))
+	for _, sch := range s.schedules {
+		list = append(list, sch)
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].CreatedAt.Before(list[k].CreatedAt) })
+	return saveJSON(s.path, list)
+}
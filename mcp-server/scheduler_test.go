@@ -0,0 +1,38 @@
+package mcpserver
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSchedulerStopEndsLoop(t *testing.T) {
+	s, err := NewScheduler(filepath.Join(t.TempDir(), "schedules.json"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expr, err := ParseCron("@hourly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	due := time.Now().Add(-time.Minute)
+	s.schedules["due"] = &Schedule{ID: "due", Cron: "@hourly", Tool: "a2certrenew", NextRun: due, expr: expr}
+
+	s.Stop()
+	done := make(chan struct{})
+	go func() {
+		s.loop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the loop kept running after Stop")
+	}
+	if sch := s.List()[0]; !sch.NextRun.Equal(due) || !sch.LastRun.IsZero() {
+		t.Errorf("a stopped scheduler ran a schedule: next %s, last %s", sch.NextRun, sch.LastRun)
+	}
+	// Stopping again, as a second shutdown path may, is harmless
+	s.Stop()
+}
@@ -0,0 +1,238 @@
+package mcpserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// DefaultListenSocket is the usual socket for -listen
+const DefaultListenSocket = "/run/a2cmds-mcp/mcp.sock"
+
+// Transport carries JSON-RPC messages between the server and one client
+type Transport interface {
+	// ReadMessage returns the next message from the client, or io.EOF
+	// once the client is gone
+	ReadMessage() ([]byte, error)
+	// WriteMessage sends one message to the client. Calls never overlap.
+	WriteMessage(msg []byte) error
+}
+
+// stdioTransport exchanges newline-delimited messages over a pair of
+// streams: stdin and stdout, or both ends of a socket
+type stdioTransport struct {
+	scanner *bufio.Scanner
+	w       io.Writer
+}
+
+// NewStdioTransport reads messages from r and writes them to w, one per line
+func NewStdioTransport(r io.Reader, w io.Writer) Transport {
+	scanner := bufio.NewScanner(r)
+	// Increase buffer size for large messages
+	buf := make([]byte, 0, 64*1024)
+	scanner.Buffer(buf, 1024*1024)
+	return &stdioTransport{scanner: scanner, w: w}
+}
+
+func (t *stdioTransport) ReadMessage() ([]byte, error) {
+	for t.scanner.Scan() {
+		if line := t.scanner.Bytes(); len(line) > 0 {
+			return append([]byte(nil), line...), nil
+		}
+	}
+	if err := t.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (t *stdioTransport) WriteMessage(msg []byte) error {
+	_, err := t.w.Write(append(msg, '\n'))
+	return err
+}
+
+// Options configure a Server. The zero value serves the built-in tools
+// with the default configuration.
+type Options struct {
+	// Config is the configuration in effect; nil uses the defaults
+	Config *Config
+	// StateDir holds schedules, idempotency keys and unfinished jobs;
+	// empty uses DefaultStateDir
+	StateDir string
+	// Jobs runs the async tools' jobs; nil uses a JobQueue sized by
+	// Config.Queue. Pipelines need a JobQueue.
+	Jobs JobManager
+	// Executor starts scripts; nil runs them locally, or through the
+	// helper named in Config.Transports
+	Executor Executor
+	// Hosts lists the remote hosts tools can run on; nil allows none
+	Hosts *HostInventory
+	// Notify holds the global job notification rules
+	Notify NotifyConfig
+	// Name and Version are reported to clients in initialize
+	Name    string
+	Version string
+}
+
+// Server is an MCP server for the a2cmds scripts. It owns the job
+// manager, scheduler, idempotency keys and notifier it was created with.
+// The tool handlers still reach them, and the configuration, through
+// package state set by NewServer, so a program can create only one
+// Server.
+type Server struct {
+	stateDir    string
+	jobs        JobManager
+	scheduler   *Scheduler
+	idempotency *IdempotencyStore
+	notifier    *Notifier
+}
+
+var (
+	serverCreated atomic.Bool
+	// serverInfo is what initialize reports
+	serverInfo = ServerInfo{Name: "a2cmds-mcp", Version: "1.0.0"}
+)
+
+// NewServer sets up the job manager, scheduler and notifications and
+// starts the metrics endpoint if one is configured. Problems with saved
+// state are reported on stderr but do not stop the server.
+func NewServer(opts Options) (*Server, error) {
+	if !serverCreated.CompareAndSwap(false, true) {
+		return nil, errors.New("a Server already exists in this process")
+	}
+	conf := opts.Config
+	if conf == nil {
+		conf = defaultConfig()
+	}
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	activeConfig.Store(conf)
+
+	s := &Server{stateDir: opts.StateDir}
+	if s.stateDir == "" {
+		s.stateDir = DefaultStateDir
+	}
+	if opts.Name != "" {
+		serverInfo.Name = opts.Name
+	}
+	if opts.Version != "" {
+		serverInfo.Version = opts.Version
+	}
+
+	switch {
+	case opts.Executor != nil:
+		executor = opts.Executor
+	case conf.Transports.ExecHelper != "":
+		executor = helperExecutor{socket: conf.Transports.ExecHelper}
+	}
+	if opts.Hosts != nil {
+		activeHosts.Store(opts.Hosts)
+	}
+
+	s.jobs = opts.Jobs
+	if s.jobs == nil {
+		s.jobs = NewJobQueue(conf.Queue.Workers, conf.Queue.ToolLimits)
+	}
+	reportUnfinishedJobs(s.stateDir)
+	outputDir = filepath.Join(s.stateDir, "output")
+
+	var err error
+	s.scheduler, err = NewScheduler(filepath.Join(s.stateDir, "schedules.json"), s.jobs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading schedules: %v\n", err)
+	}
+	s.scheduler.Start()
+
+	s.idempotency, err = NewIdempotencyStore(filepath.Join(s.stateDir, "idempotency.json"), conf.Retention.Idempotency, s.jobs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading idempotency keys: %v\n", err)
+	}
+
+	s.notifier = NewNotifier(opts.Notify, s.jobs)
+	s.jobs.OnJobPrompt(elicitPrompt)
+
+	// The handlers find the server's state here
+	jobMgr, scheduler, idempotency, notifier = s.jobs, s.scheduler, s.idempotency, s.notifier
+
+	if conf.Transports.MetricsListen != "" {
+		ServeMetrics(conf.Transports.MetricsListen, s.jobs)
+	}
+	return s, nil
+}
+
+// Jobs returns the job manager the server's tools start jobs with
+func (s *Server) Jobs() JobManager {
+	return s.jobs
+}
+
+// Serve answers the client on t until it goes away. It returns nil when
+// the client closes the connection, and the read error otherwise. Several
+// clients can be served at once; they share the jobs. Jobs keep running
+// when a client leaves; call Shutdown to wait for them.
+func (s *Server) Serve(t Transport) error {
+	return s.serve(t, stdioCaller)
+}
+
+// serve answers a client whose tool calls are made as caller
+func (s *Server) serve(t Transport, caller Caller) error {
+	sess := openSession(t, caller)
+	defer sess.close()
+
+	for {
+		line, err := t.ReadMessage()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var request JSONRPCRequest
+		if err := json.Unmarshal(line, &request); err != nil {
+			sess.sendError(nil, -32700, "Parse error", err.Error())
+			continue
+		}
+
+		// Responses to our own requests, such as elicitations
+		if request.Method == "" && request.ID != nil {
+			sess.handleClientResponse(line)
+			continue
+		}
+
+		handleRequest(sess, &request)
+	}
+}
+
+// Listen serves every client that connects to l until l is closed.
+// Clients on a unix socket are authorized by their Unix user.
+func (s *Server) Listen(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := s.serve(NewStdioTransport(conn, conn), connCaller(conn)); err != nil {
+				fmt.Fprintf(os.Stderr, "Client %s: %v\n", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Shutdown stops accepting tool calls and starting jobs, then waits up
+// to the configured shutdown timeout for running jobs and open calls.
+// Jobs left unfinished are saved to the state directory.
+func (s *Server) Shutdown(reason string) {
+	s.shutdown(reason, config().Timeouts.Shutdown)
+}
@@ -0,0 +1,30 @@
+package mcpserver
+
+import "testing"
+
+func TestNewServer(t *testing.T) {
+	oldConfig := activeConfig.Load()
+	oldJobMgr, oldScheduler, oldIdempotency, oldNotifier, oldOutputDir := jobMgr, scheduler, idempotency, notifier, outputDir
+	t.Cleanup(func() {
+		activeConfig.Store(oldConfig)
+		jobMgr, scheduler, idempotency, notifier, outputDir = oldJobMgr, oldScheduler, oldIdempotency, oldNotifier, oldOutputDir
+		serverCreated.Store(false)
+	})
+
+	jobs := NewJobQueue(1, nil)
+	s, err := NewServer(Options{StateDir: t.TempDir(), Jobs: jobs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.scheduler.Stop()
+
+	if s.Jobs() != jobs {
+		t.Error("Jobs did not return the job manager the server was created with")
+	}
+	if s.scheduler == nil || s.idempotency == nil || s.notifier == nil {
+		t.Error("the server's scheduler, idempotency store or notifier is not set")
+	}
+	if _, err := NewServer(Options{StateDir: t.TempDir()}); err == nil {
+		t.Error("a second Server was created in the same process")
+	}
+}
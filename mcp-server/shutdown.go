@@ -0,0 +1,203 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const DefaultShutdownTimeout = 2 * time.Minute
+
+// unfinishedJobsFile records the jobs a shutdown left behind, in the
+// state directory
+const unfinishedJobsFile = "unfinished-jobs.json"
+
+var (
+	// callsMu guards shuttingDown and the inflight.Add of new tool calls,
+	// so no call slips in after shutdown starts waiting for them
+	callsMu      sync.Mutex
+	shuttingDown bool
+
+	shutdownOnce sync.Once
+)
+
+// unfinishedJob is what is saved about a job still queued or running at exit
+type unfinishedJob struct {
+	ID        string    `json:"id"`
+	Tool      string    `json:"tool"`
+	Host      string    `json:"host,omitempty"`
+	Status    JobStatus `json:"status"`
+	PID       int       `json:"pid,omitempty"`
+	Command   []string  `json:"command,omitempty"`
+	ParentID  string    `json:"parentId,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	QueuedAt  time.Time `json:"queuedAt"`
+	StartedAt time.Time `json:"startedAt"`
+	Output    string    `json:"output,omitempty"`
+	Stderr    string    `json:"stderr,omitempty"`
+
+	// OutputFiles keep receiving the stdout and stderr of a job left
+	// running
+	OutputFiles []string `json:"outputFiles,omitempty"`
+}
+
+// beginToolCall registers a tool call unless the server is shutting down
+func beginToolCall() bool {
+	callsMu.Lock()
+	defer callsMu.Unlock()
+
+	if shuttingDown {
+		return false
+	}
+	inflight.Add(1)
+	return true
+}
+
+// handleSignals shuts down on SIGTERM or SIGINT. A second signal exits
+// at once.
+func handleSignals(s *Server) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-sigs
+		go func() {
+			sig := <-sigs
+			fmt.Fprintf(os.Stderr, "Received %s again, exiting without waiting for jobs\n", sig)
+			os.Exit(1)
+		}()
+		s.shutdown(sig.String(), config().Timeouts.Shutdown)
+		os.Exit(0)
+	}()
+}
+
+// shutdown stops accepting tool calls and starting jobs, waits up to
+// timeout for running jobs and open calls, and saves and logs every job
+// left unfinished. Running processes are not killed: stopping a purchase
+// or certbot halfway is worse than letting it finish unobserved. They run
+// in their own process group, write their output to files and ignore the
+// hangup of their terminal, so the server exiting does not stop them.
+func (s *Server) shutdown(reason string, timeout time.Duration) {
+	shutdownOnce.Do(func() {
+		fmt.Fprintf(os.Stderr, "Shutting down (%s), waiting up to %s for running jobs\n", reason, timeout)
+		deadline := time.Now().Add(timeout)
+
+		callsMu.Lock()
+		shuttingDown = true
+		callsMu.Unlock()
+
+		s.scheduler.Stop()
+		// Nobody is left to answer prompts
+		s.jobs.CloseInputs()
+		left := s.jobs.Drain(timeout)
+
+		// Give open calls (sync tools, wait_job) the rest of the deadline,
+		// but at least a moment, to respond
+		calls := make(chan struct{})
+		go func() {
+			inflight.Wait()
+			close(calls)
+		}()
+		remaining := time.Until(deadline)
+		if remaining < time.Second {
+			remaining = time.Second
+		}
+		select {
+		case <-calls:
+		case <-time.After(remaining):
+			fmt.Fprintln(os.Stderr, "Some tool calls did not respond before the shutdown deadline")
+		}
+
+		if len(left) > 0 {
+			s.saveUnfinishedJobs(left)
+		}
+		tracer.Flush()
+	})
+}
+
+// saveUnfinishedJobs logs the jobs a shutdown leaves behind and writes
+// them to the state directory
+func (s *Server) saveUnfinishedJobs(ids []string) {
+	var jobs []unfinishedJob
+	for _, id := range ids {
+		info, ok := s.jobs.GetJobStatus(id)
+		if !ok {
+			continue
+		}
+		u := unfinishedJob{
+			ID:          id,
+			Tool:        info.Tool,
+			Host:        info.Host,
+			Status:      info.Status,
+			PID:         info.PID,
+			OutputFiles: info.OutputFiles,
+			ParentID:    info.ParentID,
+			Source:      info.Source,
+			QueuedAt:    info.QueueTime,
+			StartedAt:   info.StartTime,
+			Output:      info.Output,
+			Stderr:      info.Stderr,
+			Command:     info.Command,
+		}
+		jobs = append(jobs, u)
+
+		switch {
+		case u.PID != 0:
+			fmt.Fprintf(os.Stderr, "Left running: job %s (%s), pid %d, started %s\n", id, u.Tool, u.PID, u.StartedAt.Format(time.RFC3339))
+			if len(u.OutputFiles) > 0 {
+				fmt.Fprintf(os.Stderr, "  output continues in %s\n", strings.Join(u.OutputFiles, " and "))
+			}
+		case u.Status == JobStatusRunning && u.Host != "":
+			fmt.Fprintf(os.Stderr, "Left running: job %s (%s) on host %s, started %s\n", id, u.Tool, u.Host, u.StartedAt.Format(time.RFC3339))
+		case u.Status == JobStatusRunning:
+			fmt.Fprintf(os.Stderr, "Left unfinished: job %s (%s)\n", id, u.Tool)
+		default:
+			fmt.Fprintf(os.Stderr, "Not started: job %s (%s), queued %s\n", id, u.Tool, u.QueuedAt.Format(time.RFC3339))
+		}
+	}
+
+	path := filepath.Join(s.stateDir, unfinishedJobsFile)
+	if err := saveJSON(path, jobs); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving unfinished jobs: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d unfinished job(s) saved to %s\n", len(jobs), path)
+}
+
+// reportUnfinishedJobs logs the jobs the previous run left behind and
+// moves their record aside so it is reported only once
+func reportUnfinishedJobs(stateDir string) {
+	path := filepath.Join(stateDir, unfinishedJobsFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var jobs []unfinishedJob
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", path, err)
+		return
+	}
+	for _, u := range jobs {
+		fmt.Fprintf(os.Stderr, "Previous run left job %s (%s) %s", u.ID, u.Tool, u.Status)
+		if u.Host != "" {
+			fmt.Fprintf(os.Stderr, " on host %s", u.Host)
+		}
+		if u.PID != 0 {
+			fmt.Fprintf(os.Stderr, " as pid %d", u.PID)
+		}
+		if len(u.OutputFiles) > 0 {
+			fmt.Fprintf(os.Stderr, ", output in %s", strings.Join(u.OutputFiles, " and "))
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+	seen := path + "." + time.Now().Format("20060102-150405")
+	if err := os.Rename(path, seen); err == nil {
+		fmt.Fprintf(os.Stderr, "Details kept in %s\n", seen)
+	}
+}
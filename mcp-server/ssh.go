@@ -0,0 +1,208 @@
+package mcpserver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshExecutor runs scripts on a remote host over one shared SSH
+// connection, opening a session per command
+type sshExecutor struct {
+	name string
+	host *RemoteHost
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+var (
+	sshExecutorsMu sync.Mutex
+	sshExecutors   = map[string]*sshExecutor{}
+)
+
+// sshExecutorFor returns the executor for a host, reusing its connection
+// unless a reload of the hosts file changed the host
+func sshExecutorFor(name string, h *RemoteHost) *sshExecutor {
+	sshExecutorsMu.Lock()
+	defer sshExecutorsMu.Unlock()
+
+	if e, ok := sshExecutors[name]; ok && *e.host == *h {
+		return e
+	}
+	e := &sshExecutor{name: name, host: h}
+	sshExecutors[name] = e
+	return e
+}
+
+// dial connects and authenticates to the host, checking its key
+func (e *sshExecutor) dial() (*ssh.Client, error) {
+	keyData, err := os.ReadFile(e.host.IdentityFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(keyData)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v (keys with a passphrase are not supported)", e.host.IdentityFile, err)
+	}
+	hostKeys, err := knownhosts.New(e.host.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := e.host.Address
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	return ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            e.host.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeys,
+		Timeout:         15 * time.Second,
+	})
+}
+
+// session opens a session, reconnecting once if the connection dropped
+func (e *sshExecutor) session() (*ssh.Session, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client != nil {
+		if s, err := e.client.NewSession(); err == nil {
+			return s, nil
+		}
+		e.client.Close()
+		e.client = nil
+	}
+
+	client, err := e.dial()
+	if err != nil {
+		return nil, fmt.Errorf("ssh %s: %v", e.name, err)
+	}
+	e.client = client
+	s, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("ssh %s: %v", e.name, err)
+	}
+	return s, nil
+}
+
+// remoteArgv is the command that runs req on the host. Variables are set
+// with env because sshd normally refuses to accept them from the client.
+func (e *sshExecutor) remoteArgv(req ExecRequest) []string {
+	var argv []string
+	if e.host.Sudo {
+		argv = append(argv, "sudo", "-n")
+	}
+	var env []string
+	if req.TraceParent != "" {
+		env = append(env, "TRACEPARENT="+req.TraceParent)
+	}
+	if req.Tool == "a2wcrecalc_dms" && e.host.DMSDir != "" {
+		env = append(env, "DMS_DIR="+e.host.DMSDir)
+	}
+	if len(env) > 0 {
+		argv = append(argv, "env")
+		argv = append(argv, env...)
+	}
+	argv = append(argv, req.Name)
+	return append(argv, req.Args...)
+}
+
+func (e *sshExecutor) Start(req ExecRequest) (*Process, error) {
+	session, err := e.session()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	proc := &Process{}
+	if req.Stdin {
+		if proc.Stdin, err = session.StdinPipe(); err != nil {
+			session.Close()
+			return nil, err
+		}
+	}
+	if req.PTY {
+		proc.PTYErr = fmt.Errorf("not available on remote host %s", e.name)
+	}
+
+	argv := e.remoteArgv(req)
+	quoted := make([]string, len(argv))
+	for i, a := range argv {
+		quoted[i] = shellQuote(a)
+	}
+	if err := session.Start("cd / && exec " + strings.Join(quoted, " ")); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	proc.Argv = append([]string{"ssh", e.name}, argv...)
+	var killed atomic.Bool
+	proc.kill = func() error {
+		killed.Store(true)
+		// Not every sshd passes signals on; closing the session hangs up
+		session.Signal(ssh.SIGKILL)
+		return session.Close()
+	}
+	var outDone, errDone <-chan struct{}
+	proc.Stdout, outDone = relayOutput(stdout)
+	proc.Stderr, errDone = relayOutput(stderr)
+
+	proc.wait = func() (int, error) {
+		err := session.Wait()
+
+		grace := time.NewTimer(outputGrace)
+		defer grace.Stop()
+		for _, done := range []<-chan struct{}{outDone, errDone} {
+			select {
+			case <-done:
+			case <-grace.C:
+				session.Close()
+			}
+		}
+		<-outDone
+		<-errDone
+		session.Close()
+
+		if killed.Load() {
+			return -1, nil
+		}
+		switch err := err.(type) {
+		case nil:
+			return 0, nil
+		case *ssh.ExitError:
+			if err.Signal() != "" {
+				return -1, nil
+			}
+			return err.ExitStatus(), nil
+		default:
+			return -1, fmt.Errorf("ssh %s: %v", e.name, err)
+		}
+	}
+	return proc, nil
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:@,+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,145 @@
+package mcpserver
+
+import "time"
+
+// Tool results carry their text for people and models, and the same
+// facts as structuredContent for programs such as the Go client.
+
+// jobContent is a job's state in structuredContent
+type jobContent struct {
+	JobID  string    `json:"jobId"`
+	Tool   string    `json:"tool"`
+	Host   string    `json:"host,omitempty"`
+	Status JobStatus `json:"status"`
+	// ExitCode is set once the job has finished
+	ExitCode  *int `json:"exitCode,omitempty"`
+	Cancelled bool `json:"cancelled,omitempty"`
+
+	Priority       string     `json:"priority"`
+	QueuePosition  int        `json:"queuePosition,omitempty"`
+	WaitingFor     string     `json:"waitingFor,omitempty"`
+	EstimatedStart *time.Time `json:"estimatedStart,omitempty"`
+	Locks          []string   `json:"locks,omitempty"`
+
+	ParentID string        `json:"parentId,omitempty"`
+	Source   string        `json:"source,omitempty"`
+	Steps    []stepContent `json:"steps,omitempty"`
+
+	Attempts    int            `json:"attempts,omitempty"`
+	MaxAttempts int            `json:"maxAttempts,omitempty"`
+	Prompt      *promptContent `json:"prompt,omitempty"`
+
+	Output    string          `json:"output,omitempty"`
+	Stderr    string          `json:"stderr,omitempty"`
+	Artifacts []changeContent `json:"artifacts,omitempty"`
+
+	QueuedAt  time.Time  `json:"queuedAt"`
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	EndedAt   *time.Time `json:"endedAt,omitempty"`
+
+	// CheckInterval is how often to poll a job that was just started
+	CheckInterval string `json:"checkInterval,omitempty"`
+	// WaitReason says why wait_job returned
+	WaitReason string `json:"waitReason,omitempty"`
+	// Duplicate marks a job an earlier call with the same idempotency
+	// key started
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+type stepContent struct {
+	ID        string     `json:"id"`
+	Tool      string     `json:"tool"`
+	Status    StepStatus `json:"status"`
+	JobID     string     `json:"jobId,omitempty"`
+	Attempts  int        `json:"attempts,omitempty"`
+	ExitCode  int        `json:"exitCode"`
+	DependsOn []string   `json:"dependsOn,omitempty"`
+}
+
+type promptContent struct {
+	Text      string    `json:"text"`
+	Source    string    `json:"source"`
+	Since     time.Time `json:"since"`
+	Sensitive bool      `json:"sensitive,omitempty"`
+}
+
+type changeContent struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	Diff   string `json:"diff,omitempty"`
+}
+
+// commandContent is a sync tool's run in structuredContent
+type commandContent struct {
+	ExitCode int             `json:"exitCode"`
+	Stdout   string          `json:"stdout"`
+	Stderr   string          `json:"stderr"`
+	Changes  []changeContent `json:"changes,omitempty"`
+}
+
+// newJobContent copies a job's state for structuredContent
+func newJobContent(info JobInfo) *jobContent {
+	c := &jobContent{
+		JobID:       info.ID,
+		Tool:        info.Tool,
+		Host:        info.Host,
+		Status:      info.Status,
+		Cancelled:   info.Cancelled,
+		Priority:    info.Priority.String(),
+		Locks:       info.Locks,
+		ParentID:    info.ParentID,
+		Source:      info.Source,
+		Attempts:    len(info.Attempts),
+		MaxAttempts: info.MaxAttempts,
+		Output:      info.Output,
+		Stderr:      info.Stderr,
+		Artifacts:   newChangeContents(info.Artifacts),
+		QueuedAt:    info.QueueTime,
+	}
+	if info.Status == JobStatusQueued {
+		c.QueuePosition = info.QueuePosition
+		c.WaitingFor = waitReason(info)
+		if !info.EstimatedStart.IsZero() {
+			c.EstimatedStart = &info.EstimatedStart
+		}
+	}
+	if info.Status == JobStatusCompleted || info.Status == JobStatusFailed {
+		exitCode := info.ExitCode
+		c.ExitCode = &exitCode
+	}
+	if !info.StartTime.IsZero() {
+		c.StartedAt = &info.StartTime
+	}
+	if !info.EndTime.IsZero() {
+		c.EndedAt = &info.EndTime
+	}
+	if p := info.Prompt; p != nil {
+		c.Prompt = &promptContent{Text: p.Text, Source: p.Source, Since: p.Since, Sensitive: p.Sensitive}
+	}
+	for _, st := range info.Steps {
+		c.Steps = append(c.Steps, stepContent{
+			ID:        st.ID,
+			Tool:      st.Tool,
+			Status:    st.Status,
+			JobID:     st.JobID,
+			Attempts:  st.Attempts,
+			ExitCode:  st.ExitCode,
+			DependsOn: st.DependsOn,
+		})
+	}
+	return c
+}
+
+func newChangeContents(changes []FileChange) []changeContent {
+	var out []changeContent
+	for _, ch := range changes {
+		out = append(out, changeContent{Path: ch.Path, Change: ch.Change, Diff: ch.Diff})
+	}
+	return out
+}
+
+// withStructured returns the result with structuredContent added
+func withStructured(r ToolCallResult, content any) ToolCallResult {
+	r.StructuredContent = content
+	return r
+}
@@ -0,0 +1,657 @@
+package mcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/a2cmds/mcp-server/client"
+	"golang.org/x/term"
+)
+
+// subcommand calls tools from a shell instead of serving a client
+type subcommand struct {
+	// args describes the positional arguments, for the usage message
+	args  string
+	about string
+	// setup adds the command's own flags and returns the command
+	setup func(fs *flag.FlagSet) func(cl *cliClient, args []string) int
+}
+
+var subcommands = map[string]subcommand{
+	"tools": {
+		about: "List the tools the server offers.",
+		setup: func(fs *flag.FlagSet) func(*cliClient, []string) int {
+			return cmdTools
+		},
+	},
+	"call": {
+		args:  "TOOL [NAME=VALUE...]",
+		about: "Call a tool. String arguments are taken as they are; others are JSON, as in port=8080 or secured=true.",
+		setup: func(fs *flag.FlagSet) func(*cliClient, []string) int {
+			follow := fs.Bool("follow", false, "follow the job an async tool starts until it finishes")
+			return func(cl *cliClient, args []string) int {
+				return cmdCall(cl, args, *follow)
+			}
+		},
+	},
+	"jobs": {
+		about: "List the server's jobs, newest first.",
+		setup: func(fs *flag.FlagSet) func(*cliClient, []string) int {
+			status := fs.String("status", "", "only list jobs with this status: queued, running, completed or failed")
+			tool := fs.String("tool", "", "only list jobs of this tool")
+			return func(cl *cliClient, args []string) int {
+				return cmdJobs(cl, args, *status, *tool)
+			}
+		},
+	},
+	"tui": {
+		about: "Watch the server in a full-screen view: its jobs, the selected job's output as it arrives, and the domains with their DNS and certificate expiry. Keys: tab switches between jobs and domains, c cancels the selected job, s and d check the selected domain's status and DNS records, r renews the certificates that are due, q quits.",
+		setup: func(fs *flag.FlagSet) func(*cliClient, []string) int {
+			refresh := fs.Duration("refresh", time.Second, "how often to refresh the jobs and the selected job's output")
+			return func(cl *cliClient, args []string) int {
+				return cmdTUI(cl, args, *refresh)
+			}
+		},
+	},
+	"job": {
+		args:  "ID",
+		about: "Show a job's status and output.",
+		setup: func(fs *flag.FlagSet) func(*cliClient, []string) int {
+			follow := fs.Bool("follow", false, "print the job's output as it arrives, answering its prompts from the terminal, until it finishes")
+			return func(cl *cliClient, args []string) int {
+				return cmdJob(cl, args, *follow)
+			}
+		},
+	},
+}
+
+// runSubcommand runs a subcommand with its arguments and returns the
+// exit code
+func runSubcommand(name string, args []string) int {
+	cmd := subcommands[name]
+	fs := flag.NewFlagSet("a2cmds-mcp "+name, flag.ContinueOnError)
+	server := fs.String("server", "", "unix socket of a running server, URL of its REST API, or \"local\" to run the tools in this process; default "+DefaultListenSocket+" if it exists, else local")
+	token := fs.String("token", os.Getenv("A2CMDS_MCP_TOKEN"), "REST API token; default $A2CMDS_MCP_TOKEN")
+	format := fs.String("o", "table", "output format: table or json")
+	configFile := fs.String("config", DefaultConfigFile, "configuration file for -server local")
+	run := cmd.setup(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: a2cmds-mcp %s [flags] %s\n\n%s\n\nFlags:\n", name, cmd.args, cmd.about)
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "-o must be table or json, not %q\n", *format)
+		return 2
+	}
+
+	addr := resolveServer(*server)
+	tc, err := dialServer(addr, *token, *configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer tc.Close()
+	_, local := tc.(*localCaller)
+	return run(&cliClient{toolCaller: tc, server: addr, json: *format == "json", local: local}, positional)
+}
+
+// parseInterspersed parses flags given before, between or after the
+// positional arguments, as in "job ID -follow"
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// cliResult is a tool call's result as the subcommands see it
+type cliResult struct {
+	Text       string
+	IsError    bool
+	Structured json.RawMessage
+}
+
+// toolCaller is the server the subcommands call tools on
+type toolCaller interface {
+	ListTools() ([]Tool, error)
+	CallTool(name string, args map[string]any) (cliResult, error)
+	Close()
+}
+
+// resolveServer returns the server to use when -server is not given:
+// the usual socket if a server is listening there, else local
+func resolveServer(server string) string {
+	if server != "" {
+		return server
+	}
+	if _, err := os.Stat(DefaultListenSocket); err == nil {
+		return DefaultListenSocket
+	}
+	return "local"
+}
+
+// dialServer connects to the server -server names
+func dialServer(server, token, configFile string) (toolCaller, error) {
+	switch {
+	case server == "local":
+		return newLocalCaller(configFile)
+	case strings.HasPrefix(server, "http://"), strings.HasPrefix(server, "https://"):
+		if token == "" {
+			return nil, errors.New("the REST API needs a token; pass -token or set A2CMDS_MCP_TOKEN")
+		}
+		return &httpCaller{base: strings.TrimSuffix(server, "/"), token: token}, nil
+	default:
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		c, err := client.Dial(ctx, "unix", server)
+		if err != nil {
+			return nil, err
+		}
+		return mcpCaller{c}, nil
+	}
+}
+
+// mcpCaller calls tools over a server's MCP socket
+type mcpCaller struct {
+	c *client.Client
+}
+
+func (m mcpCaller) ListTools() ([]Tool, error) {
+	list, err := m.c.ListTools(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	tools := make([]Tool, 0, len(list))
+	for _, t := range list {
+		tool := Tool{Name: t.Name, Description: t.Description}
+		if err := json.Unmarshal(t.InputSchema, &tool.InputSchema); err != nil {
+			return nil, fmt.Errorf("%s: %v", t.Name, err)
+		}
+		tools = append(tools, tool)
+	}
+	return tools, nil
+}
+
+func (m mcpCaller) CallTool(name string, args map[string]any) (cliResult, error) {
+	r, err := m.c.CallTool(context.Background(), name, args)
+	var toolErr *client.ToolError
+	if err != nil && !errors.As(err, &toolErr) {
+		return cliResult{}, err
+	}
+	return cliResult{Text: r.Text, IsError: r.IsError, Structured: r.Structured}, nil
+}
+
+func (m mcpCaller) Close() {
+	m.c.Close()
+}
+
+// httpCaller calls tools through a server's REST API
+type httpCaller struct {
+	base  string
+	token string
+}
+
+// do sends a request and decodes a 2xx or 422 response into v. It
+// returns the status code.
+func (h *httpCaller) do(method, path string, body, v any) (int, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, h.base+path, r)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+h.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusUnprocessableEntity:
+		return resp.StatusCode, json.Unmarshal(data, v)
+	}
+	var e restError
+	if json.Unmarshal(data, &e) != nil || e.Error == "" {
+		e.Error = resp.Status
+	}
+	return resp.StatusCode, errors.New(e.Error)
+}
+
+func (h *httpCaller) ListTools() ([]Tool, error) {
+	var result struct {
+		Tools []Tool `json:"tools"`
+	}
+	_, err := h.do(http.MethodGet, "/v1/tools", nil, &result)
+	return result.Tools, err
+}
+
+func (h *httpCaller) CallTool(name string, args map[string]any) (cliResult, error) {
+	var result struct {
+		Text   string          `json:"text"`
+		Result json.RawMessage `json:"result"`
+	}
+	status, err := h.do(http.MethodPost, "/v1/tools/"+name, args, &result)
+	if err != nil {
+		return cliResult{}, err
+	}
+	return cliResult{Text: result.Text, IsError: status == http.StatusUnprocessableEntity, Structured: result.Result}, nil
+}
+
+func (h *httpCaller) Close() {}
+
+// localCaller runs the tools in this process, with the configuration
+// file but none of a running server's state
+type localCaller struct {
+	caller   Caller
+	stateDir string
+}
+
+func newLocalCaller(configFile string) (*localCaller, error) {
+	conf, err := LoadConfig(configFile, nil)
+	if err != nil {
+		return nil, err
+	}
+	// A running server owns the listeners
+	conf.Transports = ConfigTransports{ExecHelper: conf.Transports.ExecHelper}
+
+	inv, err := LoadHostInventory(DefaultHostsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading hosts: %v\n", err)
+	}
+	stateDir, err := os.MkdirTemp("", "a2cmds-mcp-")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := NewServer(Options{Config: conf, StateDir: stateDir, Hosts: inv}); err != nil {
+		os.RemoveAll(stateDir)
+		return nil, err
+	}
+
+	l := &localCaller{caller: Caller{Name: "unknown", Via: "local"}, stateDir: stateDir}
+	if u, err := user.Current(); err == nil {
+		l.caller.Name = u.Username
+	}
+	return l, nil
+}
+
+func (l *localCaller) ListTools() ([]Tool, error) {
+	var tools []Tool
+	for _, tool := range GetAllTools() {
+		if toolEnabled(tool.Name) {
+			tools = append(tools, tool)
+		}
+	}
+	return tools, nil
+}
+
+func (l *localCaller) CallTool(name string, args map[string]any) (cliResult, error) {
+	result, err := callTool(l.caller, name, args, SpanContext{})
+	if err != nil {
+		return cliResult{}, err
+	}
+	r := cliResult{IsError: result.IsError}
+	for _, block := range result.Content {
+		r.Text += block.Text
+	}
+	if result.StructuredContent != nil {
+		if r.Structured, err = json.Marshal(result.StructuredContent); err != nil {
+			return cliResult{}, err
+		}
+	}
+	return r, nil
+}
+
+func (l *localCaller) Close() {
+	os.RemoveAll(l.stateDir)
+}
+
+// cliClient is what the subcommands run with
+type cliClient struct {
+	toolCaller
+	// server is the socket, URL or "local" the client talks to
+	server string
+	// json prints results as JSON instead of tables and text
+	json bool
+	// local is set when jobs run in this process and end with it
+	local bool
+
+	stdin *bufio.Reader
+}
+
+func cmdTools(cl *cliClient, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "tools takes no arguments")
+		return 2
+	}
+	tools, err := cl.ListTools()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if cl.json {
+		printJSON(tools)
+		return 0
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TOOL\tMODE\tDESCRIPTION")
+	for _, tool := range tools {
+		mode := "sync"
+		if startsJob(tool) {
+			mode = "async"
+		}
+		summary, _, _ := strings.Cut(tool.Description, ". ")
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", tool.Name, mode, strings.TrimSuffix(summary, "."))
+	}
+	tw.Flush()
+	return 0
+}
+
+func cmdCall(cl *cliClient, args []string, follow bool) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "call needs a tool name")
+		return 2
+	}
+	tools, err := cl.ListTools()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	var tool *Tool
+	for i := range tools {
+		if tools[i].Name == args[0] {
+			tool = &tools[i]
+		}
+	}
+	if tool == nil {
+		fmt.Fprintf(os.Stderr, "Unknown tool %q; list them with a2cmds-mcp tools\n", args[0])
+		return 2
+	}
+	toolArgs, err := parseToolArgs(*tool, args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	res, err := cl.CallTool(tool.Name, toolArgs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	// A job started in this process would die with it
+	if (follow || cl.local) && !res.IsError && startsJob(*tool) {
+		var job client.Job
+		if json.Unmarshal(res.Structured, &job) == nil && job.ID != "" {
+			return cl.follow(job.ID)
+		}
+	}
+	return cl.printResult(res, nil)
+}
+
+// parseToolArgs turns NAME=VALUE arguments into a tool's arguments.
+// Values of string arguments are taken as they are; others are parsed as
+// JSON, so numbers, booleans, arrays and objects can be given.
+func parseToolArgs(tool Tool, list []string) (map[string]any, error) {
+	args := map[string]any{}
+	for _, kv := range list {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("expected NAME=VALUE, got %q", kv)
+		}
+		prop, known := tool.InputSchema.Properties[name]
+		if known && prop.Type == "string" {
+			args[name] = value
+			continue
+		}
+		var v any
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			if known {
+				return nil, fmt.Errorf("%s: expected a %s in JSON, got %q", name, prop.Type, value)
+			}
+			v = value
+		}
+		args[name] = v
+	}
+	return args, nil
+}
+
+func cmdJobs(cl *cliClient, args []string, status, tool string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "jobs takes no arguments")
+		return 2
+	}
+	callArgs := map[string]any{}
+	if status != "" {
+		callArgs["status"] = status
+	}
+	if tool != "" {
+		callArgs["tool"] = tool
+	}
+	res, err := cl.CallTool("list_jobs", callArgs)
+	if err != nil || res.IsError {
+		return cl.printResult(res, err)
+	}
+	var list struct {
+		Jobs []client.Job `json:"jobs"`
+	}
+	if err := json.Unmarshal(res.Structured, &list); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if cl.json {
+		printJSON(list.Jobs)
+		return 0
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTOOL\tSTATUS\tEXIT\tHOST\tAGE")
+	for _, job := range list.Jobs {
+		exit, host := "", job.Host
+		if job.ExitCode != nil {
+			exit = fmt.Sprint(*job.ExitCode)
+		}
+		if host == "" {
+			host = "local"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", job.ID, job.Tool, job.Status, exit, host, time.Since(job.QueuedAt).Round(time.Second))
+	}
+	tw.Flush()
+	return 0
+}
+
+func cmdJob(cl *cliClient, args []string, follow bool) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "job needs one job ID")
+		return 2
+	}
+	if follow {
+		return cl.follow(args[0])
+	}
+	return cl.printResult(cl.CallTool("check_job_status", map[string]any{"jobId": args[0]}))
+}
+
+// followPoll is how long each wait_job call of follow blocks
+const followPoll = 2
+
+// follow prints a job's output as it arrives until the job finishes and
+// returns 0 if it succeeded. Prompts are answered from the terminal.
+func (cl *cliClient) follow(jobID string) int {
+	var printed string
+	var answered time.Time
+	for {
+		res, err := cl.CallTool("wait_job", map[string]any{"jobId": jobID, "maxWaitSeconds": followPoll})
+		if err != nil || res.IsError {
+			return cl.printResult(res, err)
+		}
+		var job client.Job
+		if err := json.Unmarshal(res.Structured, &job); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+
+		if !cl.json {
+			fmt.Print(newOutput(printed, job.Output))
+			printed = job.Output
+		}
+		if job.Done() {
+			if cl.json {
+				printJSON(job)
+			} else {
+				if printed != "" && !strings.HasSuffix(printed, "\n") {
+					fmt.Println()
+				}
+				if job.Stderr != "" {
+					fmt.Fprint(os.Stderr, job.Stderr)
+				}
+				if job.ExitCode != nil {
+					fmt.Fprintf(os.Stderr, "Job %s %s with exit code %d\n", job.ID, job.Status, *job.ExitCode)
+				}
+			}
+			if job.Succeeded() {
+				return 0
+			}
+			return 1
+		}
+
+		if p := job.Prompt; p != nil {
+			if p.Since.After(answered) {
+				answered = p.Since
+				if err := cl.answer(jobID, p); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					return 1
+				}
+			}
+			// wait_job returns at once while the prompt is open
+			time.Sleep(followPoll * time.Second)
+		}
+	}
+}
+
+// answer asks the user at the terminal to answer a job's prompt. Without
+// a terminal it only reports the prompt, which another client can answer.
+func (cl *cliClient) answer(jobID string, p *client.Prompt) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		fmt.Fprintf(os.Stderr, "Job %s is waiting for input: %s\n", jobID, p.Text)
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "\n%s ", strings.TrimSpace(p.Text))
+	var line string
+	if p.Sensitive {
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+		line = string(b)
+	} else {
+		if cl.stdin == nil {
+			cl.stdin = bufio.NewReader(os.Stdin)
+		}
+		var err error
+		if line, err = cl.stdin.ReadString('\n'); err != nil && line == "" {
+			return err
+		}
+	}
+
+	res, err := cl.CallTool("send_job_input", map[string]any{
+		"jobId":  jobID,
+		"input":  strings.TrimRight(line, "\r\n"),
+		"secret": p.Sensitive,
+	})
+	if err == nil && res.IsError {
+		err = errors.New(res.Text)
+	}
+	return err
+}
+
+// newOutput returns what a job's output tail cur adds to the tail prev
+// printed earlier. The tail drops old lines as new ones arrive.
+func newOutput(prev, cur string) string {
+	lines := strings.SplitAfter(prev, "\n")
+	for i := range lines {
+		if rest := strings.Join(lines[i:], ""); rest != "" && strings.HasPrefix(cur, rest) {
+			return cur[len(rest):]
+		}
+	}
+	return cur
+}
+
+// printResult prints a tool call's result and returns the exit code for
+// it: 1 for errors, and a script's own exit code
+func (cl *cliClient) printResult(res cliResult, err error) int {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if res.IsError {
+		fmt.Fprintln(os.Stderr, strings.TrimSpace(res.Text))
+		return 1
+	}
+
+	if cl.json {
+		if len(res.Structured) > 0 {
+			printJSON(res.Structured)
+		} else {
+			printJSON(map[string]string{"text": res.Text})
+		}
+	} else {
+		fmt.Println(strings.TrimRight(res.Text, "\n"))
+	}
+
+	var run struct {
+		JobID    string `json:"jobId"`
+		ExitCode *int   `json:"exitCode"`
+	}
+	if json.Unmarshal(res.Structured, &run) == nil && run.JobID == "" && run.ExitCode != nil && *run.ExitCode != 0 {
+		return min(*run.ExitCode, 255)
+	}
+	return 0
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,450 @@
+package mcpserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultToolsDir holds tool definitions loaded alongside the built-in tools
+const DefaultToolsDir = "/etc/a2cmds-mcp/tools.d"
+
+// DefaultDefinedCheckInterval is the check interval of defined async
+// tools that do not set one
+const DefaultDefinedCheckInterval = 30 * time.Second
+
+// ToolDefinition is a tool defined in a YAML or JSON file: its schema,
+// and how its arguments become the script's command line. The command
+// line is subcommand, then flags, then args in the order listed.
+type ToolDefinition struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Mode is sync, which returns the output, or async, which starts a job
+	Mode string `yaml:"mode"`
+	// Command is the script, found in PATH or through binaries
+	Command    string `yaml:"command"`
+	Subcommand string `yaml:"subcommand"`
+	// Flags are always passed, such as -ni
+	Flags []string        `yaml:"flags"`
+	Args  []ArgDefinition `yaml:"args"`
+	// Locks are lock keys such as apache-config, fqdn:{domain} or
+	// registrar:{registrar}, where {name} is an argument's value
+	Locks []string `yaml:"locks"`
+	// Timeout stops the script when it runs longer; 0 means no limit
+	Timeout       time.Duration `yaml:"timeout"`
+	CheckInterval time.Duration `yaml:"checkInterval"`
+	// PTY runs the script with a terminal, for scripts that write to /dev/tty
+	PTY bool `yaml:"pty"`
+
+	path string
+	rule argvRule
+}
+
+// ArgDefinition is one argument of a defined tool
+type ArgDefinition struct {
+	Name string `yaml:"name"`
+	// Type is string, boolean or integer
+	Type        string   `yaml:"type"`
+	Description string   `yaml:"description"`
+	Required    bool     `yaml:"required"`
+	Default     any      `yaml:"default"`
+	Enum        []string `yaml:"enum"`
+	// Flag comes before the value, or for a boolean is passed when it is
+	// true. Without one the value is a positional argument.
+	Flag string `yaml:"flag"`
+	// Check validates a string value: domain, domains, name, port, path
+	// or text, the default
+	Check string `yaml:"check"`
+}
+
+// Tool modes
+const (
+	ToolModeSync  = "sync"
+	ToolModeAsync = "async"
+)
+
+var (
+	toolNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+	flagPattern     = regexp.MustCompile(`^--?[A-Za-z0-9][A-Za-z0-9_-]*$`)
+	lockArgPattern  = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+)
+
+// valueChecks are the checks an argument can ask for by name
+var valueChecks = map[string]argCheck{
+	"domain":  checkDomain,
+	"domains": checkDomains,
+	"name":    checkName,
+	"port":    checkPort,
+	"path":    checkPath,
+	"text":    checkText,
+}
+
+// checkText allows a single-line value that cannot be taken for an option
+func checkText(v string) error {
+	if v == "" || len(v) > 1024 || strings.HasPrefix(v, "-") || strings.ContainsAny(v, "\x00\n\r") {
+		return fmt.Errorf("invalid value %q", v)
+	}
+	return nil
+}
+
+func checkInteger(v string) error {
+	if _, err := strconv.ParseInt(v, 10, 64); err != nil || strings.HasPrefix(v, "-") {
+		return fmt.Errorf("invalid number %q", v)
+	}
+	return nil
+}
+
+// reservedArgs are added to tools by the server
+var reservedArgs = map[string]bool{
+	"host": true, "priority": true, "notify": true, "idempotencyKey": true, "interactive": true,
+}
+
+// LoadToolDefinitions reads every *.yaml, *.yml and *.json file in dir.
+// A missing directory has no definitions.
+func LoadToolDefinitions(dir string) (map[string]*ToolDefinition, error) {
+	defs := make(map[string]*ToolDefinition)
+	if dir == "" {
+		return defs, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return defs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	builtin := make(map[string]bool)
+	for _, t := range append(builtinTools(), registeredTools()...) {
+		builtin[t.Name] = true
+	}
+	for _, e := range entries {
+		switch filepath.Ext(e.Name()) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		def, err := loadToolDefinition(path)
+		if err != nil {
+			return nil, err
+		}
+		if builtin[def.Name] {
+			return nil, fmt.Errorf("%s: %s is a built-in tool", path, def.Name)
+		}
+		if other, ok := defs[def.Name]; ok {
+			return nil, fmt.Errorf("%s: %s is already defined in %s", path, def.Name, other.path)
+		}
+		defs[def.Name] = def
+	}
+	return defs, nil
+}
+
+func loadToolDefinition(path string) (*ToolDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	def := &ToolDefinition{path: path}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(def); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("empty file")
+		}
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := def.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return def, nil
+}
+
+// compile checks a definition and builds its argument grammar
+func (d *ToolDefinition) compile() error {
+	if !toolNamePattern.MatchString(d.Name) {
+		return fmt.Errorf("invalid tool name %q", d.Name)
+	}
+	if d.Description == "" {
+		return fmt.Errorf("%s: description is required", d.Name)
+	}
+	switch d.Mode {
+	case ToolModeSync:
+	case ToolModeAsync:
+		if d.CheckInterval == 0 {
+			d.CheckInterval = DefaultDefinedCheckInterval
+		}
+	default:
+		return fmt.Errorf("%s: mode must be %s or %s", d.Name, ToolModeSync, ToolModeAsync)
+	}
+	if err := checkName(d.Command); err != nil {
+		return fmt.Errorf("%s: command: %v", d.Name, err)
+	}
+	if d.Timeout < 0 || d.CheckInterval < 0 {
+		return fmt.Errorf("%s: timeout and checkInterval cannot be negative", d.Name)
+	}
+
+	d.rule = argvRule{Script: d.Command, Subcommand: d.Subcommand, Flags: make(map[string]argCheck)}
+	if d.Subcommand != "" {
+		if err := checkName(d.Subcommand); err != nil {
+			return fmt.Errorf("%s: subcommand: %v", d.Name, err)
+		}
+	}
+	addFlag := func(flag string, check argCheck) error {
+		if !flagPattern.MatchString(flag) {
+			return fmt.Errorf("%s: invalid flag %q", d.Name, flag)
+		}
+		if _, dup := d.rule.Flags[flag]; dup {
+			return fmt.Errorf("%s: flag %s is used twice", d.Name, flag)
+		}
+		d.rule.Flags[flag] = check
+		return nil
+	}
+	for _, flag := range d.Flags {
+		if err := addFlag(flag, nil); err != nil {
+			return err
+		}
+	}
+
+	seen := make(map[string]bool)
+	optionalPositional := false
+	for i := range d.Args {
+		a := &d.Args[i]
+		if !toolNamePattern.MatchString(a.Name) || reservedArgs[a.Name] || seen[a.Name] {
+			return fmt.Errorf("%s: invalid or duplicate argument name %q", d.Name, a.Name)
+		}
+		seen[a.Name] = true
+
+		check, err := a.compile()
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", d.Name, a.Name, err)
+		}
+		if a.Flag != "" {
+			if err := addFlag(a.Flag, check); err != nil {
+				return err
+			}
+			continue
+		}
+		if a.Type == "boolean" {
+			return fmt.Errorf("%s: %s: a boolean needs a flag", d.Name, a.Name)
+		}
+		// A missing positional would shift the ones after it
+		if optionalPositional && a.Required {
+			return fmt.Errorf("%s: %s: required positional arguments must come before optional ones", d.Name, a.Name)
+		}
+		optionalPositional = optionalPositional || !a.Required
+		d.rule.Positional = append(d.rule.Positional, check)
+	}
+
+	for _, lock := range d.Locks {
+		for _, m := range lockArgPattern.FindAllStringSubmatch(lock, -1) {
+			if !seen[m[1]] {
+				return fmt.Errorf("%s: lock %s: unknown argument %s", d.Name, lock, m[1])
+			}
+		}
+	}
+	return nil
+}
+
+// compile checks an argument and returns the check for its value, nil
+// for a boolean
+func (a *ArgDefinition) compile() (argCheck, error) {
+	if a.Type == "" {
+		a.Type = "string"
+	}
+	if a.Default != nil {
+		if err := checkDefaultType(a.property(), a.Default); err != nil {
+			return nil, fmt.Errorf("default %v", err)
+		}
+	}
+	switch a.Type {
+	case "boolean":
+		if len(a.Enum) > 0 || a.Check != "" {
+			return nil, errors.New("a boolean takes no enum or check")
+		}
+		return nil, nil
+	case "integer":
+		if len(a.Enum) > 0 || a.Check != "" {
+			return nil, errors.New("an integer takes no enum or check")
+		}
+		return checkInteger, nil
+	case "string":
+		if len(a.Enum) > 0 {
+			for _, v := range a.Enum {
+				if err := checkText(v); err != nil {
+					return nil, fmt.Errorf("enum: %v", err)
+				}
+			}
+			return checkOneOf(a.Enum...), nil
+		}
+		if a.Check == "" {
+			a.Check = "text"
+		}
+		check, ok := valueChecks[a.Check]
+		if !ok {
+			return nil, fmt.Errorf("unknown check %q", a.Check)
+		}
+		return check, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", a.Type)
+	}
+}
+
+func (a *ArgDefinition) property() Property {
+	return Property{Type: a.Type, Description: a.Description, Enum: a.Enum, Default: a.Default}
+}
+
+// Tool is the definition's entry in tools/list
+func (d *ToolDefinition) Tool() Tool {
+	props := map[string]Property{"host": hostProperty}
+	required := []string{}
+	for _, a := range d.Args {
+		props[a.Name] = a.property()
+		if a.Required {
+			required = append(required, a.Name)
+		}
+	}
+	if d.Mode == ToolModeAsync {
+		props["priority"] = priorityProperty
+		props["notify"] = notifyProperty
+		props["idempotencyKey"] = idempotencyKeyProperty
+	}
+	return Tool{
+		Name:        d.Name,
+		Description: d.Description,
+		InputSchema: InputSchema{Type: "object", Properties: props, Required: required},
+	}
+}
+
+// build turns call arguments into the command to run
+func (d *ToolDefinition) build(args map[string]any) (JobSpec, error) {
+	cmdArgs := []string{}
+	if d.Subcommand != "" {
+		cmdArgs = append(cmdArgs, d.Subcommand)
+	}
+	cmdArgs = append(cmdArgs, d.Flags...)
+
+	values := make(map[string]string)
+	skipped := ""
+	for _, a := range d.Args {
+		v, set := a.value(args)
+		if !set {
+			if a.Required {
+				return JobSpec{}, fmt.Errorf("%s is required", a.Name)
+			}
+			if a.Flag == "" && skipped == "" {
+				skipped = a.Name
+			}
+			continue
+		}
+		if a.Flag == "" && skipped != "" {
+			return JobSpec{}, fmt.Errorf("%s needs %s", a.Name, skipped)
+		}
+		values[a.Name] = v
+		switch {
+		case a.Type == "boolean":
+			if v == "true" {
+				cmdArgs = append(cmdArgs, a.Flag)
+			}
+		case a.Flag != "":
+			cmdArgs = append(cmdArgs, a.Flag, v)
+		default:
+			cmdArgs = append(cmdArgs, v)
+		}
+	}
+
+	var locks []string
+	for _, tmpl := range d.Locks {
+		locks = append(locks, lockKeys(tmpl, values)...)
+	}
+	return JobSpec{Name: d.Command, Args: cmdArgs, Locks: locks, Timeout: d.Timeout, PTY: d.PTY}, nil
+}
+
+// value returns an argument's value as it goes on the command line
+func (a *ArgDefinition) value(args map[string]any) (string, bool) {
+	v, ok := args[a.Name]
+	if !ok || v == nil {
+		v = a.Default
+	}
+	switch a.Type {
+	case "boolean":
+		b, ok := v.(bool)
+		return strconv.FormatBool(b), ok
+	case "integer":
+		switch n := v.(type) {
+		case float64:
+			return strconv.FormatInt(int64(n), 10), true
+		case int:
+			return strconv.Itoa(n), true
+		}
+		return "", false
+	default:
+		s, ok := v.(string)
+		s = strings.TrimSpace(s)
+		return s, ok && s != ""
+	}
+}
+
+// lockKeys expands a lock template. fqdn: locks take a domain list and
+// give one key per domain; a template with an empty argument gives none.
+func lockKeys(tmpl string, values map[string]string) []string {
+	if m := lockArgPattern.FindStringSubmatch(tmpl); m != nil {
+		switch tmpl {
+		case "fqdn:" + m[0]:
+			return domainLocks(values[m[1]])
+		case "registrar:" + m[0]:
+			if values[m[1]] == "" {
+				return nil
+			}
+			return []string{registrarLock(values[m[1]])}
+		}
+	}
+	empty := false
+	key := lockArgPattern.ReplaceAllStringFunc(tmpl, func(m string) string {
+		v := values[m[1:len(m)-1]]
+		empty = empty || v == ""
+		return v
+	})
+	if empty {
+		return nil
+	}
+	return []string{strings.ToLower(key)}
+}
+
+// execute runs a call to the tool
+func (d *ToolDefinition) execute(args map[string]any) ToolCallResult {
+	if d.Mode == ToolModeAsync {
+		return startToolJob(d.Name, args, checkInterval(d.Name))
+	}
+	return runToolSync(d.Name, args)
+}
+
+// definedTool returns the definition of a tool loaded from the tools
+// directory, or nil
+func definedTool(name string) *ToolDefinition {
+	return config().definitions[name]
+}
+
+// definedTools lists the loaded definitions' tools by name
+func definedTools(defs map[string]*ToolDefinition) []Tool {
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tools := make([]Tool, 0, len(names))
+	for _, name := range names {
+		tools = append(tools, defs[name].Tool())
+	}
+	return tools
+}
@@ -0,0 +1,207 @@
+package mcpserver
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// probeDefinition is the example definition from the README
+const probeDefinition = `
+name: a2probe_site
+description: Probe a site's vhost configuration
+mode: sync
+command: a2probe
+subcommand: site
+flags: [-ni]
+args:
+  - name: domain
+    description: Domain to probe
+    required: true
+    check: domain
+  - name: port
+    type: integer
+    flag: -p
+    default: 443
+  - name: overwrite
+    type: boolean
+    flag: -o
+locks: ["fqdn:{domain}", apache-config]
+timeout: 5m
+`
+
+// compileDefinition decodes and compiles a definition written in YAML
+func compileDefinition(t *testing.T, src string) (*ToolDefinition, error) {
+	t.Helper()
+	def := &ToolDefinition{}
+	dec := yaml.NewDecoder(strings.NewReader(src))
+	dec.KnownFields(true)
+	if err := dec.Decode(def); err != nil {
+		t.Fatalf("decoding definition: %v", err)
+	}
+	return def, def.compile()
+}
+
+func TestToolDefinitionCompileErrors(t *testing.T) {
+	base := "name: t\ndescription: d\nmode: sync\ncommand: a2probe\n"
+
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{"bad name", "name: 1t\ndescription: d\nmode: sync\ncommand: a2probe\n", "invalid tool name"},
+		{"no description", "name: t\nmode: sync\ncommand: a2probe\n", "description is required"},
+		{"bad mode", "name: t\ndescription: d\nmode: later\ncommand: a2probe\n", "mode must be"},
+		{"bad command", "name: t\ndescription: d\nmode: sync\ncommand: ../bin/sh\n", "command:"},
+		{"negative timeout", base + "timeout: -1s\n", "cannot be negative"},
+		{"bad flag", base + "flags: [ni]\n", "invalid flag"},
+		{"flag twice", base + "flags: [-v]\nargs:\n  - {name: verbose, type: boolean, flag: -v}\n", "used twice"},
+		{"reserved arg", base + "args:\n  - {name: host}\n", "invalid or duplicate argument"},
+		{"duplicate arg", base + "args:\n  - {name: a}\n  - {name: a}\n", "invalid or duplicate argument"},
+		{"unknown type", base + "args:\n  - {name: a, type: float}\n", "unknown type"},
+		{"unknown check", base + "args:\n  - {name: a, check: url}\n", "unknown check"},
+		{"boolean enum", base + "args:\n  - {name: a, type: boolean, flag: -a, enum: [x]}\n", "takes no enum"},
+		{"boolean positional", base + "args:\n  - {name: a, type: boolean}\n", "needs a flag"},
+		{"bad default", base + "args:\n  - {name: a, type: integer, default: many}\n", "default"},
+		{"bad enum", base + "args:\n  - {name: a, enum: [-x]}\n", "enum:"},
+		{"required after optional", base + "args:\n  - {name: a}\n  - {name: b, required: true}\n", "must come before optional"},
+		{"unknown lock arg", base + "args:\n  - {name: a}\nlocks: [\"fqdn:{b}\"]\n", "unknown argument b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileDefinition(t, tt.src)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("compile() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestToolDefinitionCompile(t *testing.T) {
+	def, err := compileDefinition(t, probeDefinition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.rule.Script != "a2probe" || def.rule.Subcommand != "site" || len(def.rule.Positional) != 1 {
+		t.Errorf("rule = %+v", def.rule)
+	}
+	for _, flag := range []string{"-ni", "-p", "-o"} {
+		if _, ok := def.rule.Flags[flag]; !ok {
+			t.Errorf("rule does not allow %s", flag)
+		}
+	}
+
+	async, err := compileDefinition(t, "name: t\ndescription: d\nmode: async\ncommand: a2probe\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if async.CheckInterval != DefaultDefinedCheckInterval {
+		t.Errorf("async CheckInterval = %s, want %s", async.CheckInterval, DefaultDefinedCheckInterval)
+	}
+}
+
+func TestToolDefinitionBuild(t *testing.T) {
+	def, err := compileDefinition(t, probeDefinition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	argvRules[def.Name] = def.rule
+	t.Cleanup(func() { delete(argvRules, def.Name) })
+
+	tests := []struct {
+		name      string
+		args      map[string]any
+		wantArgs  []string
+		wantLocks []string
+		wantErr   string
+	}{
+		{
+			name:      "defaults",
+			args:      map[string]any{"domain": "Example.com"},
+			wantArgs:  []string{"site", "-ni", "Example.com", "-p", "443"},
+			wantLocks: []string{"fqdn:example.com", "apache-config"},
+		},
+		{
+			name:      "all arguments",
+			args:      map[string]any{"domain": " example.com ", "port": float64(8443), "overwrite": true},
+			wantArgs:  []string{"site", "-ni", "example.com", "-p", "8443", "-o"},
+			wantLocks: []string{"fqdn:example.com", "apache-config"},
+		},
+		{
+			name:      "boolean false",
+			args:      map[string]any{"domain": "example.com", "overwrite": false},
+			wantArgs:  []string{"site", "-ni", "example.com", "-p", "443"},
+			wantLocks: []string{"fqdn:example.com", "apache-config"},
+		},
+		{
+			name:    "missing required",
+			args:    map[string]any{"port": float64(80)},
+			wantErr: "domain is required",
+		},
+		{
+			name:    "empty required",
+			args:    map[string]any{"domain": "  "},
+			wantErr: "domain is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := def.build(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("build() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if spec.Name != "a2probe" || !slices.Equal(spec.Args, tt.wantArgs) {
+				t.Errorf("build() runs %s %q, want a2probe %q", spec.Name, spec.Args, tt.wantArgs)
+			}
+			if !slices.Equal(spec.Locks, tt.wantLocks) {
+				t.Errorf("build() locks %q, want %q", spec.Locks, tt.wantLocks)
+			}
+			// What a definition builds, its own grammar must allow
+			if err := validateArgv(def.Name, spec.Name, spec.Args); err != nil {
+				t.Errorf("validateArgv: %v", err)
+			}
+		})
+	}
+}
+
+func TestToolDefinitionBuildSkippedPositional(t *testing.T) {
+	def, err := compileDefinition(t, "name: t\ndescription: d\nmode: sync\ncommand: a2probe\nargs:\n  - {name: a}\n  - {name: b}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := def.build(map[string]any{"b": "x"}); err == nil || !strings.Contains(err.Error(), "b needs a") {
+		t.Errorf("build() = %v, want an error saying b needs a", err)
+	}
+}
+
+func TestLockKeys(t *testing.T) {
+	values := map[string]string{"domains": "a.com, B.com", "registrar": "Namecheap.com", "site": "Blog", "empty": ""}
+
+	tests := []struct {
+		tmpl string
+		want []string
+	}{
+		{"apache-config", []string{"apache-config"}},
+		{"fqdn:{domains}", []string{"fqdn:a.com", "fqdn:b.com"}},
+		{"registrar:{registrar}", []string{"registrar:namecheap.com"}},
+		{"registrar:{empty}", nil},
+		{"site:{site}", []string{"site:blog"}},
+		{"site:{empty}", nil},
+	}
+	for _, tt := range tests {
+		if got := lockKeys(tt.tmpl, values); !slices.Equal(got, tt.want) {
+			t.Errorf("lockKeys(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
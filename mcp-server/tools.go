@@ -1,4 +1,4 @@
-package main
+package mcpserver
 
 // Tool represents an MCP tool definition
 type Tool struct {
@@ -18,10 +18,67 @@ type Property struct {
 	Description string   `json:"description"`
 	Enum        []string `json:"enum,omitempty"`
 	Default     any      `json:"default,omitempty"`
+
+	// Nested schemas for array and object properties
+	Items      *Property           `json:"items,omitempty"`
+	Properties map[string]Property `json:"properties,omitempty"`
+	Required   []string            `json:"required,omitempty"`
+}
+
+// notifyProperty is accepted by every async tool
+var notifyProperty = Property{
+	Type:        "object",
+	Description: "Report the job when it finishes. One target, or an array of targets, each with exactly one of webhook or email.",
+	Properties: map[string]Property{
+		"webhook": {Type: "string", Description: "URL to POST the job summary to as JSON; its host must be allowed by the server configuration"},
+		"email":   {Type: "string", Description: "Address to mail the job summary to via local sendmail; its domain must be allowed by the server configuration"},
+		"on": {
+			Type:        "string",
+			Description: "When to notify",
+			Enum:        []string{NotifyOnAlways, NotifyOnSuccess, NotifyOnFailure},
+			Default:     NotifyOnAlways,
+		},
+	},
+}
+
+// idempotencyKeyProperty is accepted by every async tool that runs a script
+var idempotencyKeyProperty = Property{
+	Type:        "string",
+	Description: "Repeating a call with the same key returns the original job and its result instead of running the tool again. fqdnmgr_purchase and fqdnmgr_setInitDNSRecords derive a key from their arguments when none is given.",
+}
+
+// interactiveProperty is accepted by the async tools whose scripts can
+// ask questions
+var interactiveProperty = Property{
+	Type:        "boolean",
+	Description: "Run without -ni so the script may prompt. The job then waits for answers, which check_job_status and wait_job show; reply with send_job_input.",
+	Default:     false,
+}
+
+// hostProperty is accepted by every tool that runs a script
+var hostProperty = Property{
+	Type:        "string",
+	Description: "Inventory host to run the script on over SSH; omit or use \"local\" for this machine",
+}
+
+// priorityProperty is accepted by every async tool
+var priorityProperty = Property{
+	Type:        "string",
+	Description: "Queue priority when workers are busy",
+	Enum:        PriorityNames,
+	Default:     "normal",
 }
 
-// GetAllTools returns all available MCP tools
+// GetAllTools returns all available MCP tools: the built-in ones, those
+// registered by an embedding program, then those defined in the tools
+// directory
 func GetAllTools() []Tool {
+	tools := append(builtinTools(), registeredTools()...)
+	return append(tools, definedTools(config().definitions)...)
+}
+
+// builtinTools returns the tools compiled into the server
+func builtinTools() []Tool {
 	return []Tool{
 		// a2sitemgr - Apache2 Site Manager (async)
 		{
@@ -68,6 +125,11 @@ func GetAllTools() []Tool {
 						Description: "Enable verbose output",
 						Default:     true,
 					},
+					"interactive":    interactiveProperty,
+					"priority":       priorityProperty,
+					"notify":         notifyProperty,
+					"idempotencyKey": idempotencyKeyProperty,
+					"host":           hostProperty,
 				},
 				Required: []string{"fqdn"},
 			},
@@ -93,6 +155,7 @@ func GetAllTools() []Tool {
 						Description: "Enable verbose output",
 						Default:     false,
 					},
+					"host": hostProperty,
 				},
 				Required: []string{"fqdn"},
 			},
@@ -118,6 +181,10 @@ func GetAllTools() []Tool {
 						Description: "Enable verbose output",
 						Default:     true,
 					},
+					"priority":       priorityProperty,
+					"notify":         notifyProperty,
+					"idempotencyKey": idempotencyKeyProperty,
+					"host":           hostProperty,
 				},
 				Required: []string{"fqdn", "registrar"},
 			},
@@ -145,11 +212,28 @@ func GetAllTools() []Tool {
 						Description: "Enable verbose output",
 						Default:     false,
 					},
+					"host": hostProperty,
 				},
 				Required: []string{},
 			},
 		},
 
+		// list_domains - List domains.db with certificate expiry (sync)
+		{
+			Name:        "list_domains",
+			Description: "List every domain in the local domains database with its status, registrar, whether its initial DNS records are set, and when its certificate was issued and expires. Certificates are renewed by a2certrenew with 10 of their 90 days left.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"expiringWithinDays": {
+						Type:        "integer",
+						Description: "Only list domains whose certificate expires within this many days, or has expired",
+					},
+					"host": hostProperty,
+				},
+			},
+		},
+
 		// fqdnmgr_setInitDNSRecords - Set initial DNS records (async)
 		{
 			Name:        "fqdnmgr_setInitDNSRecords",
@@ -159,7 +243,7 @@ func GetAllTools() []Tool {
 				Properties: map[string]Property{
 					"domains": {
 						Type:        "string",
-						Description: "Space-separated list of domains (e.g., 'example.com example.org')",
+						Description: "Space-separated list of domains (e.g., 'example.com example.org'). With interactive and verbose, omit it to pick from the registrar's domains.",
 					},
 					"registrar": {
 						Type:        "string",
@@ -175,8 +259,13 @@ func GetAllTools() []Tool {
 						Description: "Enable verbose output (shows propagation progress)",
 						Default:     true,
 					},
+					"interactive":    interactiveProperty,
+					"priority":       priorityProperty,
+					"notify":         notifyProperty,
+					"idempotencyKey": idempotencyKeyProperty,
+					"host":           hostProperty,
 				},
-				Required: []string{"domains", "registrar"},
+				Required: []string{"registrar"},
 			},
 		},
 
@@ -196,6 +285,7 @@ func GetAllTools() []Tool {
 						Description: "Enable verbose output",
 						Default:     false,
 					},
+					"host": hostProperty,
 				},
 				Required: []string{"fqdn"},
 			},
@@ -217,6 +307,7 @@ func GetAllTools() []Tool {
 						Description: "Enable verbose output",
 						Default:     false,
 					},
+					"host": hostProperty,
 				},
 				Required: []string{"provider"},
 			},
@@ -234,6 +325,7 @@ func GetAllTools() []Tool {
 						Description: "Enable verbose output",
 						Default:     false,
 					},
+					"host": hostProperty,
 				},
 				Required: []string{},
 			},
@@ -250,6 +342,7 @@ func GetAllTools() []Tool {
 						Type:        "string",
 						Description: "Specific wildcard domain to process (e.g., 'mail.*'). Processes all if omitted.",
 					},
+					"host": hostProperty,
 				},
 				Required: []string{},
 			},
@@ -265,8 +358,9 @@ func GetAllTools() []Tool {
 					"dmsDir": {
 						Type:        "string",
 						Description: "Path to docker-mailserver directory",
-						Default:     "/opt/compose/docker-mailserver",
+						Default:     config().DMSDir,
 					},
+					"host": hostProperty,
 				},
 				Required: []string{},
 			},
@@ -276,6 +370,114 @@ func GetAllTools() []Tool {
 		{
 			Name:        "a2certrenew",
 			Description: "Check and renew SSL certificates that are expiring within 10 days. Returns a jobId for async tracking.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"priority":       priorityProperty,
+					"notify":         notifyProperty,
+					"idempotencyKey": idempotencyKeyProperty,
+					"host":           hostProperty,
+				},
+				Required: []string{},
+			},
+		},
+
+		// run_pipeline - Run dependent tool calls as one job (async)
+		{
+			Name:        "run_pipeline",
+			Description: "Run a sequence of tool calls as one parent job. Steps start when the steps they depend on finish; each step runs as a child job. Returns a jobId; check_job_status reports per-step status.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"steps": {
+						Type:        "array",
+						Description: "Pipeline steps",
+						Items: &Property{
+							Type:        "object",
+							Description: "A tool call and its place in the pipeline",
+							Properties: map[string]Property{
+								"id": {
+									Type:        "string",
+									Description: "Step id used in dependsOn (defaults to stepN)",
+								},
+								"tool": {
+									Type:        "string",
+									Description: "Tool to run (any script-backed tool, e.g. fqdnmgr_purchase)",
+								},
+								"arguments": {
+									Type:        "object",
+									Description: "Arguments for the tool, as in a direct call",
+								},
+								"dependsOn": {
+									Type:        "array",
+									Description: "Ids of steps that must finish first",
+									Items:       &Property{Type: "string", Description: "Step id"},
+								},
+								"onFailure": {
+									Type:        "string",
+									Description: "abort: start no further steps; skip: skip dependents only; continue: run dependents anyway",
+									Enum:        []string{OnFailureAbort, OnFailureSkip, OnFailureContinue},
+									Default:     OnFailureAbort,
+								},
+								"poll": {
+									Type:        "object",
+									Description: "Rerun the step until it succeeds (e.g. fqdnmgr_checkInitDns until propagated)",
+									Properties: map[string]Property{
+										"intervalSeconds": {
+											Type:        "integer",
+											Description: "Seconds between attempts (minimum 5)",
+											Default:     30,
+										},
+										"timeoutSeconds": {
+											Type:        "integer",
+											Description: "Give up after this many seconds",
+											Default:     1800,
+										},
+									},
+								},
+							},
+							Required: []string{"tool"},
+						},
+					},
+					"priority": priorityProperty,
+					"notify":   notifyProperty,
+				},
+				Required: []string{"steps"},
+			},
+		},
+
+		// schedule_create - Create a recurring job (sync)
+		{
+			Name:        "schedule_create",
+			Description: "Run a tool on a cron schedule (e.g. a2certrenew daily). Each run is a normal tool call; the jobs it starts are visible through check_job_status.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"cron": {
+						Type:        "string",
+						Description: "Cron expression in server local time: minute hour day month weekday (e.g. '30 3 * * *'), or @hourly/@daily/@weekly/@monthly",
+					},
+					"tool": {
+						Type:        "string",
+						Description: "Tool to run (any tool except the schedule tools, e.g. a2certrenew or run_pipeline)",
+					},
+					"arguments": {
+						Type:        "object",
+						Description: "Arguments for the tool, as in a direct call",
+					},
+					"name": {
+						Type:        "string",
+						Description: "Optional label for the schedule",
+					},
+				},
+				Required: []string{"cron", "tool"},
+			},
+		},
+
+		// schedule_list - List recurring jobs (sync)
+		{
+			Name:        "schedule_list",
+			Description: "List schedules with their last and next run.",
 			InputSchema: InputSchema{
 				Type:       "object",
 				Properties: map[string]Property{},
@@ -283,6 +485,22 @@ func GetAllTools() []Tool {
 			},
 		},
 
+		// schedule_delete - Delete a recurring job (sync)
+		{
+			Name:        "schedule_delete",
+			Description: "Delete a schedule. Jobs it already started are not affected.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"scheduleId": {
+						Type:        "string",
+						Description: "Schedule ID returned by schedule_create",
+					},
+				},
+				Required: []string{"scheduleId"},
+			},
+		},
+
 		// check_job_status - Check async job status (sync)
 		{
 			Name:        "check_job_status",
@@ -298,5 +516,106 @@ func GetAllTools() []Tool {
 				Required: []string{"jobId"},
 			},
 		},
+
+		// list_jobs - List the server's jobs (sync)
+		{
+			Name:        "list_jobs",
+			Description: "List the server's jobs, newest first: queued and running ones, and finished ones until they expire. Output is left out; use check_job_status for a job's output.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"status": {
+						Type:        "string",
+						Description: "Only list jobs with this status",
+						Enum:        []string{"queued", "running", "completed", "failed"},
+					},
+					"tool": {
+						Type:        "string",
+						Description: "Only list jobs of this tool",
+					},
+				},
+			},
+		},
+
+		// cancel_job - Stop a queued or running job (sync)
+		{
+			Name:        "cancel_job",
+			Description: "Cancel a job: a queued job never starts, and a running job's script is killed and not retried. Cancelling a pipeline skips its remaining steps and cancels the running ones. The job ends as failed.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"jobId": {
+						Type:        "string",
+						Description: "Job ID returned by an async tool call",
+					},
+				},
+				Required: []string{"jobId"},
+			},
+		},
+
+		// wait_job - Block until a job finishes (sync)
+		{
+			Name:        "wait_job",
+			Description: "Wait for an async job instead of polling. Blocks until the job finishes, its output matches a pattern, or maxWaitSeconds elapses (at most 120), then returns the same status as check_job_status.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"jobId": {
+						Type:        "string",
+						Description: "Job ID returned by an async tool call",
+					},
+					"pattern": {
+						Type:        "string",
+						Description: "Regular expression; return as soon as the job's output or stderr matches it (e.g. 'PROPAGATED')",
+					},
+					"maxWaitSeconds": {
+						Type:        "integer",
+						Description: "Maximum time to block, 1-120 seconds",
+						Default:     60,
+					},
+				},
+				Required: []string{"jobId"},
+			},
+		},
+
+		// send_job_input - Answer a prompt of an interactive job (sync)
+		{
+			Name:        "send_job_input",
+			Description: "Send a line of input to a running job started with interactive: true, typically to answer the prompt check_job_status or wait_job shows. Returns the job's status a few seconds later.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"jobId": {
+						Type:        "string",
+						Description: "Job ID returned by an async tool call",
+					},
+					"input": {
+						Type:        "string",
+						Description: "Text to send, without a newline (e.g. '1,3-5' or 'all'). Empty sends just Enter.",
+					},
+					"newline": {
+						Type:        "boolean",
+						Description: "Append a newline, as pressing Enter does. Disable for single-key prompts.",
+						Default:     true,
+					},
+					"secret": {
+						Type:        "boolean",
+						Description: "Keep the input out of the job output",
+						Default:     false,
+					},
+					"eof": {
+						Type:        "boolean",
+						Description: "Close the job's input after sending, as Ctrl+D does",
+						Default:     false,
+					},
+					"target": {
+						Type:        "string",
+						Description: "Where to write: the job's stdin or its terminal. Defaults to where the current prompt was shown.",
+						Enum:        []string{InputStdin, InputTerminal},
+					},
+				},
+				Required: []string{"jobId"},
+			},
+		},
 	}
 }
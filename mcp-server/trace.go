@@ -0,0 +1,370 @@
+package mcpserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	traceServiceName   = "a2cmds-mcp"
+	traceBatchSize     = 100
+	traceFlushEvery    = 5 * time.Second
+	traceExportTimeout = 10 * time.Second
+)
+
+// traceArgKey carries the tools/call span into handlers inside the call
+// arguments, so jobs and commands they start become its children. It is
+// set by the server and never read from clients.
+const traceArgKey = "_traceparent"
+
+// SpanContext identifies a span across process boundaries
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// IsValid reports whether sc refers to a span
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats sc as a W3C traceparent header value
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]))
+}
+
+// parseTraceparent parses a W3C traceparent value
+func parseTraceparent(s string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	return sc, sc.IsValid()
+}
+
+// traceParentFromArgs returns the span context a handler should use as
+// the parent of what it starts
+func traceParentFromArgs(args map[string]any) SpanContext {
+	sc, _ := parseTraceparent(getString(args, traceArgKey, ""))
+	return sc
+}
+
+// Span is one timed operation. A nil *Span is valid and records nothing,
+// which is what StartSpan returns when tracing is off.
+type Span struct {
+	name   string
+	sc     SpanContext
+	parent [8]byte
+	start  time.Time
+
+	mu    sync.Mutex
+	attrs map[string]any
+	err   string
+	ended bool
+}
+
+// StartSpan starts a span under parent, or a new trace if parent is not
+// valid
+func StartSpan(name string, parent SpanContext, start time.Time) *Span {
+	if tracer == nil {
+		return nil
+	}
+	s := &Span{name: name, start: start, attrs: make(map[string]any)}
+	if parent.IsValid() {
+		s.sc.TraceID = parent.TraceID
+		s.parent = parent.SpanID
+	} else {
+		rand.Read(s.sc.TraceID[:])
+	}
+	rand.Read(s.sc.SpanID[:])
+	return s
+}
+
+// Context returns the span's context, or the zero context for a nil span
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttr sets a string, bool or integer attribute
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = msg
+	s.mu.Unlock()
+}
+
+// End finishes the span now
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt finishes the span at the given time and hands it to the exporter.
+// Only the first call counts.
+func (s *Span) EndAt(at time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	span := s.otlp(at)
+	s.mu.Unlock()
+
+	tracer.export(span)
+}
+
+// Tracer batches finished spans and exports them as OTLP/JSON, appended to
+// a file (one export request per line) or POSTed to a collector
+type Tracer struct {
+	file     string
+	endpoint string
+	spans    chan otlpSpan
+	flush    chan chan struct{}
+}
+
+// tracer is nil when tracing is off
+var tracer *Tracer
+
+// StartTracer enables tracing. file and endpoint may both be set.
+func StartTracer(file, endpoint string) {
+	tracer = &Tracer{
+		file:     file,
+		endpoint: endpoint,
+		spans:    make(chan otlpSpan, 4*traceBatchSize),
+		flush:    make(chan chan struct{}),
+	}
+	go tracer.loop()
+}
+
+func (t *Tracer) export(span otlpSpan) {
+	select {
+	case t.spans <- span:
+	default:
+		// Never block a tool call on a slow collector
+	}
+}
+
+func (t *Tracer) loop() {
+	ticker := time.NewTicker(traceFlushEvery)
+	defer ticker.Stop()
+
+	var batch []otlpSpan
+	for {
+		var flushed chan struct{}
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span)
+			if len(batch) < traceBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case flushed = <-t.flush:
+			for drained := false; !drained; {
+				select {
+				case span := <-t.spans:
+					batch = append(batch, span)
+				default:
+					drained = true
+				}
+			}
+		}
+		if len(batch) > 0 {
+			if err := t.write(batch); err != nil {
+				fmt.Fprintf(os.Stderr, "Trace export failed: %v\n", err)
+			}
+			batch = nil
+		}
+		if flushed != nil {
+			close(flushed)
+		}
+	}
+}
+
+// Flush exports every span that has ended so far
+func (t *Tracer) Flush() {
+	if t == nil {
+		return
+	}
+	done := make(chan struct{})
+	t.flush <- done
+	<-done
+}
+
+func (t *Tracer) write(batch []otlpSpan) error {
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttr{
+			{Key: "service.name", Value: otlpValue{StringValue: strPtr(traceServiceName)}},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: traceServiceName},
+			Spans: batch,
+		}},
+	}}}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	if t.file != "" {
+		f, err := os.OpenFile(t.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(append(data, '\n'))
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.endpoint != "" {
+		client := http.Client{Timeout: traceExportTimeout}
+		resp, err := client.Post(t.endpoint, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("collector returned %s", resp.Status)
+		}
+	}
+	return nil
+}
+
+// OTLP/JSON encoding; IDs are hex and times are nanosecond strings
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+	IntValue    string  `json:"intValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// OTLP span kind and status codes
+const (
+	otlpKindInternal = 1
+	otlpStatusOK     = 1
+	otlpStatusError  = 2
+)
+
+// otlp converts the span for export. Callers hold s.mu.
+func (s *Span) otlp(end time.Time) otlpSpan {
+	out := otlpSpan{
+		TraceID:           hex.EncodeToString(s.sc.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.sc.SpanID[:]),
+		Name:              s.name,
+		Kind:              otlpKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Status:            otlpStatus{Code: otlpStatusOK},
+	}
+	if s.parent != [8]byte{} {
+		out.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	if s.err != "" {
+		out.Status = otlpStatus{Code: otlpStatusError, Message: s.err}
+	}
+
+	keys := make([]string, 0, len(s.attrs))
+	for key := range s.attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var val otlpValue
+		switch v := s.attrs[key].(type) {
+		case string:
+			val.StringValue = strPtr(v)
+		case bool:
+			val.BoolValue = &v
+		case int:
+			val.IntValue = strconv.Itoa(v)
+		default:
+			val.StringValue = strPtr(fmt.Sprint(v))
+		}
+		out.Attributes = append(out.Attributes, otlpAttr{Key: key, Value: val})
+	}
+	return out
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,712 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/a2cmds/mcp-server/client"
+	"golang.org/x/term"
+)
+
+// tuiDomainsRefresh is how often the tui reloads the domains; it runs a
+// script on the server, so less often than the jobs
+const tuiDomainsRefresh = 30 * time.Second
+
+// Terminal escapes the tui draws with
+const (
+	sgrReset   = "\x1b[0m"
+	sgrBold    = "\x1b[1m"
+	sgrDim     = "\x1b[2m"
+	sgrReverse = "\x1b[7m"
+	sgrRed     = "\x1b[31m"
+	sgrGreen   = "\x1b[32m"
+	sgrYellow  = "\x1b[33m"
+	sgrCyan    = "\x1b[36m"
+)
+
+const (
+	tuiJobRow    = "%-8s  %-26s  %-9s  %4s  %-12s  %s"
+	tuiDomainRow = "%-30s  %-11s  %-16s  %-3s  %-10s  %-10s  %5s"
+)
+
+type tuiPane int
+
+const (
+	paneJobs tuiPane = iota
+	paneDomains
+)
+
+// tui is the state of the tui subcommand. Only its event loop touches
+// it; tool calls run in the background and hand their results back to
+// the loop through events.
+type tui struct {
+	cl      *cliClient
+	refresh time.Duration
+	events  chan func()
+
+	focus tuiPane
+
+	jobs           []client.Job
+	jobSel, jobTop int
+	// jobID is the selected job, kept across refreshes; job is its last
+	// status, with output
+	jobID string
+	job   *client.Job
+
+	domains        []client.Domain
+	domSel, domTop int
+	domainsErr     string
+	domainsAt      time.Time
+
+	jobsBusy, jobBusy, domainsBusy bool
+
+	message string
+	// confirm runs when the question in confirmText is answered with y;
+	// it returns true to quit
+	confirm     func() bool
+	confirmText string
+}
+
+func cmdTUI(cl *cliClient, args []string, refresh time.Duration) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "tui takes no arguments")
+		return 2
+	}
+	if refresh < 100*time.Millisecond {
+		fmt.Fprintln(os.Stderr, "-refresh must be at least 100ms")
+		return 2
+	}
+	in := int(os.Stdin.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Fprintln(os.Stderr, "tui needs a terminal")
+		return 2
+	}
+	state, err := term.MakeRaw(in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	// Draw on the alternate screen so the shell's screen comes back
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		term.Restore(in, state)
+	}()
+
+	t := &tui{cl: cl, refresh: refresh, events: make(chan func(), 16)}
+	t.run()
+	return 0
+}
+
+// run draws the screen after every key, result and refresh until the
+// user quits
+func (t *tui) run() {
+	keys := make(chan string, 16)
+	go readKeys(os.Stdin, keys)
+	tick := time.NewTicker(t.refresh)
+	defer tick.Stop()
+
+	t.loadJobs()
+	t.loadDomains()
+	for {
+		t.render()
+		select {
+		case k, ok := <-keys:
+			if !ok || t.key(k) {
+				return
+			}
+		case fn := <-t.events:
+			fn()
+		case <-tick.C:
+			t.loadJobs()
+			if time.Since(t.domainsAt) >= tuiDomainsRefresh {
+				t.loadDomains()
+			}
+		}
+	}
+}
+
+// call calls a tool in the background and runs done with the result on
+// the event loop
+func (t *tui) call(tool string, args map[string]any, done func(res cliResult, err error)) {
+	go func() {
+		res, err := t.cl.CallTool(tool, args)
+		t.events <- func() { done(res, err) }
+	}()
+}
+
+// decodeResult decodes a tool call's structured result into v, turning a
+// result the tool marked as an error into an error
+func decodeResult(res cliResult, err error, v any) error {
+	if err != nil {
+		return err
+	}
+	if res.IsError {
+		return errors.New(lastLine(res.Text))
+	}
+	return json.Unmarshal(res.Structured, v)
+}
+
+func (t *tui) loadJobs() {
+	if t.jobsBusy {
+		return
+	}
+	t.jobsBusy = true
+	t.call("list_jobs", map[string]any{}, func(res cliResult, err error) {
+		t.jobsBusy = false
+		var list struct {
+			Jobs []client.Job `json:"jobs"`
+		}
+		if err := decodeResult(res, err, &list); err != nil {
+			t.message = "Jobs: " + err.Error()
+			return
+		}
+		t.jobs = list.Jobs
+		t.selectJob(t.jobID)
+	})
+}
+
+// selectJob selects the job with id, or keeps the selected row when the
+// job is gone, and loads the selected job's output
+func (t *tui) selectJob(id string) {
+	for i, job := range t.jobs {
+		if job.ID == id {
+			t.jobSel = i
+		}
+	}
+	t.jobSel = max(0, min(t.jobSel, len(t.jobs)-1))
+	t.jobID = ""
+	if len(t.jobs) > 0 {
+		t.jobID = t.jobs[t.jobSel].ID
+	}
+	if t.job != nil && t.job.ID != t.jobID {
+		t.job = nil
+	}
+	t.loadJob()
+}
+
+// loadJob fetches the selected job's status and output, unless it has
+// finished since it was last fetched
+func (t *tui) loadJob() {
+	if t.jobID == "" || t.jobBusy || (t.job != nil && t.job.Done()) {
+		return
+	}
+	t.jobBusy = true
+	t.call("check_job_status", map[string]any{"jobId": t.jobID}, func(res cliResult, err error) {
+		t.jobBusy = false
+		var job client.Job
+		if decodeResult(res, err, &job) != nil || job.ID != t.jobID {
+			return
+		}
+		t.job = &job
+	})
+}
+
+func (t *tui) loadDomains() {
+	if t.domainsBusy {
+		return
+	}
+	t.domainsBusy = true
+	t.domainsAt = time.Now()
+	t.call("list_domains", map[string]any{}, func(res cliResult, err error) {
+		t.domainsBusy = false
+		var list struct {
+			Domains []client.Domain `json:"domains"`
+		}
+		if err := decodeResult(res, err, &list); err != nil {
+			t.domainsErr = err.Error()
+			return
+		}
+		t.domainsErr = ""
+		t.domains = list.Domains
+		t.domSel = max(0, min(t.domSel, len(t.domains)-1))
+	})
+}
+
+// key handles a key press and returns true to quit
+func (t *tui) key(k string) bool {
+	if t.confirm != nil {
+		fn := t.confirm
+		t.confirm, t.confirmText = nil, ""
+		if k == "y" || k == "Y" {
+			return fn()
+		}
+		t.message = ""
+		return false
+	}
+
+	switch k {
+	case "q", "ctrl-c":
+		return t.quit()
+	case "tab":
+		t.focus = 1 - t.focus
+	case "up", "k":
+		t.move(-1)
+	case "down", "j":
+		t.move(1)
+	case "pgup":
+		t.move(-10)
+	case "pgdown":
+		t.move(10)
+	case "R", "ctrl-l":
+		t.message = "Refreshing"
+		t.loadJobs()
+		t.loadDomains()
+	case "c":
+		t.cancelJob()
+	case "s":
+		t.checkDomain("fqdnmgr_check", "Checking the status of")
+	case "d":
+		t.checkDomain("fqdnmgr_checkInitDns", "Checking the DNS records of")
+	case "r":
+		t.renewCertificates()
+	}
+	return false
+}
+
+// ask shows a yes/no question; fn runs if it is answered with y
+func (t *tui) ask(question string, fn func() bool) {
+	t.confirmText, t.confirm = question, fn
+}
+
+func (t *tui) quit() bool {
+	if !t.cl.local {
+		return true
+	}
+	for _, job := range t.jobs {
+		if !job.Done() {
+			t.ask("Jobs are still running in this process and lose their output when it exits. Quit anyway?", func() bool { return true })
+			return false
+		}
+	}
+	return true
+}
+
+// move moves the selection of the focused pane by n rows
+func (t *tui) move(n int) {
+	if t.focus == paneDomains {
+		t.domSel = max(0, min(t.domSel+n, len(t.domains)-1))
+		return
+	}
+	if len(t.jobs) == 0 {
+		return
+	}
+	t.jobSel = max(0, min(t.jobSel+n, len(t.jobs)-1))
+	t.selectJob(t.jobs[t.jobSel].ID)
+}
+
+func (t *tui) cancelJob() {
+	if t.focus != paneJobs || len(t.jobs) == 0 {
+		t.message = "Select a job to cancel in the jobs pane"
+		return
+	}
+	job := t.jobs[t.jobSel]
+	if job.Done() {
+		t.message = fmt.Sprintf("Job %s has already finished", shortID(job.ID))
+		return
+	}
+	t.ask(fmt.Sprintf("Cancel job %s (%s)?", shortID(job.ID), job.Tool), func() bool {
+		t.message = fmt.Sprintf("Cancelling job %s", shortID(job.ID))
+		t.call("cancel_job", map[string]any{"jobId": job.ID}, func(res cliResult, err error) {
+			var cancelled client.Job
+			if err := decodeResult(res, err, &cancelled); err != nil {
+				t.message = err.Error()
+				return
+			}
+			t.message = fmt.Sprintf("Job %s cancelled, now %s", shortID(job.ID), cancelled.Status)
+			if t.jobID == job.ID {
+				t.job = &cancelled
+			}
+			t.loadJobs()
+		})
+		return false
+	})
+}
+
+// checkDomain runs a sync check tool on the selected domain and shows
+// the last line it printed
+func (t *tui) checkDomain(tool, doing string) {
+	if t.focus != paneDomains || len(t.domains) == 0 {
+		t.message = "Select a domain in the domains pane first (tab)"
+		return
+	}
+	fqdn := t.domains[t.domSel].Domain
+	t.message = fmt.Sprintf("%s %s", doing, fqdn)
+	t.call(tool, map[string]any{"fqdn": fqdn}, func(res cliResult, err error) {
+		var run client.CommandResult
+		if err := decodeResult(res, err, &run); err != nil {
+			t.message = fmt.Sprintf("%s: %v", fqdn, err)
+			return
+		}
+		if run.OK() {
+			t.message = fmt.Sprintf("%s: %s", fqdn, lastLine(run.Stdout))
+		} else {
+			t.message = fmt.Sprintf("%s: exit code %d: %s", fqdn, run.ExitCode, lastLine(run.Stdout+"\n"+run.Stderr))
+		}
+		t.loadDomains()
+	})
+}
+
+func (t *tui) renewCertificates() {
+	t.ask("Renew every certificate that is due with a2certrenew?", func() bool {
+		t.message = "Starting a2certrenew"
+		t.call("a2certrenew", map[string]any{}, func(res cliResult, err error) {
+			var job client.Job
+			if err := decodeResult(res, err, &job); err != nil {
+				t.message = "a2certrenew: " + err.Error()
+				return
+			}
+			t.message = fmt.Sprintf("Started a2certrenew as job %s", shortID(job.ID))
+			t.focus = paneJobs
+			t.jobID = job.ID
+			t.loadJobs()
+		})
+		return false
+	})
+}
+
+// render draws the whole screen: the jobs, the selected job's output, the
+// domains, and a status line with the keys
+func (t *tui) render() {
+	w, h, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		w, h = 80, 24
+	}
+
+	// Header, three section titles, two table headers and the footer
+	rows := max(3, h-8)
+	jobRows := max(1, rows*3/10)
+	domRows := max(1, rows*3/10)
+	outRows := max(1, rows-jobRows-domRows)
+
+	var lines []string
+	add := func(style, text string) {
+		lines = append(lines, style+fit(text, w)+sgrReset)
+	}
+
+	running, queued := 0, 0
+	for _, job := range t.jobs {
+		switch job.Status {
+		case client.JobRunning:
+			running++
+		case client.JobQueued:
+			queued++
+		}
+	}
+	clock := time.Now().Format("15:04:05")
+	add(sgrReverse, fit(fmt.Sprintf(" a2cmds-mcp · %s · %d running, %d queued", t.cl.server, running, queued), max(0, w-len(clock)-1))+clock)
+
+	// Jobs
+	add(t.titleStyle(paneJobs), "── Jobs ")
+	add(sgrDim, fmt.Sprintf(tuiJobRow, "ID", "TOOL", "STATUS", "EXIT", "HOST", "AGE"))
+	t.jobTop = scrollTop(t.jobTop, t.jobSel, jobRows, len(t.jobs))
+	for i := t.jobTop; i < t.jobTop+jobRows; i++ {
+		switch {
+		case i < len(t.jobs):
+			job := t.jobs[i]
+			add(t.rowStyle(paneJobs, i == t.jobSel, jobStyle(job)), jobRow(job))
+		case i == 0:
+			add(sgrDim, "No jobs")
+		default:
+			add("", "")
+		}
+	}
+
+	// The selected job's output
+	title := "── Output "
+	if job := t.job; job != nil {
+		title = fmt.Sprintf("── Output of %s (%s, %s) ", shortID(job.ID), job.Tool, job.Status)
+	}
+	add(sgrCyan, title)
+	for _, line := range t.outputLines(outRows) {
+		lines = append(lines, line.style+fit(line.text, w)+sgrReset)
+	}
+
+	// Domains
+	add(t.titleStyle(paneDomains), "── Domains ")
+	add(sgrDim, fmt.Sprintf(tuiDomainRow, "DOMAIN", "STATUS", "REGISTRAR", "DNS", "CERT", "EXPIRES", "DAYS"))
+	t.domTop = scrollTop(t.domTop, t.domSel, domRows, len(t.domains))
+	for i := t.domTop; i < t.domTop+domRows; i++ {
+		switch {
+		case i == t.domTop && t.domainsErr != "":
+			add(sgrRed, "Cannot list domains: "+t.domainsErr)
+		case i < len(t.domains):
+			d := t.domains[i]
+			add(t.rowStyle(paneDomains, i == t.domSel, domainStyle(d)), domainRow(d))
+		case i == 0 && t.domainsBusy:
+			add(sgrDim, "Loading")
+		case i == 0:
+			add(sgrDim, "No domains")
+		default:
+			add("", "")
+		}
+	}
+
+	// Footer
+	if t.confirmText != "" {
+		add(sgrYellow+sgrBold, t.confirmText+" (y/n)")
+	} else {
+		add("", t.message)
+	}
+	add(sgrDim, "tab pane  ↑↓ select  c cancel job  s check domain  d check DNS  r renew certificates  R refresh  q quit")
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range lines[:min(len(lines), h)] {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line)
+	}
+	b.WriteString("\x1b[J")
+	os.Stdout.WriteString(b.String())
+}
+
+type tuiLine struct {
+	style, text string
+}
+
+// outputLines returns the last n lines of the selected job's output and
+// stderr, padded to n
+func (t *tui) outputLines(n int) []tuiLine {
+	var out []tuiLine
+	job := t.job
+	switch {
+	case t.jobID == "":
+	case job == nil:
+		out = append(out, tuiLine{sgrDim, "Loading"})
+	default:
+		for _, line := range screenLines(job.Output) {
+			out = append(out, tuiLine{"", line})
+		}
+		for _, line := range screenLines(job.Stderr) {
+			out = append(out, tuiLine{sgrRed, line})
+		}
+		switch {
+		case job.Prompt != nil:
+			out = append(out, tuiLine{sgrYellow, fmt.Sprintf("Waiting for input: %s (answer with a2cmds-mcp job %s -follow)", strings.TrimSpace(job.Prompt.Text), job.ID)})
+		case job.Status == client.JobQueued && job.WaitingFor != "":
+			out = append(out, tuiLine{sgrDim, "Waiting for " + job.WaitingFor})
+		}
+	}
+	if len(out) > n {
+		out = out[len(out)-n:]
+	}
+	for len(out) < n {
+		out = append(out, tuiLine{})
+	}
+	return out
+}
+
+func (t *tui) titleStyle(p tuiPane) string {
+	if t.focus == p {
+		return sgrCyan + sgrBold
+	}
+	return sgrCyan
+}
+
+// rowStyle marks the selected row: in reverse video in the focused pane,
+// in bold in the other
+func (t *tui) rowStyle(p tuiPane, selected bool, style string) string {
+	switch {
+	case !selected:
+		return style
+	case t.focus == p:
+		return style + sgrReverse
+	default:
+		return style + sgrBold
+	}
+}
+
+func jobRow(job client.Job) string {
+	exit, host := "", job.Host
+	if job.ExitCode != nil {
+		exit = fmt.Sprint(*job.ExitCode)
+	}
+	if host == "" {
+		host = "local"
+	}
+	age := time.Since(job.QueuedAt).Round(time.Second).String()
+	switch {
+	case job.Prompt != nil:
+		age += ", waiting for input"
+	case job.Cancelled:
+		age += ", cancelled"
+	case job.ParentID != "":
+		age += ", step of " + shortID(job.ParentID)
+	}
+	return fmt.Sprintf(tuiJobRow, shortID(job.ID), job.Tool, job.Status, exit, host, age)
+}
+
+func jobStyle(job client.Job) string {
+	switch {
+	case job.Prompt != nil:
+		return sgrYellow + sgrBold
+	case job.Status == client.JobRunning:
+		return sgrYellow
+	case job.Status == client.JobFailed:
+		return sgrRed
+	case job.Status == client.JobCompleted:
+		return sgrGreen
+	}
+	return sgrDim
+}
+
+func domainRow(d client.Domain) string {
+	dns, days := "-", ""
+	if d.DNSInit != nil {
+		dns = "no"
+		if *d.DNSInit {
+			dns = "yes"
+		}
+	}
+	if d.DaysLeft != nil {
+		days = fmt.Sprint(*d.DaysLeft)
+	}
+	return fmt.Sprintf(tuiDomainRow, d.Domain, d.Status, d.Registrar, dns, d.CertDate, d.CertExpires, days)
+}
+
+func domainStyle(d client.Domain) string {
+	switch {
+	case d.DaysLeft != nil && *d.DaysLeft < 0:
+		return sgrRed + sgrBold
+	case d.RenewalDue:
+		return sgrYellow
+	case d.Status != "owned":
+		return sgrDim
+	}
+	return ""
+}
+
+// scrollTop returns the first row to show so that sel is among the rows
+// shown
+func scrollTop(top, sel, rows, total int) int {
+	if sel < top {
+		top = sel
+	}
+	if sel >= top+rows {
+		top = sel - rows + 1
+	}
+	return max(0, min(top, total-rows))
+}
+
+// fit cuts or pads s to w columns
+func fit(s string, w int) string {
+	if n := utf8.RuneCountInString(s); n < w {
+		return s + strings.Repeat(" ", w-n)
+	}
+	return string([]rune(s)[:max(0, w)])
+}
+
+// screenLines splits script output into lines without escape sequences or
+// other control characters, which would break the screen
+func screenLines(s string) []string {
+	s = ansiEscape.ReplaceAllString(strings.TrimRight(s, "\n"), "")
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Map(func(r rune) rune {
+			switch {
+			case r == '\t':
+				return ' '
+			case r < ' ' || r == 0x7f:
+				return -1
+			}
+			return r
+		}, line)
+	}
+	return lines
+}
+
+// lastLine returns the last non-empty line of s
+func lastLine(s string) string {
+	lines := screenLines(s)
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// tuiKeys names the escape sequences of the keys the tui uses
+var tuiKeys = []struct{ seq, name string }{
+	{"\x1b[A", "up"}, {"\x1bOA", "up"},
+	{"\x1b[B", "down"}, {"\x1bOB", "down"},
+	{"\x1b[5~", "pgup"}, {"\x1b[6~", "pgdown"},
+}
+
+// readKeys sends the names of the keys pressed until stdin closes
+func readKeys(r io.Reader, keys chan<- string) {
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		for _, k := range parseKeys(string(buf[:n])) {
+			keys <- k
+		}
+	}
+}
+
+// parseKeys turns raw terminal input into key names: printable
+// characters stand for themselves
+func parseKeys(in string) []string {
+	var keys []string
+next:
+	for in != "" {
+		for _, k := range tuiKeys {
+			if strings.HasPrefix(in, k.seq) {
+				keys = append(keys, k.name)
+				in = in[len(k.seq):]
+				continue next
+			}
+		}
+		switch c := in[0]; {
+		case c == 0x1b && len(in) > 2 && (in[1] == '[' || in[1] == 'O'):
+			// Skip other escape sequences up to their final byte
+			i := 2
+			for i < len(in) && (in[i] < '@' || in[i] > '~') {
+				i++
+			}
+			in = in[min(i+1, len(in)):]
+			continue
+		case c == 0x1b:
+			keys = append(keys, "esc")
+		case c == 3:
+			keys = append(keys, "ctrl-c")
+		case c == 12:
+			keys = append(keys, "ctrl-l")
+		case c == '\t':
+			keys = append(keys, "tab")
+		case c == '\r' || c == '\n':
+			keys = append(keys, "enter")
+		default:
+			r, size := utf8.DecodeRuneInString(in)
+			if r >= ' ' {
+				keys = append(keys, string(r))
+			}
+			in = in[size:]
+			continue
+		}
+		in = in[1:]
+	}
+	return keys
+}
@@ -1,6 +1,9 @@
 #!/bin/bash
 if [ $# -gt 0 ] && [ -d "$1" ]; then
     DMS_DIR="$1"
+elif [ -n "$DMS_DIR" ] && [ -d "$DMS_DIR" ]; then
+    # Set by the caller's environment (e.g. the MCP server)
+    :
 else
     # Default to standard path when no argument provided or invalid argument
     if [ -d "/opt/compose/docker-mailserver" ]; then
@@ -2406,8 +2406,9 @@ check_dns_propagation() {
 }
 
 # List all domains for a registrar
-# Usage: list [REGISTRAR] [local|remote]
+# Usage: list [-l] [REGISTRAR] [local|remote]
 #   No args     - list all local domains with their status and registrar (machine parsable)
+#   -l          - with no args, also print dns_init and cert_date
 #   REGISTRAR local  - query the local domains DB for domains associated with REGISTRAR
 #   REGISTRAR remote - use provider API to fetch domains and save only non-free statuses to DB
 list() {
@@ -2419,6 +2420,13 @@ list() {
         ensure_domains_db
 
         local rows
+        if [ "$LONG_LIST" = true ]; then
+            rows=$(sqlite3 "$DOMAINS_DB_PATH" "SELECT domain, status, registrar, dns_init, cert_date FROM domains ORDER BY domain;" 2>/dev/null || true)
+            # Machine-parsable output: domain|status|registrar|dns_init|cert_date
+            [ -n "$rows" ] && printf "%s\n" "$rows"
+            return 0
+        fi
+
         rows=$(sqlite3 "$DOMAINS_DB_PATH" "SELECT domain, status, registrar FROM domains ORDER BY domain;" 2>/dev/null || true)
 
         if [ -z "$rows" ]; then
@@ -2434,6 +2442,11 @@ list() {
         return 0
     fi
 
+    if [ "$LONG_LIST" = true ]; then
+        echo "Error: -l lists all local domains and takes no REGISTRAR" >&2
+        return 1
+    fi
+
     # If registrar provided, mode is required
     if [ -z "$mode" ]; then
         echo "Error: when REGISTRAR is specified, mode (local|remote) is required" >&2
@@ -2785,12 +2798,17 @@ case "$FUNCTION_NAME" in
         check_init_dns_propagation "$FQDN" "$WAN_IP"
         ;;
     "list")
+        LONG_LIST=false
+        for arg in "$@"; do
+            [ "$arg" = "-l" ] && LONG_LIST=true
+        done
+        set -- $(printf '%s\n' "$@" | grep -v '^-l$')
         if [ $# -eq 0 ]; then
             # No arguments - list all local domains
             list
         elif [ $# -eq 1 ]; then
             echo "Error: when REGISTRAR is specified, mode (local|remote) is required"
-            echo "Usage: $0 list [REGISTRAR] [local|remote]"
+            echo "Usage: $0 list [-l] [REGISTRAR] [local|remote]"
             exit 1
         else
             REGISTRAR="$1"